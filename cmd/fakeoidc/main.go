@@ -0,0 +1,41 @@
+package main
+
+import (
+	"coffee/pkg/oidc/oidctest"
+	"flag"
+	"log"
+	"net/http"
+)
+
+// Локальный провайдер OpenID Connect для разработки и проверки входа через
+// провайдеров без Google. Страница входа сразу «входит» пользователем из
+// флагов или из параметра login_hint.
+//
+//	go run ./cmd/fakeoidc -addr :9000 -email user@example.com
+//
+// и в .env API:
+//
+//	OIDC_PROVIDERS=fake
+//	OIDC_FAKE_ISSUER=http://localhost:9000
+//	OIDC_FAKE_CLIENT_ID=coffee
+//	OIDC_FAKE_CLIENT_SECRET=secret
+func main() {
+	addr := flag.String("addr", ":9000", "адрес сервера")
+	issuer := flag.String("issuer", "http://localhost:9000", "issuer, внешний адрес сервера")
+	clientID := flag.String("client-id", "coffee", "client_id")
+	clientSecret := flag.String("client-secret", "secret", "client_secret, пустой — публичный клиент")
+	email := flag.String("email", "user@example.com", "email пользователя")
+	name := flag.String("name", "Test User", "имя пользователя")
+	verified := flag.Bool("email-verified", true, "подтвержден ли email")
+	flag.Parse()
+
+	provider, err := oidctest.New(*issuer, *clientID, *clientSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	provider.Email = *email
+	provider.Name = *name
+	provider.EmailVerified = *verified
+	log.Printf("fake OIDC provider %s, client_id %s", *issuer, *clientID)
+	log.Fatal(http.ListenAndServe(*addr, provider.Handler()))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"coffee/configs"
+	"coffee/internal/coffee"
+	"coffee/internal/media"
+	"coffee/pkg/db"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+// Сверяет файлы в static/images с таблицей coffees и удаляет сиротские файлы
+// старше grace-периода.
+//
+//	go run ./cmd/gc -dry-run
+func main() {
+	configs.LoadEnv()
+	// Сборщику не нужен секрет подписи: он ничего не подписывает.
+	conf := &configs.Config{
+		Db:      configs.LoadDbConfig(),
+		Gc:      configs.LoadGcConfig(),
+		Storage: configs.LoadStorageConfig(),
+	}
+	dryRun := flag.Bool("dry-run", false, "только отчет, без удаления")
+	grace := flag.Duration("grace", conf.Gc.GracePeriod, "не удалять файлы моложе указанного срока")
+	flag.Parse()
+
+	database := db.NewDb(conf)
+	storageBackend, err := media.NewBackend(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mediaService := media.NewMediaService(media.NewBlobRepository(database), storageBackend, conf.Storage)
+	gc := coffee.NewGarbageCollector(coffee.NewCoffeeRepository(database), mediaService, *grace)
+	report, err := gc.Run(*dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal(err)
+	}
+}
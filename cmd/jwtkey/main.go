@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"log"
+	"os"
+)
+
+// Создает закрытый ключ для подписи токенов в формате PKCS#8 PEM.
+//
+//	go run ./cmd/jwtkey -alg EdDSA -out keys/access-2026-10.pem
+func main() {
+	algorithm := flag.String("alg", "EdDSA", "алгоритм: RS256 или EdDSA")
+	out := flag.String("out", "", "файл ключа")
+	flag.Parse()
+
+	if *out == "" {
+		log.Fatal("укажите -out")
+	}
+	var key crypto.PrivateKey
+	var err error
+	switch *algorithm {
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		log.Fatalf("неизвестный алгоритм %q", *algorithm)
+	}
+	if err != nil {
+		log.Fatalf("не удалось создать ключ: %v", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		log.Fatalf("не удалось сохранить ключ: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(*out, data, 0o600); err != nil {
+		log.Fatalf("не удалось сохранить ключ: %v", err)
+	}
+	log.Printf("ключ %s записан в %s", *algorithm, *out)
+}
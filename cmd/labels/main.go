@@ -0,0 +1,78 @@
+package main
+
+import (
+	"coffee/configs"
+	"coffee/internal/coffee"
+	"coffee/internal/label"
+	"coffee/pkg/db"
+	"coffee/pkg/qr"
+	"flag"
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+)
+
+// Рисует PDF-лист этикеток для выбранных кофе.
+//
+//	go run ./cmd/labels -layout a4-3x8 -out labels.pdf espresso latte
+//	go run ./cmd/labels -layout roll-62 -bleed 2 -all
+func main() {
+	layoutName := flag.String("layout", "a4-3x8", "раскладка листа")
+	bleed := flag.Float64("bleed", 0, "вылеты, мм")
+	copies := flag.Int("copies", 1, "копий каждой этикетки")
+	background := flag.String("background", "", "цвет фона этикетки, #rrggbb")
+	location := flag.String("location", "", "метка места для учета сканов")
+	out := flag.String("out", "labels.pdf", "файл результата")
+	all := flag.Bool("all", false, "все кофе из базы")
+	list := flag.Bool("layouts", false, "показать раскладки и выйти")
+	flag.Parse()
+
+	if *list {
+		for _, layout := range label.Layouts() {
+			fmt.Printf("%-12s %s\n", layout.Name, layout.Description)
+		}
+		return
+	}
+	layout, ok := label.GetLayout(*layoutName)
+	if !ok {
+		log.Fatalf("неизвестная раскладка %q, см. -layouts", *layoutName)
+	}
+	var bg color.RGBA
+	if *background != "" {
+		var err error
+		if bg, err = qr.ParseColor(*background); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if !*all && flag.NArg() == 0 {
+		log.Fatal("укажите slug кофе или -all")
+	}
+
+	configs.LoadEnv()
+	conf := &configs.Config{Db: configs.LoadDbConfig(), Qr: configs.LoadQrConfig()}
+	repo := coffee.NewCoffeeRepository(db.NewDb(conf))
+	service := label.NewLabelService(repo, conf)
+	opts := label.Options{
+		Layout:     layout,
+		Bleed:      *bleed,
+		Copies:     *copies,
+		Background: bg,
+		Location:   *location,
+	}
+
+	var data []byte
+	var err error
+	if *all {
+		data, err = service.Render(repo.GetAllCoffee(int(repo.Count()), 0), opts)
+	} else {
+		data, err = service.RenderSlugs(flag.Args(), opts)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("этикетки записаны в %s", *out)
+}
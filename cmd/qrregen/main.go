@@ -0,0 +1,23 @@
+package main
+
+import (
+	"coffee/configs"
+	"coffee/internal/coffee"
+	"coffee/pkg/db"
+	"log"
+)
+
+// Перерисовывает QR-коды всех кофе по PUBLIC_BASE_URL и QR_PATH_TEMPLATE.
+// Запускать после смены домена.
+//
+//	go run ./cmd/qrregen
+func main() {
+	configs.LoadEnv()
+	conf := &configs.Config{Db: configs.LoadDbConfig(), Qr: configs.LoadQrConfig()}
+	repo := coffee.NewCoffeeRepository(db.NewDb(conf))
+	updated, err := coffee.RegenerateQRCodes(repo, conf)
+	if err != nil {
+		log.Fatalf("обновлено %d QR-кодов, ошибка: %v", updated, err)
+	}
+	log.Printf("обновлено %d QR-кодов (%s)", updated, conf.Qr.PublicBaseURL)
+}
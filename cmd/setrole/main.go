@@ -0,0 +1,32 @@
+package main
+
+import (
+	"coffee/configs"
+	"coffee/internal/user"
+	"coffee/pkg/db"
+	"flag"
+	"log"
+)
+
+// Назначает роль пользователю. Нужна, чтобы завести первого администратора.
+//
+//	go run ./cmd/setrole -email admin@example.com -role admin
+func main() {
+	email := flag.String("email", "", "email пользователя")
+	role := flag.String("role", string(user.RoleAdmin), "роль: customer, barista, manager или admin")
+	flag.Parse()
+
+	if *email == "" {
+		log.Fatal("укажите -email")
+	}
+	if !user.Role(*role).Valid() {
+		log.Fatalf("неизвестная роль %q", *role)
+	}
+	configs.LoadEnv()
+	conf := &configs.Config{Db: configs.LoadDbConfig()}
+	repo := user.NewUserRepository(db.NewDb(conf))
+	if err := repo.SetRole(*email, user.Role(*role)); err != nil {
+		log.Fatalf("не удалось назначить роль: %v", err)
+	}
+	log.Printf("%s теперь %s", *email, *role)
+}
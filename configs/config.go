@@ -1,15 +1,35 @@
 package configs
 
 import (
+	"coffee/pkg/jwt"
 	"github.com/joho/godotenv"
 	"log"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
-	Db   DbConfig
-	Auth AuthConfig
-	Smtp SmtpConfig
+	Db      DbConfig
+	Auth    AuthConfig
+	Smtp    SmtpConfig
+	Gc      GcConfig
+	Media   MediaConfig
+	Storage StorageConfig
+	Qr      QrConfig
+	Table   TableConfig
+	Login   LoginConfig
+	OIDC    OIDCConfig
+	Server  ServerConfig
+}
+
+// ServerConfig — окружение HTTP-сервера. TrustedProxies — адреса и подсети
+// обратных прокси, которым можно верить в X-Forwarded-For и X-Real-IP.
+type ServerConfig struct {
+	TrustedProxies []netip.Prefix
 }
 
 type SmtpConfig struct {
@@ -22,24 +42,155 @@ type DbConfig struct {
 	DATABASE_URL string
 }
 
+// GcConfig — настройки сборщика сиротских файлов. Interval 0 отключает
+// периодический запуск.
+type GcConfig struct {
+	Interval    time.Duration
+	GracePeriod time.Duration
+}
+
+// MediaConfig — доступ к файлам из static/images. Файлы из PrivateDirs
+// отдаются только по подписанным ссылкам со сроком действия.
+type MediaConfig struct {
+	SigningSecret string
+	PrivateDirs   []string
+	SignedURLTTL  time.Duration
+}
+
+// StorageConfig — прямые загрузки в хранилище. UploadDir — временная
+// область LocalBackend, куда файлы попадают до подтверждения.
+type StorageConfig struct {
+	Backend       string
+	UploadDir     string
+	UploadURLTTL  time.Duration
+	MaxUploadSize int64
+}
+
+// QrConfig — адрес, который кодируется в QR-кодах. В PathTemplate
+// подстановка {slug} заменяется на slug кофе.
+type QrConfig struct {
+	PublicBaseURL string
+	PathTemplate  string
+	// LogoPath — логотип для QR-кодов с параметром logo=true.
+	LogoPath string
+}
+
+// TableConfig — QR-коды столов. Ссылка подписывается SigningSecret,
+// сессия меню живет SessionTTL, после перехода клиент попадает на
+// PublicBaseURL + MenuPath.
+type TableConfig struct {
+	SigningSecret string
+	SessionTTL    time.Duration
+	MenuPath      string
+}
+
+// LoginConfig — защита входа от перебора. Неудачные попытки считаются
+// отдельно по аккаунту и по IP в окне Window. После FreeAttempts неудач
+// каждая следующая попытка ждет BackoffBase, удваивая паузу до BackoffMax;
+// при достижении порога ключ блокируется на LockoutDuration. Store —
+// "memory" для одного узла или "postgres" для нескольких.
+type LoginConfig struct {
+	Store            string
+	Window           time.Duration
+	FreeAttempts     int64
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	AccountThreshold int64
+	IPThreshold      int64
+	LockoutDuration  time.Duration
+	NotifyOnLockout  bool
+}
+
+// OIDCConfig — вход через провайдеров OpenID Connect. Провайдер
+// возвращает пользователя на PublicBaseURL + /auth/oidc/{name}/callback,
+// оттуда API перенаправляет на PublicBaseURL + LoginPath с одноразовым
+// кодом или ошибкой. StateTTL — сколько ждать возвращения от провайдера.
+type OIDCConfig struct {
+	Providers []OIDCProviderConfig
+	LoginPath string
+	StateTTL  time.Duration
+}
+
+type OIDCProviderConfig struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
 type AuthConfig struct {
-	AccessSecret  string
-	RefreshSecret string
+	// AccessKeys и RefreshKeys — ключи подписи токенов. Первым ключом
+	// подписываются новые токены, остальные принимаются до ротации.
+	AccessKeys  *jwt.KeySet
+	RefreshKeys *jwt.KeySet
+	// Issuer и Audience — iss и aud токенов, ClockSkew — допустимое
+	// расхождение часов с другими сервисами. RequireIssuer (по умолчанию
+	// включен) запрещает токены без iss и aud; выключать его стоит только
+	// на время обновления, пока не истекли токены, выпущенные до их
+	// появления.
+	Issuer        string
+	Audience      string
+	RequireIssuer bool
+	ClockSkew     time.Duration
+	// PasswordResetTTL — срок действия ссылки сброса пароля, ссылка ведет
+	// на Qr.PublicBaseURL + PasswordResetPath.
+	PasswordResetTTL  time.Duration
+	PasswordResetPath string
+	// EmailVerifyTTL и EmailVerifyPath — то же для подтверждения email,
+	// EmailChangePath — для подтверждения нового email при смене,
+	// ReauthPath — для подтверждения действия в аккаунте без пароля.
+	EmailVerifyTTL  time.Duration
+	EmailVerifyPath string
+	EmailChangePath string
+	ReauthPath      string
+	// UnverifiedLogin — что можно пользователю с неподтвержденным email:
+	// "allow" — все, "limited" — вход без прав роли, "deny" — вход запрещен.
+	UnverifiedLogin string
+	// TOTPIssuer — название сервиса в приложении-аутентификаторе.
+	// TwoFactorRoles — роли, которые получают права только после
+	// включения TOTP, по умолчанию manager и admin; чтобы не требовать TOTP
+	// ни от кого, укажите none. ChallengeTTL — сколько действует токен второго шага
+	// входа.
+	TOTPIssuer     string
+	TwoFactorRoles []string
+	ChallengeTTL   time.Duration
 }
 
+// LoadConfig читает всю конфигурацию API. Утилитам из cmd, которым не
+// нужны секреты API, достаточно LoadEnv и нужных разделов.
 func LoadConfig() *Config {
-	err := godotenv.Load(".env")
-	if err != nil {
-		log.Println("Error loading .env file, using default config")
-	}
+	LoadEnv()
 
+	accessKeys, refreshKeys := getKeySet("JWT_ACCESS"), getKeySet("JWT_REFRESH")
+	if accessKeys.Shares(refreshKeys) {
+		log.Fatal("JWT_ACCESS and JWT_REFRESH keys must differ")
+	}
+	mediaSecret := getSecret("MEDIA_SIGNING_SECRET", accessKeys, refreshKeys)
+	tableSecret := getSecret("TABLE_SIGNING_SECRET", accessKeys, refreshKeys)
+	if mediaSecret == tableSecret {
+		log.Fatal("MEDIA_SIGNING_SECRET and TABLE_SIGNING_SECRET must differ")
+	}
 	return &Config{
-		Db: DbConfig{
-			DATABASE_URL: os.Getenv("DATABASE_URL"),
-		},
+		Db: LoadDbConfig(),
 		Auth: AuthConfig{
-			AccessSecret:  os.Getenv("TOKEN"),
-			RefreshSecret: os.Getenv("TOKEN"),
+			AccessKeys:    accessKeys,
+			RefreshKeys:   refreshKeys,
+			Issuer:        getString("JWT_ISSUER", "coffee"),
+			Audience:      getString("JWT_AUDIENCE", "coffee-api"),
+			RequireIssuer: getBool("JWT_REQUIRE_ISSUER", true),
+			ClockSkew:     getDuration("JWT_CLOCK_SKEW", 30*time.Second),
+
+			PasswordResetTTL:  getDuration("PASSWORD_RESET_TTL", time.Hour),
+			PasswordResetPath: getString("PASSWORD_RESET_PATH", "/reset-password"),
+			EmailVerifyTTL:    getDuration("EMAIL_VERIFY_TTL", 48*time.Hour),
+			EmailVerifyPath:   getString("EMAIL_VERIFY_PATH", "/verify-email"),
+			EmailChangePath:   getString("EMAIL_CHANGE_PATH", "/confirm-email"),
+			ReauthPath:        getString("REAUTH_PATH", "/confirm-action"),
+			UnverifiedLogin:   getString("UNVERIFIED_LOGIN", "limited"),
+			TOTPIssuer:        getString("TOTP_ISSUER", "Coffee"),
+			TwoFactorRoles:    getList("TWO_FACTOR_REQUIRED_ROLES", []string{"manager", "admin"}),
+			ChallengeTTL:      getDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute),
 		},
 		Smtp: SmtpConfig{
 			SmtpHost: os.Getenv("SMTP_HOST"),
@@ -47,5 +198,240 @@ func LoadConfig() *Config {
 			From:     os.Getenv("SMTP_EMAIL"),
 			Password: os.Getenv("SMTP_PASSWORD"),
 		},
+		Gc: LoadGcConfig(),
+		Media: MediaConfig{
+			SigningSecret: mediaSecret,
+			PrivateDirs:   getList("MEDIA_PRIVATE_DIRS", []string{"drafts", "labels"}),
+			SignedURLTTL:  getDuration("MEDIA_SIGNED_URL_TTL", 15*time.Minute),
+		},
+		Storage: LoadStorageConfig(),
+		Qr:      LoadQrConfig(),
+		Login: LoginConfig{
+			Store:            getString("LOGIN_GUARD_STORE", "memory"),
+			Window:           getDuration("LOGIN_ATTEMPT_WINDOW", time.Hour),
+			FreeAttempts:     getInt64("LOGIN_FREE_ATTEMPTS", 3),
+			BackoffBase:      getDuration("LOGIN_BACKOFF_BASE", time.Second),
+			BackoffMax:       getDuration("LOGIN_BACKOFF_MAX", time.Minute),
+			AccountThreshold: getInt64("LOGIN_LOCKOUT_THRESHOLD", 10),
+			IPThreshold:      getInt64("LOGIN_IP_LOCKOUT_THRESHOLD", 50),
+			LockoutDuration:  getDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+			NotifyOnLockout:  getBool("LOGIN_LOCKOUT_NOTIFY", true),
+		},
+		OIDC: OIDCConfig{
+			Providers: getOIDCProviders(),
+			LoginPath: getString("OIDC_LOGIN_PATH", "/login/callback"),
+			StateTTL:  getDuration("OIDC_STATE_TTL", 10*time.Minute),
+		},
+		Server: ServerConfig{
+			TrustedProxies: getPrefixes("TRUSTED_PROXIES"),
+		},
+		Table: TableConfig{
+			SigningSecret: tableSecret,
+			SessionTTL:    getDuration("TABLE_SESSION_TTL", 4*time.Hour),
+			MenuPath:      getString("TABLE_MENU_PATH", "/coffee"),
+		},
+	}
+}
+
+// LoadEnv подгружает переменные из .env, если файл есть.
+func LoadEnv() {
+	if err := godotenv.Load(".env"); err != nil {
+		log.Println("Error loading .env file, using default config")
+	}
+}
+
+func LoadDbConfig() DbConfig {
+	return DbConfig{
+		DATABASE_URL: os.Getenv("DATABASE_URL"),
+	}
+}
+
+func LoadGcConfig() GcConfig {
+	return GcConfig{
+		Interval:    getDuration("GC_INTERVAL", 0),
+		GracePeriod: getDuration("GC_GRACE_PERIOD", 24*time.Hour),
+	}
+}
+
+func LoadStorageConfig() StorageConfig {
+	return StorageConfig{
+		Backend:       getString("STORAGE_BACKEND", "local"),
+		UploadDir:     getString("STORAGE_UPLOAD_DIR", "static/uploads"),
+		UploadURLTTL:  getDuration("UPLOAD_URL_TTL", 15*time.Minute),
+		MaxUploadSize: getInt64("MAX_UPLOAD_SIZE", 50<<20),
+	}
+}
+
+// LoadQrConfig требует PUBLIC_BASE_URL.
+func LoadQrConfig() QrConfig {
+	return QrConfig{
+		PublicBaseURL: getBaseURL("PUBLIC_BASE_URL"),
+		PathTemplate:  getString("QR_PATH_TEMPLATE", "/coffee/coffee/{slug}"),
+		LogoPath:      getString("QR_LOGO_PATH", "static/brand/logo.png"),
+	}
+}
+
+func getString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getPrefixes читает список подсетей через запятую; одиночный адрес
+// означает подсеть из одного адреса.
+func getPrefixes(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, item := range getList(key, nil) {
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(item)
+			if addrErr != nil {
+				log.Fatalf("Invalid %s entry %q: %v", key, item, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+// getBaseURL читает обязательный публичный адрес сервиса. Без него
+// QR-коды и ссылки из писем указывали бы не туда, поэтому значения по
+// умолчанию нет.
+func getBaseURL(key string) string {
+	value := strings.TrimSuffix(os.Getenv(key), "/")
+	if value == "" {
+		log.Fatalf("%s is required", key)
+	}
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		log.Fatalf("Invalid %s=%q: absolute http(s) URL expected", key, value)
+	}
+	return value
+}
+
+// getSecret читает обязательный секрет подписи. Пустой секрет или секрет,
+// совпадающий с любым ключом HS256 из keySets (включая ключи после
+// ротации), позволил бы подделывать подписи, поэтому без отдельного
+// значения сервис не запускается.
+func getSecret(key string, keySets ...*jwt.KeySet) string {
+	value := os.Getenv(key)
+	if value == "" {
+		log.Fatalf("%s is required", key)
+	}
+	for _, keys := range keySets {
+		if keys.HasSecret([]byte(value)) {
+			log.Fatalf("%s must differ from JWT keys", key)
+		}
+	}
+	return value
+}
+
+func getInt64(key string, fallback int64) int64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %d", key, value, fallback)
+		return fallback
+	}
+	return number
+}
+
+func getBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	flag, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %t", key, value, fallback)
+		return fallback
+	}
+	return flag
+}
+
+func getList(key string, fallback []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid %s=%q, using %s", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// oidcIssuers — адреса известных провайдеров, для остальных нужен
+// OIDC_<NAME>_ISSUER.
+var oidcIssuers = map[string]string{
+	"google": "https://accounts.google.com",
+}
+
+// getOIDCProviders читает провайдеров из OIDC_PROVIDERS и настройки
+// каждого из OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET и _SCOPES.
+func getOIDCProviders() []OIDCProviderConfig {
+	var providers []OIDCProviderConfig
+	for _, name := range getList("OIDC_PROVIDERS", nil) {
+		name = strings.ToLower(name)
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := OIDCProviderConfig{
+			Name:         name,
+			Issuer:       getString(prefix+"ISSUER", oidcIssuers[name]),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			Scopes:       strings.Fields(getString(prefix+"SCOPES", "openid email profile")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			log.Printf("OIDC provider %s needs %sISSUER and %sCLIENT_ID, skipping", name, prefix, prefix)
+			continue
+		}
+		providers = append(providers, provider)
+	}
+	return providers
+}
+
+// getKeySet читает ключи токенов из prefix_ALG и prefix_KEYS (см.
+// jwt.ParseKeySet). Без prefix_KEYS нужен один ключ HS256 в prefix_SECRET.
+func getKeySet(prefix string) *jwt.KeySet {
+	algorithm := getString(prefix+"_ALG", jwt.HS256)
+	spec := os.Getenv(prefix + "_KEYS")
+	if spec == "" {
+		if algorithm != jwt.HS256 {
+			log.Fatalf("%s_KEYS is required for %s", prefix, algorithm)
+		}
+		secret := os.Getenv(prefix + "_SECRET")
+		if secret == "" {
+			log.Fatalf("%s_SECRET or %s_KEYS is required", prefix, prefix)
+		}
+		keys, err := jwt.NewKeySet(jwt.NewHMACKey("", []byte(secret)))
+		if err != nil {
+			log.Fatalf("Invalid %s keys: %v", prefix, err)
+		}
+		return keys
+	}
+	keys, err := jwt.ParseKeySet(algorithm, spec)
+	if err != nil {
+		log.Fatalf("Invalid %s_KEYS: %v", prefix, err)
 	}
+	return keys
 }
@@ -0,0 +1,394 @@
+package account
+
+import (
+	"coffee/internal/auth"
+	"coffee/pkg/jwt"
+	"coffee/pkg/middleware"
+	"coffee/pkg/req"
+	"coffee/pkg/res"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+type AccountHandler struct {
+	AccountService *AccountService
+	AuthService    *auth.AuthService
+}
+
+type AccountHandlerDeps struct {
+	AccountService *AccountService
+	AuthService    *auth.AuthService
+	Auth           *middleware.Auth
+}
+
+func NewAccountHandler(router *http.ServeMux, deps AccountHandlerDeps) {
+	handler := &AccountHandler{
+		AccountService: deps.AccountService,
+		AuthService:    deps.AuthService,
+	}
+	router.Handle("GET /me", middleware.IsAuthed(handler.GetProfile(), deps.Auth))
+	router.Handle("PATCH /me", middleware.IsAuthed(middleware.OwnerOnly(handler.UpdateProfile()), deps.Auth))
+	router.Handle("DELETE /me", middleware.IsAuthed(middleware.OwnerOnly(handler.Delete()), deps.Auth))
+	router.Handle("POST /me/reauth", middleware.IsAuthed(middleware.OwnerOnly(handler.RequestReauth()), deps.Auth))
+	router.Handle("POST /me/password", middleware.IsAuthed(middleware.OwnerOnly(handler.ChangePassword()), deps.Auth))
+	router.Handle("POST /me/email", middleware.IsAuthed(middleware.OwnerOnly(handler.ChangeEmail()), deps.Auth))
+	router.HandleFunc("POST /me/email/confirm", handler.ConfirmEmail())
+	router.Handle("PUT /me/avatar", middleware.IsAuthed(middleware.OwnerOnly(handler.UploadAvatar()), deps.Auth))
+	router.Handle("DELETE /me/avatar", middleware.IsAuthed(middleware.OwnerOnly(handler.DeleteAvatar()), deps.Auth))
+	router.Handle("GET /me/sessions", middleware.IsAuthed(middleware.OwnerOnly(handler.Sessions()), deps.Auth))
+	router.Handle("DELETE /me/sessions/{id}", middleware.IsAuthed(middleware.OwnerOnly(handler.RevokeSession()), deps.Auth))
+}
+
+func contextEmail(r *http.Request) string {
+	email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+	return email
+}
+
+// writeReauthError отвечает на ошибку проверки пароля и кода TOTP.
+func writeReauthError(w http.ResponseWriter, err error) {
+	var tooMany *auth.TooManyAttemptsError
+	switch {
+	case errors.Is(err, auth.ErrWrongPassword), errors.Is(err, auth.ErrInvalidCode),
+		errors.Is(err, auth.ErrPasswordNotSet), errors.Is(err, auth.ErrInvalidReauthToken):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, auth.ErrCodeRequired):
+		http.Error(w, err.Error(), http.StatusPreconditionRequired)
+	case errors.As(err, &tooMany):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Профиль
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 200 {object} ProfileResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Router /me [get]
+func (handler *AccountHandler) GetProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, err := handler.AccountService.Profile(contextEmail(r))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		res.Json(w, NewProfileResponse(profile), http.StatusOK)
+	}
+}
+
+// @Summary Изменить профиль
+// @Tags account
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body ProfileUpdateRequest true "Изменяемые поля"
+// @Success 200 {object} ProfileResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Router /me [patch]
+func (handler *AccountHandler) UpdateProfile() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[ProfileUpdateRequest](&w, r)
+		if err != nil {
+			return
+		}
+		profile, err := handler.AccountService.UpdateProfile(contextEmail(r), body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, NewProfileResponse(profile), http.StatusOK)
+	}
+}
+
+// @Summary Подтверждение по email
+// @Description Для аккаунта без пароля (созданного при входе через провайдера): отправляет на email ссылку на страницу REAUTH_PATH с токеном. Токен передается как reauthToken вместо пароля при смене пароля или email, удалении аккаунта и отключении TOTP; действует 15 минут и один раз.
+// @Tags account
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 202 {string} string "Accepted"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "account has a password"
+// @Router /me/reauth [post]
+func (handler *AccountHandler) RequestReauth() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := handler.AuthService.RequestReauth(contextEmail(r))
+		if errors.Is(err, auth.ErrPasswordSet) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// @Summary Сменить пароль
+// @Description Меняет пароль по текущему (и коду TOTP, если он включен). Аккаунт без пароля вместо текущего пароля передает reauthToken из письма POST /me/reauth. Все входы завершаются, в ответе — новые токены.
+// @Tags account
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body PasswordChangeRequest true "Текущий и новый пароль"
+// @Success 200 {object} PasswordChangeResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "current password is incorrect"
+// @Failure 428 {string} string "two-factor code is required"
+// @Failure 429 {string} string "too many login attempts"
+// @Router /me/password [post]
+func (handler *AccountHandler) ChangePassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[PasswordChangeRequest](&w, r)
+		if err != nil {
+			return
+		}
+		changed, err := handler.AuthService.ChangePassword(contextEmail(r), auth.Reauth{
+			Password: body.CurrentPassword,
+			Token:    body.ReauthToken,
+			Code:     body.Code,
+			Client:   auth.ClientInfo{UserAgent: r.UserAgent(), IP: req.ClientIP(r)},
+		}, body.NewPassword)
+		if err != nil {
+			writeReauthError(w, err)
+			return
+		}
+		tokens, err := handler.AuthService.IssueTokens(changed, auth.ClientInfo{
+			UserAgent: r.UserAgent(),
+			IP:        req.ClientIP(r),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, PasswordChangeResponse{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+		}, http.StatusOK)
+	}
+}
+
+// @Summary Сменить email
+// @Description Отправляет ссылку подтверждения на новый адрес (страница EMAIL_CHANGE_PATH). Email меняется после POST /me/email/confirm, до этого вход идет по старому.
+// @Tags account
+// @Accept json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body EmailChangeRequest true "Новый email и пароль"
+// @Success 202 {string} string "Accepted"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "current password is incorrect"
+// @Failure 409 {string} string "user already exists"
+// @Failure 428 {string} string "two-factor code is required"
+// @Failure 429 {string} string "too many login attempts"
+// @Router /me/email [post]
+func (handler *AccountHandler) ChangeEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[EmailChangeRequest](&w, r)
+		if err != nil {
+			return
+		}
+		err = handler.AuthService.RequestEmailChange(contextEmail(r), auth.Reauth{
+			Password: body.Password,
+			Token:    body.ReauthToken,
+			Code:     body.Code,
+			Client:   auth.ClientInfo{UserAgent: r.UserAgent(), IP: req.ClientIP(r)},
+		}, body.Email)
+		if errors.Is(err, auth.ErrUserExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			writeReauthError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// @Summary Подтвердить новый email
+// @Description Меняет email по токену из письма и завершает все входы.
+// @Tags account
+// @Accept json
+// @Param request body EmailConfirmRequest true "Токен из письма"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {string} string "invalid or expired token"
+// @Failure 409 {string} string "user already exists"
+// @Router /me/email/confirm [post]
+func (handler *AccountHandler) ConfirmEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[EmailConfirmRequest](&w, r)
+		if err != nil {
+			return
+		}
+		err = handler.AuthService.ConfirmEmailChange(body.Token)
+		if errors.Is(err, auth.ErrInvalidActionToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if errors.Is(err, auth.ErrUserExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Загрузить аватар
+// @Tags account
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param avatar formData file true "JPEG, PNG или WebP до 5 МБ"
+// @Success 200 {object} ProfileResponse
+// @Failure 400 {string} string "avatar must be a JPEG, PNG or WebP image"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /me/avatar [put]
+func (handler *AccountHandler) UploadAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxAvatarSize+1<<10)
+		if err := r.ParseMultipartForm(maxAvatarSize); err != nil {
+			http.Error(w, "Ошибка при обработке формы: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		file, _, err := r.FormFile("avatar")
+		if err != nil {
+			http.Error(w, "Ошибка получения файла avatar: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		profile, err := handler.AccountService.SetAvatar(contextEmail(r), file)
+		if errors.Is(err, ErrUnsupportedAvatar) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, NewProfileResponse(profile), http.StatusOK)
+	}
+}
+
+// @Summary Удалить аватар
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 200 {object} ProfileResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Router /me/avatar [delete]
+func (handler *AccountHandler) DeleteAvatar() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		profile, err := handler.AccountService.RemoveAvatar(contextEmail(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, NewProfileResponse(profile), http.StatusOK)
+	}
+}
+
+// @Summary Удалить аккаунт
+// @Description Удаляет аккаунт окончательно вместе с входами, API-ключами и аватаром. Нужен пароль (или reauthToken из письма POST /me/reauth для аккаунта без пароля) и код TOTP, если он включен.
+// @Tags account
+// @Accept json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body AccountDeleteRequest true "Подтверждение"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "current password is incorrect"
+// @Failure 428 {string} string "two-factor code is required"
+// @Failure 429 {string} string "too many login attempts"
+// @Router /me [delete]
+func (handler *AccountHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[AccountDeleteRequest](&w, r)
+		if err != nil {
+			return
+		}
+		err = handler.AccountService.Delete(contextEmail(r), auth.Reauth{
+			Password: body.Password,
+			Token:    body.ReauthToken,
+			Code:     body.Code,
+			Client:   auth.ClientInfo{UserAgent: r.UserAgent(), IP: req.ClientIP(r)},
+		})
+		if err != nil {
+			writeReauthError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Активные входы
+// @Description Устройства, на которых выполнен вход, с User-Agent, IP и временем последней активности (обновляется при обмене refresh-токена). Недавно активные первыми.
+// @Tags account
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 200 {array} SessionResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Router /me/sessions [get]
+func (handler *AccountHandler) Sessions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jti := ""
+		if claims, _ := r.Context().Value(middleware.ContextClaimsKey).(*jwt.JWTData); claims != nil {
+			jti = claims.ID
+		}
+		sessions, current, err := handler.AuthService.Sessions(contextEmail(r), jti)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data := make([]SessionResponse, 0, len(sessions))
+		for i := range sessions {
+			data = append(data, NewSessionResponse(&sessions[i], current))
+		}
+		res.Json(w, data, http.StatusOK)
+	}
+}
+
+// @Summary Завершить вход
+// @Description Отзывает refresh token входа и выданный вместе с ним access token. Текущий вход тоже можно завершить.
+// @Tags account
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID входа"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "session not found"
+// @Router /me/sessions/{id} [delete]
+func (handler *AccountHandler) RevokeSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, auth.ErrSessionNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		err = handler.AuthService.RevokeSession(contextEmail(r), uint(id))
+		if errors.Is(err, auth.ErrSessionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
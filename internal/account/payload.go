@@ -0,0 +1,95 @@
+package account
+
+import (
+	"coffee/internal/auth"
+	"coffee/internal/media"
+	"coffee/internal/user"
+	"time"
+)
+
+type ProfileResponse struct {
+	ID            uint      `json:"id" example:"1"`
+	Name          string    `json:"name" example:"John"`
+	Email         string    `json:"email" example:"user@example.com"`
+	Role          user.Role `json:"role" example:"customer"`
+	EmailVerified bool      `json:"emailVerified" example:"true"`
+	// PendingEmail — новый email, который ждет подтверждения
+	PendingEmail     string    `json:"pendingEmail,omitempty" example:"new@example.com"`
+	TwoFactorEnabled bool      `json:"twoFactorEnabled" example:"false"`
+	AvatarURL        string    `json:"avatarUrl,omitempty" example:"/coffees/static/images/avatars/3f1c...e9.jpg"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+func NewProfileResponse(u *user.User) ProfileResponse {
+	profile := ProfileResponse{
+		ID:               u.ID,
+		Name:             u.Name,
+		Email:            u.Email,
+		Role:             u.Role,
+		EmailVerified:    u.EmailVerified(),
+		PendingEmail:     u.PendingEmail,
+		TwoFactorEnabled: u.TwoFactorEnabled(),
+		CreatedAt:        u.CreatedAt,
+	}
+	if u.Avatar != "" {
+		profile.AvatarURL = "/coffees/static/images/" + media.AvatarsDir + "/" + u.Avatar
+	}
+	return profile
+}
+
+type ProfileUpdateRequest struct {
+	Name *string `json:"name" example:"John" validate:"omitempty,min=1,max=50"`
+}
+
+type PasswordChangeRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required_without=ReauthToken"`
+	// ReauthToken — токен из письма POST /me/reauth вместо текущего пароля
+	// для аккаунта без пароля
+	ReauthToken string `json:"reauthToken,omitempty"`
+	NewPassword string `json:"newPassword" validate:"required,min=8,max=72"`
+	// Code — код TOTP или код восстановления, если включен двухфакторный вход
+	Code string `json:"code,omitempty" example:"123456"`
+}
+
+type PasswordChangeResponse struct {
+	AccessToken  string `json:"accessToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refreshToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+}
+
+type EmailChangeRequest struct {
+	Email       string `json:"email" example:"new@example.com" validate:"required,email,max=50"`
+	Password    string `json:"password" validate:"required_without=ReauthToken"`
+	ReauthToken string `json:"reauthToken,omitempty"`
+	Code        string `json:"code,omitempty" example:"123456"`
+}
+
+type EmailConfirmRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type AccountDeleteRequest struct {
+	Password    string `json:"password" validate:"required_without=ReauthToken"`
+	ReauthToken string `json:"reauthToken,omitempty"`
+	Code        string `json:"code,omitempty" example:"123456"`
+}
+
+type SessionResponse struct {
+	ID        uint   `json:"id" example:"7"`
+	UserAgent string `json:"userAgent" example:"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X)"`
+	IP        string `json:"ip" example:"203.0.113.7"`
+	// Current — вход, которым сделан этот запрос
+	Current    bool      `json:"current" example:"true"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+}
+
+func NewSessionResponse(session *auth.Session, currentFamily string) SessionResponse {
+	return SessionResponse{
+		ID:         session.ID,
+		UserAgent:  session.UserAgent,
+		IP:         session.IP,
+		Current:    currentFamily != "" && session.FamilyID == currentFamily,
+		CreatedAt:  session.CreatedAt,
+		LastSeenAt: session.LastSeenAt,
+	}
+}
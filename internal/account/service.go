@@ -0,0 +1,130 @@
+package account
+
+import (
+	"bytes"
+	"coffee/internal/apikey"
+	"coffee/internal/auth"
+	"coffee/internal/media"
+	"coffee/internal/user"
+	"coffee/pkg/db"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+)
+
+const maxAvatarSize = 5 << 20 // 5 MB
+
+var ErrUnsupportedAvatar = errors.New("avatar must be a JPEG, PNG or WebP image")
+
+// avatarTypes — допустимые типы аватаров и расширения файлов для них.
+var avatarTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+// AccountService — профиль пользователя. Смена пароля, email и удаление
+// аккаунта проверяются в auth.AuthService.
+type AccountService struct {
+	UserRepository   *user.UserRepository
+	APIKeyRepository *apikey.APIKeyRepository
+	AuthService      *auth.AuthService
+	MediaService     *media.MediaService
+}
+
+type AccountServiceDeps struct {
+	UserRepository   *user.UserRepository
+	APIKeyRepository *apikey.APIKeyRepository
+	AuthService      *auth.AuthService
+	MediaService     *media.MediaService
+}
+
+func NewAccountService(deps AccountServiceDeps) *AccountService {
+	return &AccountService{
+		UserRepository:   deps.UserRepository,
+		APIKeyRepository: deps.APIKeyRepository,
+		AuthService:      deps.AuthService,
+		MediaService:     deps.MediaService,
+	}
+}
+
+func (service *AccountService) Profile(email string) (*user.User, error) {
+	return service.UserRepository.GetByEmail(email)
+}
+
+func (service *AccountService) UpdateProfile(email string, body *ProfileUpdateRequest) (*user.User, error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if body.Name != nil {
+		if err := service.UserRepository.UpdateName(existedUser.ID, *body.Name); err != nil {
+			return nil, err
+		}
+	}
+	return service.UserRepository.GetByID(existedUser.ID)
+}
+
+// SetAvatar сохраняет новый аватар и освобождает прежний. Тип файла
+// определяется по содержимому, а не по имени.
+func (service *AccountService) SetAvatar(email string, src io.Reader) (*user.User, error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrUnsupportedAvatar
+	}
+	ext, ok := avatarTypes[http.DetectContentType(head[:n])]
+	if !ok {
+		return nil, ErrUnsupportedAvatar
+	}
+	filename, err := service.MediaService.Store(io.MultiReader(bytes.NewReader(head[:n]), src), media.AvatarsDir, ext)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.UserRepository.SetAvatar(existedUser.ID, filename); err != nil {
+		_ = service.MediaService.Release(media.AvatarsDir, filename)
+		return nil, err
+	}
+	service.releaseAvatar(existedUser)
+	return service.UserRepository.GetByID(existedUser.ID)
+}
+
+func (service *AccountService) RemoveAvatar(email string) (*user.User, error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.UserRepository.SetAvatar(existedUser.ID, ""); err != nil {
+		return nil, err
+	}
+	service.releaseAvatar(existedUser)
+	existedUser.Avatar = ""
+	return existedUser, nil
+}
+
+// Delete удаляет аккаунт вместе с API-ключами и аватаром.
+func (service *AccountService) Delete(email string, reauth auth.Reauth) error {
+	deleted, err := service.AuthService.DeleteAccount(email, reauth, func(tx *db.Db, u *user.User) error {
+		return apikey.NewAPIKeyRepository(tx).DeleteUser(u.ID)
+	})
+	if err != nil {
+		return err
+	}
+	service.releaseAvatar(deleted)
+	log.Printf("account: пользователь %d удалил аккаунт", deleted.ID)
+	return nil
+}
+
+func (service *AccountService) releaseAvatar(u *user.User) {
+	if u.Avatar == "" {
+		return
+	}
+	if err := service.MediaService.Release(media.AvatarsDir, u.Avatar); err != nil {
+		log.Printf("account: не удалось удалить аватар %s: %v", u.Avatar, err)
+	}
+}
@@ -0,0 +1,354 @@
+package admin
+
+import (
+	"coffee/internal/auth"
+	"coffee/internal/user"
+	"coffee/pkg/middleware"
+	"coffee/pkg/req"
+	"coffee/pkg/res"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+)
+
+type AdminHandler struct {
+	AdminService *AdminService
+}
+
+type AdminHandlerDeps struct {
+	AdminService *AdminService
+	Auth         *middleware.Auth
+}
+
+func NewAdminHandler(router *http.ServeMux, deps AdminHandlerDeps) {
+	handler := &AdminHandler{
+		AdminService: deps.AdminService,
+	}
+	manage := func(next http.Handler) http.Handler {
+		return middleware.RequirePermission(user.PermUsersManage, middleware.OwnerOnly(next), deps.Auth)
+	}
+	router.Handle("GET /admin/users", manage(handler.List()))
+	router.Handle("GET /admin/users/{id}", manage(handler.Get()))
+	router.Handle("PUT /admin/users/{id}/role", manage(handler.SetRole()))
+	router.Handle("POST /admin/users/{id}/disable", manage(handler.Disable()))
+	router.Handle("POST /admin/users/{id}/enable", manage(handler.Enable()))
+	router.Handle("POST /admin/users/{id}/password-reset", manage(handler.ResetPassword()))
+	router.Handle("GET /admin/audit", manage(handler.Audit()))
+	router.Handle("POST /admin/users/{id}/impersonate", middleware.RequirePermission(user.PermUsersImpersonate,
+		middleware.OwnerOnly(handler.Impersonate()), deps.Auth))
+}
+
+func actor(r *http.Request) Actor {
+	email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+	return Actor{Email: email, IP: req.ClientIP(r)}
+}
+
+// page читает limit и offset из запроса. Без limit отдается defaultLimit
+// записей, больше maxLimit — нельзя.
+func page(r *http.Request) (int, int, bool) {
+	limit, offset := defaultLimit, 0
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLimit {
+			return 0, 0, false
+		}
+		limit = n
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return limit, offset, true
+}
+
+func userID(r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	return uint(id), err == nil
+}
+
+// writeError отвечает на ошибку действия над пользователем.
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrActorNotFound):
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+	case errors.Is(err, ErrSelf), errors.Is(err, ErrTargetOutranks), errors.Is(err, ErrRoleNotAllowed):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, ErrInvalidRole):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrAccountDisabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// @Summary Пользователи
+// @Description Поиск пользователей по имени и email с фильтрами по роли и статусу, новые первыми.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param q query string false "Подстрока имени или email"
+// @Param role query string false "Роль" Enums(customer, barista, manager, admin)
+// @Param status query string false "Статус" Enums(active, disabled)
+// @Param limit query int false "Количество записей (до 100)" default(20)
+// @Param offset query int false "Смещение от начала списка" default(0)
+// @Success 200 {object} UserListResponse
+// @Failure 400 {string} string "invalid pagination"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /admin/users [get]
+func (handler *AdminHandler) List() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, ok := page(r)
+		if !ok {
+			http.Error(w, "invalid pagination", http.StatusBadRequest)
+			return
+		}
+		query := r.URL.Query()
+		users, total, err := handler.AdminService.Users(user.UserFilter{
+			Query:  query.Get("q"),
+			Role:   user.Role(query.Get("role")),
+			Status: query.Get("status"),
+		}, limit, offset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data := UserListResponse{
+			Users:  make([]UserResponse, 0, len(users)),
+			Total:  total,
+			Limit:  limit,
+			Offset: offset,
+		}
+		for i := range users {
+			data.Users = append(data.Users, NewUserResponse(&users[i]))
+		}
+		res.Json(w, data, http.StatusOK)
+	}
+}
+
+// @Summary Пользователь
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID пользователя"
+// @Success 200 {object} UserResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "user not found"
+// @Router /admin/users/{id} [get]
+func (handler *AdminHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(r)
+		if !ok {
+			writeError(w, ErrUserNotFound)
+			return
+		}
+		found, err := handler.AdminService.User(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res.Json(w, NewUserResponse(found), http.StatusOK)
+	}
+}
+
+// @Summary Назначить роль
+// @Description Назначить можно только роль младше своей (администратор может назначить администратора) и только пользователю с ролью младше своей. При понижении все входы пользователя завершаются.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID пользователя"
+// @Param request body RoleRequest true "Новая роль"
+// @Success 200 {object} UserResponse
+// @Failure 400 {string} string "unknown role"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "not allowed to assign this role"
+// @Failure 404 {string} string "user not found"
+// @Router /admin/users/{id}/role [put]
+func (handler *AdminHandler) SetRole() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(r)
+		if !ok {
+			writeError(w, ErrUserNotFound)
+			return
+		}
+		body, err := req.HandleBody[RoleRequest](&w, r)
+		if err != nil {
+			return
+		}
+		updated, err := handler.AdminService.SetRole(actor(r), id, body.Role)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res.Json(w, NewUserResponse(updated), http.StatusOK)
+	}
+}
+
+// @Summary Отключить аккаунт
+// @Description Вход, обновление токенов и API-ключи пользователя перестают работать, все входы завершаются.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID пользователя"
+// @Success 200 {object} UserResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "user not found"
+// @Router /admin/users/{id}/disable [post]
+func (handler *AdminHandler) Disable() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(r)
+		if !ok {
+			writeError(w, ErrUserNotFound)
+			return
+		}
+		updated, err := handler.AdminService.Disable(actor(r), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res.Json(w, NewUserResponse(updated), http.StatusOK)
+	}
+}
+
+// @Summary Включить аккаунт
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID пользователя"
+// @Success 200 {object} UserResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "user not found"
+// @Router /admin/users/{id}/enable [post]
+func (handler *AdminHandler) Enable() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(r)
+		if !ok {
+			writeError(w, ErrUserNotFound)
+			return
+		}
+		updated, err := handler.AdminService.Enable(actor(r), id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res.Json(w, NewUserResponse(updated), http.StatusOK)
+	}
+}
+
+// @Summary Сбросить пароль
+// @Description Текущий пароль перестает действовать, все входы завершаются, пользователю уходит письмо со ссылкой для задания нового пароля.
+// @Tags admin
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID пользователя"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "user not found"
+// @Router /admin/users/{id}/password-reset [post]
+func (handler *AdminHandler) ResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(r)
+		if !ok {
+			writeError(w, ErrUserNotFound)
+			return
+		}
+		if err := handler.AdminService.ResetPassword(actor(r), id); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Войти под пользователем
+// @Description Выдает access token пользователя на 15 минут без refresh token. С ним нельзя менять пароль, email, 2FA и API-ключи; запросы помечаются в логах. Действие записывается в журнал вместе с причиной.
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID пользователя"
+// @Param request body ImpersonateRequest true "Причина"
+// @Success 200 {object} ImpersonateResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "user not found"
+// @Failure 409 {string} string "account is disabled"
+// @Router /admin/users/{id}/impersonate [post]
+func (handler *AdminHandler) Impersonate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := userID(r)
+		if !ok {
+			writeError(w, ErrUserNotFound)
+			return
+		}
+		body, err := req.HandleBody[ImpersonateRequest](&w, r)
+		if err != nil {
+			return
+		}
+		token, expiresAt, err := handler.AdminService.Impersonate(actor(r), id, body.Reason)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res.Json(w, ImpersonateResponse{
+			AccessToken: token,
+			ExpiresAt:   expiresAt,
+		}, http.StatusOK)
+	}
+}
+
+// @Summary Журнал действий
+// @Description Действия над пользователями: смена роли, отключение, сброс пароля, вход под пользователем. Новые первыми.
+// @Tags admin
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param limit query int false "Количество записей (до 100)" default(20)
+// @Param offset query int false "Смещение от начала списка" default(0)
+// @Success 200 {object} AuditListResponse
+// @Failure 400 {string} string "invalid pagination"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /admin/audit [get]
+func (handler *AdminHandler) Audit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit, offset, ok := page(r)
+		if !ok {
+			http.Error(w, "invalid pagination", http.StatusBadRequest)
+			return
+		}
+		events, total, err := handler.AdminService.Audit(limit, offset)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, AuditListResponse{
+			Events: events,
+			Total:  total,
+			Limit:  limit,
+			Offset: offset,
+		}, http.StatusOK)
+	}
+}
@@ -0,0 +1,59 @@
+package admin
+
+import (
+	"coffee/internal/audit"
+	"coffee/internal/user"
+	"time"
+)
+
+type UserResponse struct {
+	ID               uint       `json:"id" example:"42"`
+	Name             string     `json:"name" example:"John"`
+	Email            string     `json:"email" example:"user@example.com"`
+	Role             user.Role  `json:"role" example:"barista"`
+	EmailVerified    bool       `json:"emailVerified" example:"true"`
+	TwoFactorEnabled bool       `json:"twoFactorEnabled" example:"false"`
+	DisabledAt       *time.Time `json:"disabledAt"`
+	CreatedAt        time.Time  `json:"createdAt"`
+}
+
+func NewUserResponse(u *user.User) UserResponse {
+	return UserResponse{
+		ID:               u.ID,
+		Name:             u.Name,
+		Email:            u.Email,
+		Role:             u.Role,
+		EmailVerified:    u.EmailVerified(),
+		TwoFactorEnabled: u.TwoFactorEnabled(),
+		DisabledAt:       u.DisabledAt,
+		CreatedAt:        u.CreatedAt,
+	}
+}
+
+type UserListResponse struct {
+	Users  []UserResponse `json:"users"`
+	Total  int64          `json:"total" example:"120"`
+	Limit  int            `json:"limit" example:"20"`
+	Offset int            `json:"offset" example:"0"`
+}
+
+type RoleRequest struct {
+	Role user.Role `json:"role" example:"barista" validate:"required"`
+}
+
+type ImpersonateRequest struct {
+	// Reason — зачем нужен вход под пользователем, сохраняется в журнале
+	Reason string `json:"reason" example:"Тикет #1234: не отображается заказ" validate:"required,max=200"`
+}
+
+type ImpersonateResponse struct {
+	AccessToken string    `json:"accessToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+type AuditListResponse struct {
+	Events []audit.Event `json:"events"`
+	Total  int64         `json:"total" example:"57"`
+	Limit  int           `json:"limit" example:"20"`
+	Offset int           `json:"offset" example:"0"`
+}
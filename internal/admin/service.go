@@ -0,0 +1,237 @@
+package admin
+
+import (
+	"coffee/internal/audit"
+	"coffee/internal/auth"
+	"coffee/internal/user"
+	"coffee/pkg/jwt"
+	"coffee/pkg/req"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Действия в журнале аудита.
+const (
+	ActionRoleChange    = "user.role"
+	ActionDisable       = "user.disable"
+	ActionEnable        = "user.enable"
+	ActionPasswordReset = "user.password_reset"
+	ActionImpersonate   = "user.impersonate"
+	// ActionImpersonatedRequest — запрос, сделанный с токеном входа под
+	// пользователем.
+	ActionImpersonatedRequest = "user.impersonated_request"
+)
+
+var (
+	ErrUserNotFound   = errors.New("user not found")
+	ErrSelf           = errors.New("cannot manage own account")
+	ErrTargetOutranks = errors.New("not allowed to manage a user with this role")
+	ErrInvalidRole    = errors.New("unknown role")
+	ErrRoleNotAllowed = errors.New("not allowed to assign this role")
+	ErrActorNotFound  = errors.New("actor not found")
+	// ErrNotImpersonation — токен входа под пользователем не выдавался
+	// через Impersonate или уже отозван.
+	ErrNotImpersonation = errors.New("unknown impersonation token")
+)
+
+// Actor — пользователь, выполняющий действие, и адрес, с которого пришел
+// запрос. Попадает в журнал аудита.
+type Actor struct {
+	Email string
+	IP    string
+}
+
+// AdminService — управление пользователями. Менять можно только
+// пользователей с ролью младше своей и нельзя менять себя.
+type AdminService struct {
+	UserRepository  *user.UserRepository
+	AuditRepository *audit.AuditRepository
+	AuthService     *auth.AuthService
+}
+
+type AdminServiceDeps struct {
+	UserRepository  *user.UserRepository
+	AuditRepository *audit.AuditRepository
+	AuthService     *auth.AuthService
+}
+
+func NewAdminService(deps AdminServiceDeps) *AdminService {
+	return &AdminService{
+		UserRepository:  deps.UserRepository,
+		AuditRepository: deps.AuditRepository,
+		AuthService:     deps.AuthService,
+	}
+}
+
+func (service *AdminService) Users(filter user.UserFilter, limit, offset int) ([]user.User, int64, error) {
+	return service.UserRepository.Search(filter, limit, offset)
+}
+
+func (service *AdminService) User(id uint) (*user.User, error) {
+	found, err := service.UserRepository.GetByID(id)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	return found, nil
+}
+
+func (service *AdminService) Audit(limit, offset int) ([]audit.Event, int64, error) {
+	return service.AuditRepository.List(limit, offset)
+}
+
+// SetRole назначает роль. Выдать можно только роль младше своей;
+// администратор может назначить и администратора. При понижении все входы
+// пользователя завершаются, чтобы старые права сразу перестали действовать.
+func (service *AdminService) SetRole(actor Actor, id uint, role user.Role) (*user.User, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+	manager, target, err := service.target(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if !manager.Role.Outranks(role) && manager.Role != user.RoleAdmin {
+		return nil, ErrRoleNotAllowed
+	}
+	previous := target.Role
+	if previous == role {
+		return target, nil
+	}
+	if err := service.UserRepository.SetRole(target.Email, role); err != nil {
+		return nil, err
+	}
+	target.Role = role
+	if previous.Outranks(role) {
+		if err := service.AuthService.EndSessions(target.ID); err != nil {
+			return nil, err
+		}
+	}
+	service.record(actor, manager, ActionRoleChange, target, fmt.Sprintf("%s -> %s", previous, role))
+	return target, nil
+}
+
+// Disable отключает аккаунт и завершает все его входы.
+func (service *AdminService) Disable(actor Actor, id uint) (*user.User, error) {
+	manager, target, err := service.target(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if target.Disabled() {
+		return target, nil
+	}
+	if err := service.AuthService.DisableUser(target); err != nil {
+		return nil, err
+	}
+	service.record(actor, manager, ActionDisable, target, "")
+	return target, nil
+}
+
+func (service *AdminService) Enable(actor Actor, id uint) (*user.User, error) {
+	manager, target, err := service.target(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if !target.Disabled() {
+		return target, nil
+	}
+	if err := service.AuthService.EnableUser(target); err != nil {
+		return nil, err
+	}
+	service.record(actor, manager, ActionEnable, target, "")
+	return target, nil
+}
+
+// ResetPassword сбрасывает пароль и отправляет пользователю ссылку для
+// задания нового.
+func (service *AdminService) ResetPassword(actor Actor, id uint) error {
+	manager, target, err := service.target(actor, id)
+	if err != nil {
+		return err
+	}
+	if err := service.AuthService.ForcePasswordReset(target); err != nil {
+		return err
+	}
+	service.record(actor, manager, ActionPasswordReset, target, "")
+	return nil
+}
+
+// Impersonate выдает токен входа под пользователем. Причина обязательна.
+// Событие пишется в журнал до выдачи токена: если записать его не
+// удалось, токен не выдается.
+func (service *AdminService) Impersonate(actor Actor, id uint, reason string) (string, time.Time, error) {
+	manager, target, err := service.target(actor, id)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if target.Disabled() {
+		return "", time.Time{}, auth.ErrAccountDisabled
+	}
+	if err := service.write(actor, manager, ActionImpersonate, target, reason); err != nil {
+		return "", time.Time{}, err
+	}
+	return service.AuthService.Impersonate(target, manager)
+}
+
+// RecordImpersonation пишет в журнал запрос, сделанный с токеном входа под
+// пользователем. Токен должен быть выдан через Impersonate; если его нет
+// или событие не записалось, запрос не выполняется.
+func (service *AdminService) RecordImpersonation(claims *jwt.JWTData, r *http.Request) error {
+	token, err := service.AuthService.Impersonation(claims.ID)
+	if err != nil {
+		return ErrNotImpersonation
+	}
+	_, err = service.AuditRepository.Create(&audit.Event{
+		ActorID:    token.ActorID,
+		ActorEmail: claims.Actor,
+		Action:     ActionImpersonatedRequest,
+		TargetID:   &token.UserID,
+		Details:    req.Truncate(r.Method+" "+r.URL.Path, 500),
+		IP:         req.Truncate(req.ClientIP(r), 45),
+	})
+	return err
+}
+
+// target загружает того, кто выполняет действие, и пользователя, над
+// которым оно выполняется, и проверяет, что действие разрешено.
+func (service *AdminService) target(actor Actor, id uint) (*user.User, *user.User, error) {
+	manager, err := service.UserRepository.GetByEmail(actor.Email)
+	if err != nil {
+		return nil, nil, ErrActorNotFound
+	}
+	target, err := service.UserRepository.GetByID(id)
+	if err != nil {
+		return nil, nil, ErrUserNotFound
+	}
+	if target.ID == manager.ID {
+		return nil, nil, ErrSelf
+	}
+	if !manager.Role.Outranks(target.Role) {
+		return nil, nil, ErrTargetOutranks
+	}
+	return manager, target, nil
+}
+
+// record пишет действие в журнал аудита. Ошибка записи не отменяет уже
+// выполненное действие, но попадает в лог.
+func (service *AdminService) record(actor Actor, manager *user.User, action string, target *user.User, details string) {
+	if err := service.write(actor, manager, action, target, details); err != nil {
+		log.Printf("admin: не удалось записать событие %s в журнал: %v", action, err)
+	}
+}
+
+// write пишет действие в журнал аудита и возвращает ошибку записи.
+func (service *AdminService) write(actor Actor, manager *user.User, action string, target *user.User, details string) error {
+	log.Printf("admin: %s (%s) %s пользователя %d %s", manager.Email, actor.IP, action, target.ID, details)
+	_, err := service.AuditRepository.Create(&audit.Event{
+		ActorID:    manager.ID,
+		ActorEmail: manager.Email,
+		Action:     action,
+		TargetID:   &target.ID,
+		Details:    details,
+		IP:         actor.IP,
+	})
+	return err
+}
@@ -0,0 +1,131 @@
+package apikey
+
+import (
+	"coffee/pkg/middleware"
+	"coffee/pkg/req"
+	"coffee/pkg/res"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type APIKeyHandler struct {
+	APIKeyService *APIKeyService
+}
+
+type APIKeyHandlerDeps struct {
+	APIKeyService *APIKeyService
+	Auth          *middleware.Auth
+}
+
+func NewAPIKeyHandler(router *http.ServeMux, deps APIKeyHandlerDeps) {
+	handler := &APIKeyHandler{
+		APIKeyService: deps.APIKeyService,
+	}
+	router.Handle("GET /api-keys", middleware.IsAuthed(middleware.OwnerOnly(handler.GetAll()), deps.Auth))
+	router.Handle("POST /api-keys", middleware.IsAuthed(middleware.OwnerOnly(handler.Create()), deps.Auth))
+	router.Handle("DELETE /api-keys/{id}", middleware.IsAuthed(middleware.OwnerOnly(handler.Revoke()), deps.Auth))
+}
+
+// @Summary Мои API-ключи
+// @Tags API keys
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 200 {array} APIKeyResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Router /api-keys [get]
+func (handler *APIKeyHandler) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		keys, err := handler.APIKeyService.List(email)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response := make([]APIKeyResponse, 0, len(keys))
+		for i := range keys {
+			response = append(response, newResponse(&keys[i]))
+		}
+		res.Json(w, response, http.StatusOK)
+	}
+}
+
+// @Summary Создать API-ключ
+// @Description Выпускает ключ для касс и интеграций. Права ключа (scopes) не могут превышать права создателя. Ключ показывается один раз; передавайте его в заголовке X-API-Key или Authorization: Bearer.
+// @Tags API keys
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body APIKeyCreateRequest true "Ключ"
+// @Success 201 {object} APIKeyCreateResponse
+// @Failure 400 {string} string "Неверные параметры"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "scope is not allowed"
+// @Router /api-keys [post]
+func (handler *APIKeyHandler) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[APIKeyCreateRequest](&w, r)
+		if err != nil {
+			return
+		}
+		if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+			http.Error(w, "expiresAt must be in the future", http.StatusBadRequest)
+			return
+		}
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		granted, _ := r.Context().Value(middleware.ContextPermissionsKey).([]string)
+		plain, key, err := handler.APIKeyService.Create(email, granted, body.Name, body.Scopes, body.ExpiresAt)
+		if errors.Is(err, ErrScopeNotAllowed) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if errors.Is(err, ErrNoScopes) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		res.Json(w, APIKeyCreateResponse{
+			APIKeyResponse: newResponse(key),
+			Key:            plain,
+		}, http.StatusCreated)
+	}
+}
+
+// @Summary Отозвать API-ключ
+// @Tags API keys
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID ключа"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "api key not found"
+// @Router /api-keys/{id} [delete]
+func (handler *APIKeyHandler) Revoke() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "api key not found", http.StatusNotFound)
+			return
+		}
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		if err := handler.APIKeyService.Revoke(email, uint(id)); err != nil {
+			http.Error(w, "api key not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func newResponse(key *APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		APIKey: *key,
+		Scopes: key.ScopeList(),
+	}
+}
@@ -0,0 +1,36 @@
+package apikey
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKey — ключ для машинных клиентов (кассы, интеграции). Сам ключ
+// показывается один раз при создании, хранится только его хеш. По Prefix
+// ключ находится в базе и узнается в списке.
+type APIKey struct {
+	ID         uint       `json:"id" example:"1" gorm:"primaryKey"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UserID     uint       `json:"-" gorm:"index;not null"`
+	Name       string     `json:"name" example:"Касса на баре" gorm:"size:100;not null"`
+	Prefix     string     `json:"prefix" example:"ck_k3Xp9QaZ" gorm:"size:16;uniqueIndex;not null"`
+	SecretHash string     `json:"-" gorm:"size:64;not null"`
+	Scopes     string     `json:"-" gorm:"size:500;not null"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `json:"revokedAt"`
+}
+
+func (APIKey) TableName() string {
+	return "api_keys"
+}
+
+// ScopeList — права ключа.
+func (key *APIKey) ScopeList() []string {
+	return strings.Fields(key.Scopes)
+}
+
+// Active сообщает, что ключ не отозван и не истек.
+func (key *APIKey) Active(now time.Time) bool {
+	return key.RevokedAt == nil && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
@@ -0,0 +1,21 @@
+package apikey
+
+import "time"
+
+type APIKeyCreateRequest struct {
+	Name   string   `json:"name" example:"Касса на баре" validate:"required,max=100"`
+	Scopes []string `json:"scopes" example:"coffee:write,labels:print" validate:"required,min=1,dive,required"`
+	// ExpiresAt — когда ключ перестанет действовать; без него ключ бессрочный
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type APIKeyResponse struct {
+	APIKey
+	Scopes []string `json:"scopes" example:"coffee:write,labels:print"`
+}
+
+type APIKeyCreateResponse struct {
+	APIKeyResponse
+	// Key — ключ целиком, показывается только один раз
+	Key string `json:"key" example:"ck_k3Xp9QaZ_4fG7hJ2kL9mN3pQ5rS8tV1wX6yZ0aB2c"`
+}
@@ -0,0 +1,68 @@
+package apikey
+
+import (
+	"coffee/pkg/db"
+	"time"
+)
+
+type APIKeyRepository struct {
+	Database *db.Db
+}
+
+func NewAPIKeyRepository(db *db.Db) *APIKeyRepository {
+	return &APIKeyRepository{
+		Database: db,
+	}
+}
+
+func (repo *APIKeyRepository) Create(key *APIKey) (*APIKey, error) {
+	result := repo.Database.DB.Create(key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return key, nil
+}
+
+func (repo *APIKeyRepository) GetByPrefix(prefix string) (*APIKey, error) {
+	var key APIKey
+	result := repo.Database.DB.Where("prefix = ?", prefix).First(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &key, nil
+}
+
+// GetByUser возвращает ключи пользователя, новые первыми.
+func (repo *APIKeyRepository) GetByUser(userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	result := repo.Database.DB.Where("user_id = ?", userID).Order("created_at DESC").Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return keys, nil
+}
+
+func (repo *APIKeyRepository) GetForUser(userID, id uint) (*APIKey, error) {
+	var key APIKey
+	result := repo.Database.DB.Where("user_id = ? AND id = ?", userID, id).First(&key)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &key, nil
+}
+
+func (repo *APIKeyRepository) Revoke(id uint) error {
+	result := repo.Database.DB.Model(&APIKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	return result.Error
+}
+
+func (repo *APIKeyRepository) Touch(id uint, at time.Time) error {
+	result := repo.Database.DB.Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", at)
+	return result.Error
+}
+
+func (repo *APIKeyRepository) DeleteUser(userID uint) error {
+	return repo.Database.DB.Where("user_id = ?", userID).Delete(&APIKey{}).Error
+}
@@ -0,0 +1,168 @@
+package apikey
+
+import (
+	"coffee/internal/auth"
+	"coffee/internal/user"
+	"coffee/pkg/middleware"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	prefixLength = 8
+	secretLength = 32
+	// touchInterval — чаще этого время последнего использования не
+	// записывается, чтобы не писать в базу на каждый запрос.
+	touchInterval = time.Minute
+)
+
+var (
+	ErrInvalidKey      = errors.New("invalid api key")
+	ErrScopeNotAllowed = errors.New("scope is not allowed")
+	ErrNoScopes        = errors.New("at least one scope is required")
+)
+
+type APIKeyService struct {
+	APIKeyRepository *APIKeyRepository
+	UserRepository   *user.UserRepository
+	AuthService      *auth.AuthService
+}
+
+func NewAPIKeyService(apiKeyRepository *APIKeyRepository, userRepository *user.UserRepository, authService *auth.AuthService) *APIKeyService {
+	return &APIKeyService{
+		APIKeyRepository: apiKeyRepository,
+		UserRepository:   userRepository,
+		AuthService:      authService,
+	}
+}
+
+// Create выпускает ключ для пользователя email. Ключ не может дать больше
+// прав, чем granted — права того, кто его создает. Возвращает ключ
+// целиком: больше его узнать нельзя.
+func (service *APIKeyService) Create(email string, granted []string, name string, scopes []string, expiresAt *time.Time) (string, *APIKey, error) {
+	if len(scopes) == 0 {
+		return "", nil, ErrNoScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return "", nil, ErrScopeNotAllowed
+		}
+	}
+	owner, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return "", nil, err
+	}
+	prefix, err := randomString(prefixLength)
+	if err != nil {
+		return "", nil, err
+	}
+	prefix = middleware.APIKeyPrefix + prefix
+	secret, err := randomString(secretLength)
+	if err != nil {
+		return "", nil, err
+	}
+	key, err := service.APIKeyRepository.Create(&APIKey{
+		UserID:     owner.ID,
+		Name:       name,
+		Prefix:     prefix,
+		SecretHash: hashSecret(secret),
+		Scopes:     strings.Join(slices.Compact(slices.Sorted(slices.Values(scopes))), " "),
+		ExpiresAt:  expiresAt,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return prefix + "_" + secret, key, nil
+}
+
+func (service *APIKeyService) List(email string) ([]APIKey, error) {
+	owner, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	return service.APIKeyRepository.GetByUser(owner.ID)
+}
+
+func (service *APIKeyService) Revoke(email string, id uint) error {
+	owner, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+	key, err := service.APIKeyRepository.GetForUser(owner.ID, id)
+	if err != nil {
+		return err
+	}
+	return service.APIKeyRepository.Revoke(key.ID)
+}
+
+// VerifyAPIKey проверяет ключ вида ck_<prefix>_<secret>. Права ключа —
+// его scopes, но не больше текущих прав владельца с учетом тех же
+// ограничений, что и при входе: неподтвержденный email и TOTP для ролей
+// из TwoFactorRoles.
+func (service *APIKeyService) VerifyAPIKey(raw string) (*middleware.APIKeyIdentity, error) {
+	i := strings.LastIndexByte(raw, '_')
+	if i <= len(middleware.APIKeyPrefix) {
+		return nil, ErrInvalidKey
+	}
+	prefix, secret := raw[:i], raw[i+1:]
+	key, err := service.APIKeyRepository.GetByPrefix(prefix)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	if subtle.ConstantTimeCompare([]byte(key.SecretHash), []byte(hashSecret(secret))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	now := time.Now()
+	if !key.Active(now) {
+		return nil, ErrInvalidKey
+	}
+	owner, err := service.UserRepository.GetByID(key.UserID)
+	if err != nil || owner.Disabled() {
+		return nil, ErrInvalidKey
+	}
+	granted := service.AuthService.Permissions(owner)
+	permissions := []string{}
+	for _, scope := range key.ScopeList() {
+		if slices.Contains(granted, scope) {
+			permissions = append(permissions, scope)
+		}
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > touchInterval {
+		if err := service.APIKeyRepository.Touch(key.ID, now); err != nil {
+			log.Printf("apikey: не удалось обновить время использования ключа %s: %v", key.Prefix, err)
+		}
+	}
+	return &middleware.APIKeyIdentity{
+		KeyID:       key.ID,
+		Email:       owner.Email,
+		Role:        string(owner.Role),
+		Permissions: permissions,
+	}, nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+const alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+func randomString(length int) (string, error) {
+	value := make([]byte, length)
+	for i := range value {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
+		if err != nil {
+			return "", err
+		}
+		value[i] = alphabet[n.Int64()]
+	}
+	return string(value), nil
+}
@@ -3,14 +3,25 @@ package app
 import (
 	"coffee/configs"
 	_ "coffee/docs"
+	"coffee/internal/account"
+	"coffee/internal/admin"
+	"coffee/internal/apikey"
+	"coffee/internal/audit"
 	"coffee/internal/auth"
 	"coffee/internal/coffee"
+	"coffee/internal/label"
+	"coffee/internal/media"
 	"coffee/internal/notification"
+	"coffee/internal/scan"
+	"coffee/internal/table"
 	"coffee/internal/user"
 	"coffee/pkg/db"
 	httpSwagger "github.com/swaggo/http-swagger" // Add this import
 
 	"coffee/pkg/middleware"
+	"coffee/pkg/req"
+	"context"
+	"log"
 	"net/http"
 )
 
@@ -22,22 +33,105 @@ func App() http.Handler {
 	userRepository := user.NewUserRepository(db)
 
 	coffeeRepository := coffee.NewCoffeeRepository(db)
+	storageBackend, err := media.NewBackend(conf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	mediaService := media.NewMediaService(media.NewBlobRepository(db), storageBackend, conf.Storage)
+	if conf.Gc.Interval > 0 {
+		coffee.NewGarbageCollector(coffeeRepository, mediaService, conf.Gc.GracePeriod).
+			Start(context.Background(), conf.Gc.Interval)
+	}
 
-	authService := auth.NewAuthService(userRepository)
+	revocations := auth.NewRevocationStore(auth.NewRevokedTokenRepository(db))
+	revocations.Start(context.Background())
+	loginGuard, err := auth.NewLoginGuard(conf, db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authService := auth.NewAuthService(auth.AuthServiceDeps{
+		UserRepository:               userRepository,
+		Guard:                        loginGuard,
+		RefreshTokenRepository:       auth.NewRefreshTokenRepository(db),
+		SessionRepository:            auth.NewSessionRepository(db),
+		ActionTokenRepository:        auth.NewActionTokenRepository(db),
+		RecoveryCodeRepository:       auth.NewRecoveryCodeRepository(db),
+		IdentityRepository:           auth.NewIdentityRepository(db),
+		OIDCStateRepository:          auth.NewOIDCStateRepository(db),
+		ImpersonationTokenRepository: auth.NewImpersonationTokenRepository(db),
+		Revocations:                  revocations,
+		Mailer:                       notification.NewMailer(conf),
+		Config:                       conf,
+	})
+	apiKeyRepository := apikey.NewAPIKeyRepository(db)
+	apiKeyService := apikey.NewAPIKeyService(apiKeyRepository, userRepository, authService)
+	adminService := admin.NewAdminService(admin.AdminServiceDeps{
+		UserRepository:  userRepository,
+		AuditRepository: audit.NewAuditRepository(db),
+		AuthService:     authService,
+	})
+	authMiddleware := &middleware.Auth{
+		Config:        conf,
+		APIKeys:       apiKeyService,
+		Revocations:   revocations,
+		Impersonation: adminService,
+	}
 
 	coffee.NewCoffeeHandler(router, coffee.CoffeeHandlerDeps{
 		CoffeeRepository: coffeeRepository,
+		MediaService:     mediaService,
 		Config:           conf,
+		Auth:             authMiddleware,
 	})
 	auth.NewAuthHandler(router, auth.AuthHandlerDeps{
 		Config:      conf,
 		AuthService: authService,
+		Auth:        authMiddleware,
+	})
+	account.NewAccountHandler(router, account.AccountHandlerDeps{
+		AccountService: account.NewAccountService(account.AccountServiceDeps{
+			UserRepository:   userRepository,
+			APIKeyRepository: apiKeyRepository,
+			AuthService:      authService,
+			MediaService:     mediaService,
+		}),
+		AuthService: authService,
+		Auth:        authMiddleware,
+	})
+	admin.NewAdminHandler(router, admin.AdminHandlerDeps{
+		AdminService: adminService,
+		Auth:         authMiddleware,
+	})
+	apikey.NewAPIKeyHandler(router, apikey.APIKeyHandlerDeps{
+		APIKeyService: apiKeyService,
+		Auth:          authMiddleware,
+	})
+	scan.NewScanHandler(router, scan.ScanHandlerDeps{
+		ScanRepository:   scan.NewScanRepository(db),
+		CoffeeRepository: coffeeRepository,
+		Config:           conf,
+		Auth:             authMiddleware,
+	})
+	label.NewLabelHandler(router, label.LabelHandlerDeps{
+		CoffeeRepository: coffeeRepository,
+		Config:           conf,
+		Auth:             authMiddleware,
+	})
+	table.NewTableHandler(router, table.TableHandlerDeps{
+		TableRepository: table.NewTableRepository(db),
+		Config:          conf,
+		Auth:            authMiddleware,
+	})
+	media.NewUploadHandler(router, media.UploadHandlerDeps{
+		MediaService: mediaService,
+		Config:       conf,
+		Auth:         authMiddleware,
 	})
 	notification.NewNotificationHandler(router, notification.NotificationHandlerDeps{
 		Config: conf,
 	})
 	router.Handle("/docs/", httpSwagger.WrapHandler)
 
-	stack := middleware.Chain(middleware.CORS)
+	stack := middleware.Chain(middleware.CORS, req.TrustProxies(conf.Server.TrustedProxies))
 	return stack(router)
 }
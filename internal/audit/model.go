@@ -0,0 +1,19 @@
+package audit
+
+import "time"
+
+// Event — запись журнала действий администраторов.
+type Event struct {
+	ID         uint      `json:"id" example:"1" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"createdAt" gorm:"index"`
+	ActorID    uint      `json:"actorId" example:"1" gorm:"index;not null"`
+	ActorEmail string    `json:"actorEmail" example:"admin@coffee.local" gorm:"size:255;not null"`
+	Action     string    `json:"action" example:"user.disable" gorm:"size:50;not null"`
+	TargetID   *uint     `json:"targetId" example:"42" gorm:"index"`
+	Details    string    `json:"details" example:"role: user -> manager" gorm:"size:500"`
+	IP         string    `json:"ip" example:"203.0.113.7" gorm:"size:45"`
+}
+
+func (Event) TableName() string {
+	return "audit_events"
+}
@@ -0,0 +1,36 @@
+package audit
+
+import "coffee/pkg/db"
+
+type AuditRepository struct {
+	Database *db.Db
+}
+
+func NewAuditRepository(db *db.Db) *AuditRepository {
+	return &AuditRepository{
+		Database: db,
+	}
+}
+
+func (repo *AuditRepository) Create(event *Event) (*Event, error) {
+	result := repo.Database.DB.Create(event)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return event, nil
+}
+
+// List возвращает события, новые первыми, и их общее число.
+func (repo *AuditRepository) List(limit, offset int) ([]Event, int64, error) {
+	var events []Event
+	var total int64
+	query := repo.Database.DB.Model(&Event{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	result := query.Order("id DESC").Limit(limit).Offset(offset).Find(&events)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return events, total, nil
+}
@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"coffee/internal/user"
+	"coffee/pkg/db"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	// PurposeEmailChange — токен из письма на новый email.
+	PurposeEmailChange = "email_change"
+	// PurposeReauth — токен из письма, который заменяет пароль при
+	// подтверждении действия в аккаунте без пароля.
+	PurposeReauth = "reauth"
+	reauthTTL     = 15 * time.Minute
+)
+
+var (
+	ErrWrongPassword      = errors.New("current password is incorrect")
+	ErrCodeRequired       = errors.New("two-factor code is required")
+	ErrPasswordNotSet     = errors.New("account has no password, confirm by email")
+	ErrPasswordSet        = errors.New("account has a password")
+	ErrInvalidReauthToken = errors.New("invalid or expired confirmation token")
+)
+
+// Reauth — подтверждение личности перед изменением учетных данных: пароль
+// или, у аккаунта без пароля, токен из письма RequestReauth. При
+// включенном TOTP нужен еще и код. Client — откуда пришел запрос, по нему
+// учитываются неверные коды.
+type Reauth struct {
+	Password string
+	Token    string
+	Code     string
+	Client   ClientInfo
+}
+
+// RequestReauth отправляет токен подтверждения на email аккаунта без
+// пароля, например созданного при входе через провайдера.
+func (service *AuthService) RequestReauth(email string) error {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+	if existedUser.HasPassword() {
+		return ErrPasswordSet
+	}
+	token, err := service.newActionToken(existedUser.ID, PurposeReauth, reauthTTL)
+	if err != nil {
+		return err
+	}
+	link := service.Config.Qr.PublicBaseURL + service.Config.Auth.ReauthPath +
+		"?" + url.Values{"token": {token}}.Encode()
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Чтобы подтвердить изменение аккаунта, перейдите по ссылке:\n%s\n\n"+
+		"Ссылка действует %s. Если вы ничего не меняли, смените пароль через «Забыли пароль».\n",
+		existedUser.Name, link, humanize(reauthTTL))
+	go service.send(existedUser.Email, "Подтверждение действия", body)
+	return nil
+}
+
+// Reauthenticate проверяет пароль или токен из письма и, если включен
+// TOTP, код перед изменением учетных данных или удалением аккаунта. Токен
+// из письма расходуется в одной транзакции с проверкой кода, поэтому
+// неверный код его не сжигает.
+func (service *AuthService) Reauthenticate(email string, reauth Reauth) (*user.User, error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existedUser.TwoFactorEnabled() && reauth.Code == "" {
+		return nil, ErrCodeRequired
+	}
+	switch {
+	case reauth.Token != "":
+		_, err := service.ActionTokenRepository.ConsumeWith(hashToken(reauth.Token), PurposeReauth,
+			func(tx *gorm.DB, action *ActionToken) error {
+				if action.UserID != existedUser.ID {
+					return ErrInvalidReauthToken
+				}
+				return service.checkSecondFactor(existedUser, reauth.Code, reauth.Client)
+			})
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidReauthToken
+		}
+		if err != nil {
+			return nil, err
+		}
+		return existedUser, nil
+	case !existedUser.HasPassword():
+		return nil, ErrPasswordNotSet
+	case bcrypt.CompareHashAndPassword([]byte(existedUser.Password), []byte(reauth.Password)) != nil:
+		return nil, ErrWrongPassword
+	}
+	if err := service.checkSecondFactor(existedUser, reauth.Code, reauth.Client); err != nil {
+		return nil, err
+	}
+	return existedUser, nil
+}
+
+// ChangePassword меняет (или впервые задает) пароль и завершает все входы
+// пользователя, в том числе текущий: клиенту нужно получить новые токены.
+func (service *AuthService) ChangePassword(email string, reauth Reauth, password string) (*user.User, error) {
+	existedUser, err := service.Reauthenticate(email, reauth)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.UserRepository.UpdatePassword(existedUser.ID, string(hashedPassword)); err != nil {
+		return nil, err
+	}
+	if err := service.revokeUser(existedUser.ID); err != nil {
+		return nil, err
+	}
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Пароль вашего аккаунта изменен, все входы завершены. "+
+		"Если это были не вы, восстановите доступ через «Забыли пароль».\n", existedUser.Name)
+	go service.send(existedUser.Email, "Пароль изменен", body)
+	return existedUser, nil
+}
+
+// RequestEmailChange отправляет ссылку подтверждения на новый email.
+// Адрес меняется только после перехода по ссылке.
+func (service *AuthService) RequestEmailChange(email string, reauth Reauth, newEmail string) error {
+	existedUser, err := service.Reauthenticate(email, reauth)
+	if err != nil {
+		return err
+	}
+	if taken, _ := service.UserRepository.GetByEmail(newEmail); taken != nil {
+		return ErrUserExists
+	}
+	if err := service.UserRepository.SetPendingEmail(existedUser.ID, newEmail); err != nil {
+		return err
+	}
+	token, err := service.newActionToken(existedUser.ID, PurposeEmailChange, service.Config.Auth.EmailVerifyTTL)
+	if err != nil {
+		return err
+	}
+	link := service.Config.Qr.PublicBaseURL + service.Config.Auth.EmailChangePath +
+		"?" + url.Values{"token": {token}}.Encode()
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Чтобы входить с этим адресом, подтвердите его по ссылке:\n%s\n\n"+
+		"Ссылка действует %s. Если вы не меняли email, просто проигнорируйте это письмо.\n",
+		existedUser.Name, link, humanize(service.Config.Auth.EmailVerifyTTL))
+	go service.send(newEmail, "Подтверждение нового email", body)
+	notice := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Запрошена смена email вашего аккаунта на %s. Адрес сменится после подтверждения. "+
+		"Если это были не вы, смените пароль.\n", existedUser.Name, newEmail)
+	go service.send(existedUser.Email, "Смена email", notice)
+	return nil
+}
+
+// ConfirmEmailChange меняет email по токену из письма. Email записан в
+// выданных токенах, поэтому все входы завершаются.
+func (service *AuthService) ConfirmEmailChange(token string) error {
+	action, err := service.ActionTokenRepository.Consume(hashToken(token), PurposeEmailChange)
+	if err != nil {
+		return ErrInvalidActionToken
+	}
+	existedUser, err := service.UserRepository.GetByID(action.UserID)
+	if err != nil || existedUser.PendingEmail == "" {
+		return ErrInvalidActionToken
+	}
+	if taken, _ := service.UserRepository.GetByEmail(existedUser.PendingEmail); taken != nil {
+		return ErrUserExists
+	}
+	if err := service.UserRepository.ChangeEmail(existedUser.ID, existedUser.PendingEmail); err != nil {
+		return err
+	}
+	return service.revokeUser(existedUser.ID)
+}
+
+// DeleteAccount проверяет пароль, завершает входы и удаляет все данные
+// входа пользователя вместе с ним самим в одной транзакции; cleanup
+// удаляет в ней же остальные данные пользователя. Возвращает удаленного
+// пользователя, чтобы вызывающий освободил его файлы.
+func (service *AuthService) DeleteAccount(email string, reauth Reauth, cleanup func(tx *db.Db, u *user.User) error) (*user.User, error) {
+	existedUser, err := service.Reauthenticate(email, reauth)
+	if err != nil {
+		return nil, err
+	}
+	// Токены отзываются до удаления: если оно не удастся, аккаунт
+	// останется, но без действующих входов.
+	if err := service.revokeUser(existedUser.ID); err != nil {
+		return nil, err
+	}
+	err = service.RefreshTokenRepository.Database.Transaction(func(tx *gorm.DB) error {
+		database := &db.Db{DB: tx}
+		if cleanup != nil {
+			if err := cleanup(database, existedUser); err != nil {
+				return err
+			}
+		}
+		for _, remove := range []func(uint) error{
+			NewRefreshTokenRepository(database).DeleteUser,
+			NewSessionRepository(database).DeleteUser,
+			NewActionTokenRepository(database).DeleteUser,
+			NewRecoveryCodeRepository(database).DeleteAll,
+			NewIdentityRepository(database).DeleteUser,
+			NewImpersonationTokenRepository(database).DeleteUser,
+			service.UserRepository.WithTx(tx).Delete,
+		} {
+			if err := remove(existedUser.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return existedUser, nil
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"coffee/internal/user"
+	"coffee/pkg/jwt"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// impersonationTTL — срок жизни токена входа под пользователем. Refresh
+// token не выдается: по истечении нужно запросить новый.
+const impersonationTTL = 15 * time.Minute
+
+// EndSessions завершает все входы пользователя. Используется, когда права
+// пользователя изменились и старые токены не должны действовать.
+func (service *AuthService) EndSessions(userID uint) error {
+	return service.revokeUser(userID)
+}
+
+// DisableUser отключает аккаунт и завершает все его входы. API-ключи
+// перестают действовать, пока аккаунт отключен.
+func (service *AuthService) DisableUser(u *user.User) error {
+	now := time.Now()
+	if err := service.UserRepository.SetDisabled(u.ID, &now); err != nil {
+		return err
+	}
+	u.DisabledAt = &now
+	return service.revokeUser(u.ID)
+}
+
+// EnableUser снова разрешает вход в отключенный аккаунт.
+func (service *AuthService) EnableUser(u *user.User) error {
+	if err := service.UserRepository.SetDisabled(u.ID, nil); err != nil {
+		return err
+	}
+	u.DisabledAt = nil
+	return nil
+}
+
+// ForcePasswordReset делает текущий пароль недействительным, завершает все
+// входы и отправляет пользователю ссылку для задания нового пароля.
+func (service *AuthService) ForcePasswordReset(u *user.User) error {
+	if err := service.UserRepository.UpdatePassword(u.ID, unusablePassword()); err != nil {
+		return err
+	}
+	if err := service.revokeUser(u.ID); err != nil {
+		return err
+	}
+	ttl := service.Config.Auth.PasswordResetTTL
+	token, err := service.newActionToken(u.ID, PurposePasswordReset, ttl)
+	if err != nil {
+		return err
+	}
+	link := service.Config.Qr.PublicBaseURL + service.Config.Auth.PasswordResetPath +
+		"?" + url.Values{"token": {token}}.Encode()
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Администратор сбросил пароль вашего аккаунта. Чтобы снова войти, задайте новый пароль по ссылке:\n%s\n\n"+
+		"Ссылка действует %s. Если срок истек, воспользуйтесь «Забыли пароль».\n",
+		u.Name, link, humanize(ttl))
+	go service.send(u.Email, "Пароль сброшен администратором", body)
+	return nil
+}
+
+// Impersonate выпускает короткоживущий access token пользователя u для
+// поддержки. Email администратора actor попадает в claim act, поэтому
+// такие запросы попадают в журнал и не могут менять учетные данные. jti
+// токена сохраняется, и токен отзывается вместе с остальными входами
+// пользователя.
+func (service *AuthService) Impersonate(u *user.User, actor *user.User) (string, time.Time, error) {
+	if u.Disabled() {
+		return "", time.Time{}, ErrAccountDisabled
+	}
+	data := service.claims(u)
+	data.ID = uuid.NewString()
+	data.TokenType = jwt.AccessToken
+	data.ExpiresAt = time.Now().Add(impersonationTTL)
+	data.Actor = actor.Email
+	if err := service.ImpersonationTokenRepository.DeleteExpired(u.ID); err != nil {
+		return "", time.Time{}, err
+	}
+	err := service.ImpersonationTokenRepository.Create(&ImpersonationToken{
+		UserID:    u.ID,
+		ActorID:   actor.ID,
+		JTI:       data.ID,
+		ExpiresAt: data.ExpiresAt,
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	token, err := service.jwt().Create(data, service.Config.Auth.AccessKeys)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, data.ExpiresAt, nil
+}
+
+// Impersonation возвращает сохраненный токен входа под пользователем по
+// jti.
+func (service *AuthService) Impersonation(jti string) (*ImpersonationToken, error) {
+	return service.ImpersonationTokenRepository.GetByJTI(jti)
+}
@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"coffee/pkg/db"
+	"log"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// AttemptStore хранит счетчики неудачных входов.
+type AttemptStore interface {
+	Get(key string) (LoginAttempt, error)
+	// Reserve одним шагом вычисляет wait для текущего счетчика и, если
+	// ждать не нужно, заранее засчитывает попытку как неудачу. Если прошлая
+	// неудача была раньше now-window, счет начинается заново. Возвращает
+	// оставшуюся паузу; при ненулевой паузе счетчик не меняется.
+	Reserve(key string, now time.Time, window time.Duration, wait func(LoginAttempt) time.Duration) (time.Duration, error)
+	// Release снимает попытку, засчитанную Reserve.
+	Release(key string) error
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// maxMemoryAttempts — сколько счетчиков держит MemoryAttemptStore. При
+// переполнении незаблокированные счетчики выбрасываются, чтобы поток
+// запросов с разных адресов не съел память.
+const maxMemoryAttempts = 100_000
+
+// MemoryAttemptStore — счетчики в памяти процесса, для одного узла.
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+	prunedAt time.Time
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{
+		attempts: map[string]LoginAttempt{},
+	}
+}
+
+func (store *MemoryAttemptStore) Get(key string) (LoginAttempt, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	attempt, ok := store.attempts[key]
+	if !ok {
+		attempt.Key = key
+	}
+	return attempt, nil
+}
+
+func (store *MemoryAttemptStore) Reserve(key string, now time.Time, window time.Duration, wait func(LoginAttempt) time.Duration) (time.Duration, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.prune(now, window)
+	attempt, ok := store.attempts[key]
+	attempt.Key = key
+	if delay := wait(attempt); delay > 0 {
+		return delay, nil
+	}
+	if !ok && len(store.attempts) >= maxMemoryAttempts {
+		store.evict(now)
+	}
+	if now.Sub(attempt.LastFailedAt) > window {
+		attempt.Failures = 0
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = now
+	store.attempts[key] = attempt
+	return 0, nil
+}
+
+func (store *MemoryAttemptStore) Release(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	attempt, ok := store.attempts[key]
+	if !ok || attempt.Failures == 0 {
+		return nil
+	}
+	attempt.Failures--
+	store.attempts[key] = attempt
+	return nil
+}
+
+func (store *MemoryAttemptStore) Lock(key string, until time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	attempt := store.attempts[key]
+	attempt.Key = key
+	attempt.LockedUntil = until
+	store.attempts[key] = attempt
+	return nil
+}
+
+func (store *MemoryAttemptStore) Reset(key string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	delete(store.attempts, key)
+	return nil
+}
+
+// prune раз в окно выбрасывает счетчики, которые уже ни на что не влияют.
+func (store *MemoryAttemptStore) prune(now time.Time, window time.Duration) {
+	if now.Sub(store.prunedAt) < window {
+		return
+	}
+	for key, attempt := range store.attempts {
+		if now.Sub(attempt.LastFailedAt) > window && now.After(attempt.LockedUntil) {
+			delete(store.attempts, key)
+		}
+	}
+	store.prunedAt = now
+}
+
+// evict освобождает место, выбрасывая незаблокированные счетчики. Если
+// заблокированы все, выбрасывается произвольная половина.
+func (store *MemoryAttemptStore) evict(now time.Time) {
+	for key, attempt := range store.attempts {
+		if now.After(attempt.LockedUntil) {
+			delete(store.attempts, key)
+		}
+	}
+	for key := range store.attempts {
+		if len(store.attempts) < maxMemoryAttempts/2 {
+			break
+		}
+		delete(store.attempts, key)
+	}
+}
+
+// PostgresAttemptStore — счетчики в таблице login_attempts, общие для
+// всех узлов.
+type PostgresAttemptStore struct {
+	Database *db.Db
+
+	mu       sync.Mutex
+	prunedAt time.Time
+}
+
+func NewPostgresAttemptStore(db *db.Db) *PostgresAttemptStore {
+	return &PostgresAttemptStore{
+		Database: db,
+	}
+}
+
+func (store *PostgresAttemptStore) Get(key string) (LoginAttempt, error) {
+	var attempt LoginAttempt
+	result := store.Database.DB.Where("key = ?", key).Limit(1).Find(&attempt)
+	if result.Error != nil {
+		return attempt, result.Error
+	}
+	attempt.Key = key
+	return attempt, nil
+}
+
+// Reserve блокирует строку счетчика до конца транзакции, поэтому
+// параллельные попытки с одним ключом выполняются по очереди.
+func (store *PostgresAttemptStore) Reserve(key string, now time.Time, window time.Duration, wait func(LoginAttempt) time.Duration) (time.Duration, error) {
+	store.prune(now, window)
+	var delay time.Duration
+	err := store.Database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&LoginAttempt{Key: key}).Error
+		if err != nil {
+			return err
+		}
+		var attempt LoginAttempt
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("key = ?", key).First(&attempt).Error
+		if err != nil {
+			return err
+		}
+		if delay = wait(attempt); delay > 0 {
+			return nil
+		}
+		failures := attempt.Failures + 1
+		if now.Sub(attempt.LastFailedAt) > window {
+			failures = 1
+		}
+		return tx.Model(&LoginAttempt{}).Where("key = ?", key).Updates(map[string]interface{}{
+			"failures":       failures,
+			"last_failed_at": now,
+		}).Error
+	})
+	return delay, err
+}
+
+func (store *PostgresAttemptStore) Release(key string) error {
+	result := store.Database.DB.Model(&LoginAttempt{}).
+		Where("key = ? AND failures > 0", key).
+		Update("failures", gorm.Expr("failures - 1"))
+	return result.Error
+}
+
+func (store *PostgresAttemptStore) Lock(key string, until time.Time) error {
+	result := store.Database.DB.Model(&LoginAttempt{}).Where("key = ?", key).Update("locked_until", until)
+	return result.Error
+}
+
+func (store *PostgresAttemptStore) Reset(key string) error {
+	result := store.Database.DB.Where("key = ?", key).Delete(&LoginAttempt{})
+	return result.Error
+}
+
+// prune раз в окно удаляет строки, которые уже ни на что не влияют: без
+// неудач в пределах окна и без действующей блокировки. Ошибка удаления не
+// мешает входу и только попадает в лог.
+func (store *PostgresAttemptStore) prune(now time.Time, window time.Duration) {
+	store.mu.Lock()
+	if now.Sub(store.prunedAt) < window {
+		store.mu.Unlock()
+		return
+	}
+	store.prunedAt = now
+	store.mu.Unlock()
+	result := store.Database.DB.
+		Where("last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-window), now).
+		Delete(&LoginAttempt{})
+	if result.Error != nil {
+		log.Printf("auth: не удалось очистить login_attempts: %v", result.Error)
+	}
+}
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"coffee/configs"
+	"coffee/pkg/db"
+	"fmt"
+	"log"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// TooManyAttemptsError — вход временно запрещен: слишком много неудачных
+// попыток с этого аккаунта или IP.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (err *TooManyAttemptsError) Error() string {
+	return "too many login attempts"
+}
+
+// LoginGuard ограничивает перебор паролей: экспоненциальная пауза после
+// нескольких неудач и временная блокировка после порога.
+type LoginGuard struct {
+	Store  AttemptStore
+	Config configs.LoginConfig
+}
+
+// NewLoginGuard выбирает хранилище счетчиков по conf.Login.Store.
+func NewLoginGuard(conf *configs.Config, database *db.Db) (*LoginGuard, error) {
+	var store AttemptStore
+	switch conf.Login.Store {
+	case "", "memory":
+		store = NewMemoryAttemptStore()
+	case "postgres":
+		store = NewPostgresAttemptStore(database)
+	default:
+		return nil, fmt.Errorf("unknown login guard store %q", conf.Login.Store)
+	}
+	return &LoginGuard{
+		Store:  store,
+		Config: conf.Login,
+	}, nil
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// ipKey — ключ счетчика по IP. Адреса IPv6 считаются по подсети /64:
+// клиенту обычно выдается целая подсеть, и перебор по ее адресам иначе
+// обходил бы порог.
+func ipKey(ip string) string {
+	if addr, err := netip.ParseAddr(ip); err == nil && addr.Is6() && !addr.Is4In6() {
+		prefix, _ := addr.Prefix(64)
+		return "ip:" + prefix.String()
+	}
+	return "ip:" + ip
+}
+
+// Reserve засчитывает попытку входа по аккаунту и IP заранее, до проверки
+// пароля, и возвращает TooManyAttemptsError, если нужно подождать (тогда
+// попытка не засчитывается). Проверка и учет выполняются в хранилище
+// одним шагом, поэтому параллельные запросы не проходят мимо паузы. Исход
+// попытки сообщается через Fail, Succeed или Cancel.
+func (guard *LoginGuard) Reserve(email, ip string) error {
+	now := time.Now()
+	wait := func(attempt LoginAttempt) time.Duration {
+		return guard.wait(attempt, now)
+	}
+	var reserved []string
+	var delay time.Duration
+	for _, key := range []string{accountKey(email), ipKey(ip)} {
+		keyDelay, err := guard.Store.Reserve(key, now, guard.Config.Window, wait)
+		if err != nil {
+			guard.release(reserved...)
+			return err
+		}
+		if keyDelay > 0 {
+			delay = max(delay, keyDelay)
+			continue
+		}
+		reserved = append(reserved, key)
+	}
+	if delay > 0 {
+		guard.release(reserved...)
+		return &TooManyAttemptsError{RetryAfter: delay}
+	}
+	return nil
+}
+
+// Fail сообщает, что попытка из Reserve неудачна, и блокирует аккаунт или
+// IP по достижении порога. locked сообщает, что аккаунт только что
+// заблокирован.
+func (guard *LoginGuard) Fail(email, ip string) (locked bool, err error) {
+	now := time.Now()
+	locked, err = guard.lockIfNeeded(accountKey(email), guard.Config.AccountThreshold, now)
+	if err != nil {
+		return false, err
+	}
+	if _, err := guard.lockIfNeeded(ipKey(ip), guard.Config.IPThreshold, now); err != nil {
+		return locked, err
+	}
+	return locked, nil
+}
+
+// Succeed сообщает, что попытка из Reserve удалась: счетчик аккаунта
+// сбрасывается, а со счетчика IP попытка снимается. Сам счетчик IP не
+// сбрасывается, иначе перебор можно чередовать со входами в свой аккаунт.
+func (guard *LoginGuard) Succeed(email, ip string) error {
+	if err := guard.Store.Reset(accountKey(email)); err != nil {
+		return err
+	}
+	return guard.Store.Release(ipKey(ip))
+}
+
+// Cancel снимает попытку из Reserve, если ее исход неизвестен, например
+// из-за ошибки базы.
+func (guard *LoginGuard) Cancel(email, ip string) {
+	guard.release(accountKey(email), ipKey(ip))
+}
+
+func (guard *LoginGuard) release(keys ...string) {
+	for _, key := range keys {
+		if err := guard.Store.Release(key); err != nil {
+			log.Printf("auth: не удалось снять попытку входа %s: %v", key, err)
+		}
+	}
+}
+
+func (guard *LoginGuard) lockIfNeeded(key string, threshold int64, now time.Time) (bool, error) {
+	if threshold <= 0 {
+		return false, nil
+	}
+	attempt, err := guard.Store.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if attempt.Failures < threshold || now.Before(attempt.LockedUntil) {
+		return false, nil
+	}
+	if err := guard.Store.Lock(key, now.Add(guard.Config.LockoutDuration)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// wait — сколько осталось ждать до следующей попытки.
+func (guard *LoginGuard) wait(attempt LoginAttempt, now time.Time) time.Duration {
+	if now.Before(attempt.LockedUntil) {
+		return attempt.LockedUntil.Sub(now)
+	}
+	if now.Sub(attempt.LastFailedAt) > guard.Config.Window {
+		return 0
+	}
+	return max(0, attempt.LastFailedAt.Add(guard.backoff(attempt.Failures)).Sub(now))
+}
+
+// backoff — пауза после failures неудач: 0 для первых FreeAttempts, затем
+// BackoffBase, удваиваясь, но не больше BackoffMax.
+func (guard *LoginGuard) backoff(failures int64) time.Duration {
+	extra := failures - guard.Config.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+	delay := guard.Config.BackoffBase
+	for i := int64(1); i < extra && delay < guard.Config.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, guard.Config.BackoffMax)
+}
@@ -2,16 +2,24 @@ package auth
 
 import (
 	"coffee/configs"
+	"coffee/internal/user"
 	"coffee/pkg/jwt"
+	"coffee/pkg/middleware"
+	"coffee/pkg/qr"
 	"coffee/pkg/req"
 	"coffee/pkg/res"
+	"encoding/base64"
+	"errors"
+	"math"
 	"net/http"
-	"time"
+	"net/url"
+	"strconv"
 )
 
 type AuthHandlerDeps struct {
 	*configs.Config
 	*AuthService
+	Auth *middleware.Auth
 }
 
 type AuthHandler struct {
@@ -26,16 +34,35 @@ func NewAuthHandler(router *http.ServeMux, deps AuthHandlerDeps) {
 	}
 	router.HandleFunc("POST /auth/login", handler.Login())
 	router.HandleFunc("POST /auth/register", handler.Register())
+	router.HandleFunc("POST /auth/login/2fa", handler.LoginTwoFactor())
 	router.HandleFunc("POST /auth/refresh", handler.Refresh())
+	router.HandleFunc("GET /.well-known/jwks.json", handler.JWKS())
+	router.HandleFunc("GET /auth/oidc/providers", handler.OIDCProviders())
+	router.HandleFunc("GET /auth/oidc/{provider}/start", handler.StartOIDC())
+	router.Handle("POST /auth/oidc/{provider}/link", middleware.IsAuthed(middleware.OwnerOnly(handler.LinkOIDC()), deps.Auth))
+	router.HandleFunc("GET /auth/oidc/{provider}/callback", handler.OIDCCallback())
+	router.HandleFunc("POST /auth/oidc/token", handler.OIDCToken())
+	router.Handle("POST /auth/2fa/enroll", middleware.IsAuthed(middleware.OwnerOnly(handler.EnrollTOTP()), deps.Auth))
+	router.Handle("POST /auth/2fa/confirm", middleware.IsAuthed(middleware.OwnerOnly(handler.ConfirmTOTP()), deps.Auth))
+	router.Handle("POST /auth/2fa/disable", middleware.IsAuthed(middleware.OwnerOnly(handler.DisableTOTP()), deps.Auth))
+	router.Handle("POST /auth/2fa/recovery-codes", middleware.IsAuthed(middleware.OwnerOnly(handler.RegenerateRecoveryCodes()), deps.Auth))
+	router.HandleFunc("POST /auth/email/verify", handler.VerifyEmail())
+	router.HandleFunc("POST /auth/email/resend", handler.ResendVerification())
+	router.HandleFunc("POST /auth/password/forgot", handler.ForgotPassword())
+	router.HandleFunc("POST /auth/password/reset", handler.ResetPassword())
+	router.Handle("POST /auth/logout", middleware.IsAuthed(handler.Logout(), deps.Auth))
+	router.Handle("POST /auth/logout-all", middleware.IsAuthed(handler.LogoutAll(), deps.Auth))
 }
 
 // @Summary Регистрация нового пользователя
-// @Description Регистрирует нового пользователя и возвращает JWT токен
+// @Description Регистрирует нового пользователя, отправляет ссылку подтверждения email и возвращает JWT токен
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body RegisterRequest true "Данные для регистрации"
 // @Success 201 {object} RegisterResponse "Успешная регистрация"
+// @Success 202 {object} RegisterResponse "Нужно подтвердить email (UNVERIFIED_LOGIN=deny)"
+// @Failure 409 {string} string "user already exists"
 // @Router /auth/register [post]
 func (handler *AuthHandler) Register() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -43,21 +70,20 @@ func (handler *AuthHandler) Register() http.HandlerFunc {
 		if err != nil {
 			return
 		}
-		email, err := handler.AuthService.Register(body.Name, body.Email, body.Password)
+		registered, err := handler.AuthService.Register(body.Name, body.Email, body.Password)
+		if errors.Is(err, ErrUserExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		jwtService := jwt.NewJWT(
-			handler.Config.Auth.AccessSecret,
-			handler.Config.Auth.RefreshSecret,
-		)
-		tokens, err := jwtService.CreateTokenPair(
-			email,
-			15*time.Minute, // access token на 15 минут
-			24*7*time.Hour, // refresh token на 7 дней
-		)
-
+		if !handler.AuthService.CanLogin(registered) {
+			res.Json(w, RegisterResponse{VerificationRequired: true}, http.StatusAccepted)
+			return
+		}
+		tokens, err := handler.AuthService.IssueTokens(registered, clientInfo(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -78,6 +104,10 @@ func (handler *AuthHandler) Register() http.HandlerFunc {
 // @Produce json
 // @Param request body LoginRequest true "Данные для регистрации"
 // @Success 201 {object} LoginResponse "Успешная регистрация"
+// @Success 200 {object} LoginResponse "Нужен второй шаг: код TOTP на /auth/login/2fa"
+// @Failure 401 {string} string "invalid email or password"
+// @Failure 403 {string} string "email is not verified / account is disabled"
+// @Failure 429 {string} string "too many login attempts"
 // @Router /auth/login [post]
 func (handler *AuthHandler) Login() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -86,39 +116,80 @@ func (handler *AuthHandler) Login() http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		email, err := handler.AuthService.Login(body.Email, body.Password)
+		existedUser, err := handler.AuthService.Login(body.Email, body.Password, clientInfo(r))
+		var tooMany *TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			seconds := int(math.Ceil(tooMany.RetryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if errors.Is(err, ErrInvalidCredentials) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrAccountDisabled) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		jwtService := jwt.NewJWT(
-			handler.Config.Auth.AccessSecret,
-			handler.Config.Auth.RefreshSecret,
-		)
-		tokens, err := jwtService.CreateTokenPair(
-			email,
-			15*time.Minute, // access token на 15 минут
-			24*7*time.Hour, // refresh token на 7 дней
-		)
+		handler.respondLogin(w, r, existedUser)
+	}
+}
+
+// respondLogin выдает токены вошедшему пользователю или, если включен
+// TOTP, токен второго шага.
+func (handler *AuthHandler) respondLogin(w http.ResponseWriter, r *http.Request, existedUser *user.User) {
+	if existedUser.TwoFactorEnabled() {
+		challenge, err := handler.AuthService.Challenge(existedUser)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		data := LoginResponse{
-			AccessToken:  tokens.AccessToken,
-			RefreshToken: tokens.RefreshToken,
-		}
-		res.Json(w, data, 201)
+		res.Json(w, LoginResponse{
+			EmailVerified:     existedUser.EmailVerified(),
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		}, http.StatusOK)
+		return
+	}
+	tokens, err := handler.AuthService.IssueTokens(existedUser, clientInfo(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := LoginResponse{
+		AccessToken:   tokens.AccessToken,
+		RefreshToken:  tokens.RefreshToken,
+		EmailVerified: existedUser.EmailVerified(),
+	}
+	res.Json(w, data, 201)
+}
+
+// @Summary Открытые ключи access-токенов
+// @Description JWKS для проверки access-токенов другими сервисами. Публикуются только ключи RS256 и EdDSA, включая старые ключи, которые еще принимаются после ротации.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} jwt.JWKS
+// @Router /.well-known/jwks.json [get]
+func (handler *AuthHandler) JWKS() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		res.Json(w, handler.Config.Auth.AccessKeys.JWKS(), http.StatusOK)
 	}
 }
 
 // @Summary Обновление токена доступа
-// @Description Обновляет access token используя refresh token
+// @Description Обменивает refresh token на новую пару токенов. Старый refresh token перестает действовать, а его повторное использование отзывает все токены, полученные из того же входа.
 // @Tags auth
 // @Accept json
 // @Produce json
 // @Param request body RefreshRequest true "Refresh токен"
 // @Success 200 {object} RefreshResponse "Новая пара токенов"
+// @Failure 401 {string} string "Invalid refresh token, refresh token has expired или refresh token reuse detected"
 // @Router /auth/refresh [post]
 func (handler *AuthHandler) Refresh() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -127,43 +198,530 @@ func (handler *AuthHandler) Refresh() http.HandlerFunc {
 			return
 		}
 
-		jwtService := jwt.NewJWT(
-			handler.Config.Auth.AccessSecret,
-			handler.Config.Auth.RefreshSecret,
-		)
-
-		// Проверяем и парсим refresh токен
-		flag, claims := jwtService.ParseRefreshToken(body.RefreshToken)
-		if !flag {
+		tokens, err := handler.AuthService.Refresh(body.RefreshToken, clientInfo(r))
+		if errors.Is(err, ErrRefreshTokenReused) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrRefreshTokenExpired) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrInvalidRefreshToken) {
 			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
 			return
 		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data := RefreshResponse{
+			AccessToken:  tokens.AccessToken,
+			RefreshToken: tokens.RefreshToken,
+		}
 
-		// Вычисляем оставшееся время жизни refresh токена
-		expirationTime := time.Unix(claims.ExpiresAt.Unix(), 0)
-		remainingTime := time.Until(expirationTime)
+		res.Json(w, data, http.StatusOK)
+	}
+}
+
+// @Summary Выход
+// @Description Отзывает текущий access token и refresh token этого входа
+// @Tags auth
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 204 {string} string "No Content"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /auth/logout [post]
+func (handler *AuthHandler) Logout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(middleware.ContextClaimsKey).(*jwt.JWTData)
+		if claims == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err := handler.AuthService.Logout(claims); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Выход на всех устройствах
+// @Description Отзывает все refresh-токены пользователя и выданные с ними access-токены
+// @Tags auth
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 204 {string} string "No Content"
+// @Failure 401 {string} string "Unauthorized"
+// @Router /auth/logout-all [post]
+func (handler *AuthHandler) LogoutAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := r.Context().Value(middleware.ContextClaimsKey).(*jwt.JWTData)
+		if claims == nil {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if err := handler.AuthService.LogoutAll(claims); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
 
-		if remainingTime <= 0 {
-			http.Error(w, "Refresh token has expired", http.StatusUnauthorized)
+// @Summary Подтверждение email
+// @Description Подтверждает email по токену из письма. Права роли в токенах появятся после следующего входа или обновления токена.
+// @Tags auth
+// @Accept json
+// @Param request body VerifyEmailRequest true "Токен из письма"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {string} string "invalid or expired token"
+// @Router /auth/email/verify [post]
+func (handler *AuthHandler) VerifyEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[VerifyEmailRequest](&w, r)
+		if err != nil {
+			return
+		}
+		err = handler.AuthService.VerifyEmail(body.Token)
+		if errors.Is(err, ErrInvalidActionToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
 
-		// Генерируем только новый access токен
-		accessToken, err := jwtService.Create(jwt.JWTData{
-			Email:     claims.Email,
-			ExpiresAt: time.Now().Add(15 * time.Minute),
-			TokenType: "access",
-		}, jwtService.AccessSecret)
+// @Summary Повторная отправка подтверждения
+// @Description Отправляет новую ссылку подтверждения email, прежняя перестает действовать. Ответ не зависит от того, зарегистрирован ли адрес.
+// @Tags auth
+// @Accept json
+// @Param request body ResendVerificationRequest true "Email"
+// @Success 202 {string} string "Accepted"
+// @Router /auth/email/resend [post]
+func (handler *AuthHandler) ResendVerification() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[ResendVerificationRequest](&w, r)
+		if err != nil {
+			return
+		}
+		if err := handler.AuthService.ResendVerification(body.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
 
+// @Summary Забыли пароль
+// @Description Отправляет на email одноразовую ссылку для сброса пароля. Ответ не зависит от того, зарегистрирован ли адрес.
+// @Tags auth
+// @Accept json
+// @Param request body ForgotPasswordRequest true "Email"
+// @Success 202 {string} string "Accepted"
+// @Router /auth/password/forgot [post]
+func (handler *AuthHandler) ForgotPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[ForgotPasswordRequest](&w, r)
 		if err != nil {
+			return
+		}
+		if err := handler.AuthService.ForgotPassword(body.Email); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
 
-		data := RefreshResponse{
-			AccessToken: accessToken,
+// @Summary Сброс пароля
+// @Description Задает новый пароль по токену из письма. Все входы пользователя завершаются.
+// @Tags auth
+// @Accept json
+// @Param request body ResetPasswordRequest true "Токен и новый пароль"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {string} string "invalid or expired token"
+// @Router /auth/password/reset [post]
+func (handler *AuthHandler) ResetPassword() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[ResetPasswordRequest](&w, r)
+		if err != nil {
+			return
+		}
+		err = handler.AuthService.ResetPassword(body.Token, body.Password)
+		if errors.Is(err, ErrInvalidActionToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
 
-		res.Json(w, data, http.StatusOK)
+// @Summary Второй шаг входа
+// @Description Принимает токен из /auth/login и код из приложения-аутентификатора или код восстановления, возвращает пару токенов
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body LoginTwoFactorRequest true "Токен второго шага и код"
+// @Success 201 {object} LoginResponse
+// @Failure 401 {string} string "invalid code"
+// @Failure 429 {string} string "too many login attempts"
+// @Router /auth/login/2fa [post]
+func (handler *AuthHandler) LoginTwoFactor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[LoginTwoFactorRequest](&w, r)
+		if err != nil {
+			return
+		}
+		existedUser, err := handler.AuthService.CompleteLogin(body.ChallengeToken, body.Code, clientInfo(r))
+		var tooMany *TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		if errors.Is(err, ErrInvalidChallenge) || errors.Is(err, ErrInvalidCode) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tokens, err := handler.AuthService.IssueTokens(existedUser, clientInfo(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, LoginResponse{
+			AccessToken:   tokens.AccessToken,
+			RefreshToken:  tokens.RefreshToken,
+			EmailVerified: existedUser.EmailVerified(),
+		}, http.StatusCreated)
+	}
+}
+
+// @Summary Подключение TOTP
+// @Description Выпускает секрет для приложения-аутентификатора и QR-код с ним. Двухфакторный вход включится после подтверждения кодом.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 200 {object} TOTPEnrollResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "two-factor authentication is already enabled"
+// @Router /auth/2fa/enroll [post]
+func (handler *AuthHandler) EnrollTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		secret, link, err := handler.AuthService.EnrollTOTP(email)
+		if errors.Is(err, ErrTwoFactorEnabled) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		png, err := qr.Render(link, qr.Options{Format: qr.PNG, Size: 256, Margin: 4})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		res.Json(w, TOTPEnrollResponse{
+			Secret:     secret,
+			OtpauthURL: link,
+			QRCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(png),
+		}, http.StatusOK)
+	}
+}
+
+// @Summary Подтверждение TOTP
+// @Description Включает двухфакторный вход по коду из приложения и возвращает коды восстановления. Коды показываются один раз.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body TOTPCodeRequest true "Код из приложения"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {string} string "invalid code"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 409 {string} string "two-factor authentication is already enabled"
+// @Router /auth/2fa/confirm [post]
+func (handler *AuthHandler) ConfirmTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[TOTPCodeRequest](&w, r)
+		if err != nil {
+			return
+		}
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		codes, err := handler.AuthService.ConfirmTOTP(email, body.Code)
+		if !writeTwoFactorError(w, err) {
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		res.Json(w, RecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+	}
+}
+
+// @Summary Отключение TOTP
+// @Tags auth
+// @Accept json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body TOTPDisableRequest true "Пароль (или токен из письма для аккаунта без пароля) и код из приложения или код восстановления"
+// @Success 204 {string} string "No Content"
+// @Failure 400 {string} string "invalid code / account has no password, confirm by email"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 429 {string} string "too many login attempts"
+// @Router /auth/2fa/disable [post]
+func (handler *AuthHandler) DisableTOTP() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[TOTPDisableRequest](&w, r)
+		if err != nil {
+			return
+		}
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		err = handler.AuthService.DisableTOTP(email, Reauth{
+			Password: body.Password,
+			Token:    body.ReauthToken,
+			Code:     body.Code,
+			Client:   clientInfo(r),
+		})
+		if !writeTwoFactorError(w, err) {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Новые коды восстановления
+// @Description Заменяет коды восстановления новыми, прежние перестают действовать
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body TOTPCodeRequest true "Код из приложения или код восстановления"
+// @Success 200 {object} RecoveryCodesResponse
+// @Failure 400 {string} string "invalid code"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 429 {string} string "too many login attempts"
+// @Router /auth/2fa/recovery-codes [post]
+func (handler *AuthHandler) RegenerateRecoveryCodes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[TOTPCodeRequest](&w, r)
+		if err != nil {
+			return
+		}
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		codes, err := handler.AuthService.RegenerateRecoveryCodes(email, body.Code, clientInfo(r))
+		if !writeTwoFactorError(w, err) {
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		res.Json(w, RecoveryCodesResponse{RecoveryCodes: codes}, http.StatusOK)
+	}
+}
+
+// writeTwoFactorError отвечает ошибкой управления TOTP. Возвращает true,
+// если ошибки нет.
+func writeTwoFactorError(w http.ResponseWriter, err error) bool {
+	var tooMany *TooManyAttemptsError
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrInvalidCode), errors.Is(err, ErrInvalidCredentials),
+		errors.Is(err, ErrTwoFactorNotPending), errors.Is(err, ErrTwoFactorNotEnabled),
+		errors.Is(err, ErrPasswordNotSet), errors.Is(err, ErrInvalidReauthToken):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrTwoFactorEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.As(err, &tooMany):
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(tooMany.RetryAfter.Seconds()))))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+func clientInfo(r *http.Request) ClientInfo {
+	return ClientInfo{
+		UserAgent: r.UserAgent(),
+		IP:        req.ClientIP(r),
+	}
+}
+
+// @Summary Провайдеры входа
+// @Description Имена настроенных провайдеров OpenID Connect для кнопок «Войти через ...».
+// @Tags auth
+// @Produce json
+// @Success 200 {array} string
+// @Router /auth/oidc/providers [get]
+func (handler *AuthHandler) OIDCProviders() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res.Json(w, handler.AuthService.OIDCProviders(), http.StatusOK)
+	}
+}
+
+// @Summary Вход через провайдера
+// @Description Перенаправляет на страницу входа провайдера (authorization code с PKCE) и ставит cookie oidc_state, без которой возврат не примется. После входа провайдер вернет пользователя на /auth/oidc/{provider}/callback.
+// @Tags auth
+// @Param provider path string true "Провайдер" example(google)
+// @Success 302 {string} string "Перенаправление к провайдеру"
+// @Failure 404 {string} string "unknown oidc provider"
+// @Failure 502 {string} string "Провайдер недоступен"
+// @Router /auth/oidc/{provider}/start [get]
+func (handler *AuthHandler) StartOIDC() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, state, err := handler.AuthService.StartOIDC(r.Context(), r.PathValue("provider"), "")
+		if errors.Is(err, ErrUnknownProvider) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		http.SetCookie(w, handler.AuthService.OIDCStateCookie(state))
+		http.Redirect(w, r, link, http.StatusFound)
+	}
+}
+
+// @Summary Привязать провайдера к аккаунту
+// @Description Возвращает адрес страницы входа провайдера и ставит cookie oidc_state (запрос нужно отправлять с credentials); после входа его аккаунт будет привязан к текущему пользователю. Нужно для провайдеров, которые не сообщают email.
+// @Tags auth
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param provider path string true "Провайдер" example(telegram)
+// @Success 200 {object} OIDCLinkResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 404 {string} string "unknown oidc provider"
+// @Failure 502 {string} string "Провайдер недоступен"
+// @Router /auth/oidc/{provider}/link [post]
+func (handler *AuthHandler) LinkOIDC() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		email, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		link, state, err := handler.AuthService.StartOIDC(r.Context(), r.PathValue("provider"), email)
+		if errors.Is(err, ErrUnknownProvider) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		http.SetCookie(w, handler.AuthService.OIDCStateCookie(state))
+		res.Json(w, OIDCLinkResponse{URL: link}, http.StatusOK)
+	}
+}
+
+// @Summary Возврат от провайдера
+// @Description Завершает вход через провайдера, если state совпадает с cookie oidc_state, и перенаправляет на страницу фронтенда OIDC_LOGIN_PATH с параметром code (обменять на токены через /auth/oidc/token), linked (провайдер привязан) или error.
+// @Tags auth
+// @Param provider path string true "Провайдер"
+// @Param code query string false "Код авторизации"
+// @Param state query string false "State из /start"
+// @Success 302 {string} string "Перенаправление на фронтенд"
+// @Router /auth/oidc/{provider}/callback [get]
+func (handler *AuthHandler) OIDCCallback() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider := r.PathValue("provider")
+		query := r.URL.Query()
+		redirect := func(values url.Values) {
+			target := handler.Config.Qr.PublicBaseURL + handler.Config.OIDC.LoginPath + "?" + values.Encode()
+			http.Redirect(w, r, target, http.StatusFound)
+		}
+		stateErr := handler.AuthService.CheckOIDCState(r, query.Get("state"))
+		http.SetCookie(w, handler.AuthService.OIDCStateCookie(""))
+		if providerError := query.Get("error"); providerError != "" {
+			redirect(url.Values{"error": {providerError}})
+			return
+		}
+		if stateErr != nil {
+			redirect(url.Values{"error": {oidcErrorCode(stateErr)}})
+			return
+		}
+		existedUser, linked, err := handler.AuthService.CompleteOIDC(r.Context(), provider, query.Get("state"), query.Get("code"))
+		if err != nil {
+			redirect(url.Values{"error": {oidcErrorCode(err)}})
+			return
+		}
+		if linked {
+			redirect(url.Values{"linked": {provider}})
+			return
+		}
+		code, err := handler.AuthService.OIDCLoginCode(existedUser)
+		if err != nil {
+			redirect(url.Values{"error": {"server_error"}})
+			return
+		}
+		redirect(url.Values{"code": {code}})
+	}
+}
+
+// @Summary Токены после входа через провайдера
+// @Description Обменивает одноразовый code из перенаправления на пару токенов или, если включен TOTP, на challengeToken для /auth/login/2fa.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param request body OIDCTokenRequest true "Код из перенаправления"
+// @Success 201 {object} LoginResponse
+// @Success 200 {object} LoginResponse "Нужен второй шаг: код TOTP на /auth/login/2fa"
+// @Failure 401 {string} string "invalid or expired login code"
+// @Failure 403 {string} string "email is not verified / account is disabled"
+// @Router /auth/oidc/token [post]
+func (handler *AuthHandler) OIDCToken() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[OIDCTokenRequest](&w, r)
+		if err != nil {
+			return
+		}
+		existedUser, err := handler.AuthService.ExchangeOIDCCode(body.Code)
+		if errors.Is(err, ErrInvalidOIDCLogin) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, ErrEmailNotVerified) || errors.Is(err, ErrAccountDisabled) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		handler.respondLogin(w, r, existedUser)
+	}
+}
+
+// oidcErrorCode — код ошибки входа через провайдера для фронтенда.
+func oidcErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownProvider):
+		return "unknown_provider"
+	case errors.Is(err, ErrInvalidOIDCState):
+		return "invalid_state"
+	case errors.Is(err, ErrOIDCEmailRequired):
+		return "email_required"
+	case errors.Is(err, ErrIdentityLinked):
+		return "identity_linked"
+	case errors.Is(err, ErrOIDCProviderFailed):
+		return "provider_error"
+	default:
+		return "server_error"
 	}
 }
@@ -0,0 +1,139 @@
+package auth
+
+import "time"
+
+// RefreshToken — выданный refresh token. Хранится только хеш. Токены,
+// полученные друг из друга обменом, образуют семейство FamilyID: повторное
+// предъявление уже обмененного токена отзывает все семейство.
+type RefreshToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"index;not null"`
+	FamilyID  string    `gorm:"size:36;index;not null"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	UserAgent string `gorm:"size:255"`
+	IP        string `gorm:"size:45"`
+	// AccessJTI — access token, выданный вместе с этим refresh token.
+	AccessJTI       string `gorm:"size:36;index"`
+	AccessExpiresAt time.Time
+}
+
+// Session — вход пользователя (Login, Register, вход через провайдера):
+// семейство refresh-токенов с общим FamilyID. Вход действует, пока в
+// семействе есть неиспользованный и неотозванный refresh token. IP и
+// User-Agent обновляются при каждом обмене refresh-токена.
+type Session struct {
+	ID         uint `gorm:"primaryKey"`
+	CreatedAt  time.Time
+	UserID     uint   `gorm:"index;not null"`
+	FamilyID   string `gorm:"size:36;uniqueIndex;not null"`
+	UserAgent  string `gorm:"size:255"`
+	IP         string `gorm:"size:45"`
+	LastSeenAt time.Time
+}
+
+// ImpersonationToken — access token входа под пользователем. Refresh
+// token к нему не выдается, поэтому jti хранится здесь, чтобы отозвать
+// токен вместе с остальными входами пользователя.
+type ImpersonationToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"index;not null"`
+	ActorID   uint      `gorm:"not null"`
+	JTI       string    `gorm:"size:36;uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
+// RevokedToken — access token, отозванный до истечения срока.
+type RevokedToken struct {
+	JTI       string    `gorm:"size:36;primaryKey"`
+	CreatedAt time.Time `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
+// ClientInfo — откуда пришел запрос на выпуск токенов.
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// Назначения одноразовых токенов из писем.
+const (
+	PurposePasswordReset = "password_reset"
+	PurposeEmailVerify   = "email_verify"
+	// PurposeOIDCLogin — код, который фронтенд после входа через
+	// провайдера обменивает на токены.
+	PurposeOIDCLogin = "oidc_login"
+	// PurposeTwoFactorChallenge — jti токена второго шага входа. Отметка
+	// об использовании в базе делает токен одноразовым на всех узлах.
+	PurposeTwoFactorChallenge = "2fa_challenge"
+)
+
+// Политики входа с неподтвержденным email, см. configs.AuthConfig.
+const (
+	UnverifiedAllow   = "allow"
+	UnverifiedLimited = "limited"
+	UnverifiedDeny    = "deny"
+)
+
+// ActionToken — одноразовый токен из письма. Хранится только хеш.
+type ActionToken struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"index;not null"`
+	Purpose   string    `gorm:"size:32;not null"`
+	TokenHash string    `gorm:"size:64;uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
+
+// LoginAttempt — счетчик неудачных входов по ключу (аккаунт или IP).
+type LoginAttempt struct {
+	Key          string    `gorm:"size:100;primaryKey"`
+	Failures     int64     `gorm:"not null;default:0"`
+	LastFailedAt time.Time `gorm:"index;not null"`
+	LockedUntil  time.Time
+}
+
+// RecoveryCode — одноразовый код восстановления на случай потери
+// приложения-аутентификатора. Хранится только хеш.
+type RecoveryCode struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"size:64;not null"`
+	UsedAt    *time.Time
+}
+
+// Identity — аккаунт пользователя у внешнего провайдера OpenID Connect.
+// Пользователь находится по паре провайдер и subject, а не по email:
+// email у провайдера может смениться.
+type Identity struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	Provider  string `gorm:"size:32;not null;uniqueIndex:idx_identity_subject"`
+	Subject   string `gorm:"size:255;not null;uniqueIndex:idx_identity_subject"`
+	Email     string `gorm:"size:255"`
+}
+
+// OIDCState — начатый вход через провайдера: state из ссылки, nonce для
+// id_token и PKCE verifier. UserID задан, если провайдер привязывают к
+// уже вошедшему пользователю.
+type OIDCState struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	StateHash string `gorm:"size:64;uniqueIndex;not null"`
+	Provider  string `gorm:"size:32;not null"`
+	Nonce     string `gorm:"size:64;not null"`
+	Verifier  string `gorm:"size:64;not null"`
+	UserID    *uint
+	ExpiresAt time.Time `gorm:"index;not null"`
+}
+
+func (OIDCState) TableName() string {
+	return "oidc_states"
+}
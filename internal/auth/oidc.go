@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"coffee/configs"
+	"coffee/internal/user"
+	"coffee/pkg/oidc"
+	"coffee/pkg/req"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// oidcLoginTTL — сколько действует код, с которым фронтенд забирает
+	// токены после возвращения от провайдера.
+	oidcLoginTTL    = 2 * time.Minute
+	oidcStateCookie = "oidc_state"
+)
+
+var (
+	ErrUnknownProvider    = errors.New("unknown oidc provider")
+	ErrInvalidOIDCState   = errors.New("invalid or expired oidc state")
+	ErrOIDCEmailRequired  = errors.New("provider did not return a verified email")
+	ErrIdentityLinked     = errors.New("provider account is linked to another user")
+	ErrInvalidOIDCLogin   = errors.New("invalid or expired login code")
+	ErrOIDCProviderFailed = errors.New("oidc provider error")
+)
+
+func newOIDCProviders(conf *configs.Config) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for _, provider := range conf.OIDC.Providers {
+		redirectURL := conf.Qr.PublicBaseURL + "/auth/oidc/" + provider.Name + "/callback"
+		providers[provider.Name] = oidc.NewProvider(provider.Name, provider.Issuer,
+			provider.ClientID, provider.ClientSecret, redirectURL, provider.Scopes)
+	}
+	return providers
+}
+
+// OIDCProviders — имена настроенных провайдеров.
+func (service *AuthService) OIDCProviders() []string {
+	names := make([]string, 0, len(service.providers))
+	for name := range service.providers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// StartOIDC начинает вход через провайдера и возвращает адрес его страницы
+// входа и state, который нужно привязать к браузеру (см. OIDCStateCookie).
+// Если linkEmail задан, аккаунт провайдера привязывается к этому
+// пользователю.
+func (service *AuthService) StartOIDC(ctx context.Context, providerName, linkEmail string) (link, rawState string, err error) {
+	provider, ok := service.providers[providerName]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	state := &OIDCState{
+		Provider:  providerName,
+		Nonce:     oidc.RandomString(),
+		Verifier:  oidc.RandomString(),
+		ExpiresAt: time.Now().Add(service.Config.OIDC.StateTTL),
+	}
+	if linkEmail != "" {
+		existedUser, err := service.UserRepository.GetByEmail(linkEmail)
+		if err != nil {
+			return "", "", err
+		}
+		state.UserID = &existedUser.ID
+	}
+	rawState = oidc.RandomString()
+	state.StateHash = hashToken(rawState)
+	link, err = provider.AuthCodeURL(ctx, rawState, state.Nonce, state.Verifier)
+	if err != nil {
+		return "", "", err
+	}
+	if err := service.OIDCStateRepository.Create(state); err != nil {
+		return "", "", err
+	}
+	return link, rawState, nil
+}
+
+// OIDCStateCookie — cookie, которая привязывает state к браузеру, начавшему
+// вход. Без нее ссылку возврата от провайдера можно подсунуть другому
+// пользователю и войти им в чужой аккаунт провайдера. В cookie лежит хеш
+// state; пустой rawState удаляет cookie.
+func (service *AuthService) OIDCStateCookie(rawState string) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/oidc/",
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(service.Config.Qr.PublicBaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if rawState == "" {
+		cookie.MaxAge = -1
+		return cookie
+	}
+	cookie.Value = hashToken(rawState)
+	cookie.MaxAge = int(service.Config.OIDC.StateTTL.Seconds())
+	return cookie
+}
+
+// CheckOIDCState сверяет state из возврата от провайдера с cookie браузера.
+func (service *AuthService) CheckOIDCState(r *http.Request, rawState string) error {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || rawState == "" ||
+		subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(hashToken(rawState))) != 1 {
+		return ErrInvalidOIDCState
+	}
+	return nil
+}
+
+// CompleteOIDC завершает вход по code и state из возврата от провайдера
+// и возвращает пользователя. linked сообщает, что это была привязка
+// провайдера к уже вошедшему пользователю.
+func (service *AuthService) CompleteOIDC(ctx context.Context, providerName, rawState, code string) (u *user.User, linked bool, err error) {
+	provider, ok := service.providers[providerName]
+	if !ok {
+		return nil, false, ErrUnknownProvider
+	}
+	state, err := service.OIDCStateRepository.Consume(hashToken(rawState))
+	if err != nil || state.Provider != providerName {
+		return nil, false, ErrInvalidOIDCState
+	}
+	claims, err := provider.Exchange(ctx, code, state.Verifier, state.Nonce)
+	if err != nil {
+		log.Printf("auth: вход через %s не удался: %v", providerName, err)
+		return nil, false, ErrOIDCProviderFailed
+	}
+	u, err = service.oidcUser(providerName, claims, state.UserID)
+	if err != nil {
+		return nil, false, err
+	}
+	return u, state.UserID != nil, nil
+}
+
+// oidcUser находит пользователя по аккаунту провайдера. Новый аккаунт
+// провайдера привязывается к пользователю, который его привязывает, или к
+// пользователю с тем же email, если провайдер подтвердил email; иначе
+// создается новый пользователь.
+func (service *AuthService) oidcUser(providerName string, claims *oidc.Claims, linkUserID *uint) (*user.User, error) {
+	identity, err := service.IdentityRepository.GetBySubject(providerName, claims.Subject)
+	if err == nil {
+		if linkUserID != nil && *linkUserID != identity.UserID {
+			return nil, ErrIdentityLinked
+		}
+		return service.UserRepository.GetByID(identity.UserID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	var u *user.User
+	switch {
+	case linkUserID != nil:
+		u, err = service.UserRepository.GetByID(*linkUserID)
+	case claims.Email != "" && claims.EmailVerified:
+		u, err = service.oidcUserByEmail(claims)
+	default:
+		return nil, ErrOIDCEmailRequired
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = service.IdentityRepository.Create(&Identity{
+		UserID:   u.ID,
+		Provider: providerName,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// oidcUserByEmail находит пользователя по email, подтвержденному
+// провайдером, или регистрирует нового. Если email существующего аккаунта
+// не был подтвержден, аккаунт мог завести кто угодно: его пароль
+// сбрасывается, а входы завершаются.
+func (service *AuthService) oidcUserByEmail(claims *oidc.Claims) (*user.User, error) {
+	email := strings.ToLower(claims.Email)
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err == nil {
+		if existedUser.EmailVerified() {
+			return existedUser, nil
+		}
+		log.Printf("auth: email %s подтвержден провайдером, пароль неподтвержденного аккаунта сброшен", email)
+		if err := service.UserRepository.UpdatePassword(existedUser.ID, unusablePassword()); err != nil {
+			return nil, err
+		}
+		if err := service.revokeUser(existedUser.ID); err != nil {
+			return nil, err
+		}
+		if err := service.UserRepository.MarkEmailVerified(existedUser.ID); err != nil {
+			return nil, err
+		}
+		return service.UserRepository.GetByID(existedUser.ID)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	name := claims.Name
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	now := time.Now()
+	return service.UserRepository.CreateUser(&user.User{
+		Name:            req.Truncate(name, 50),
+		Email:           email,
+		Password:        unusablePassword(),
+		Role:            user.RoleCustomer,
+		EmailVerifiedAt: &now,
+	})
+}
+
+// OIDCLoginCode выпускает одноразовый код, который фронтенд обменивает
+// на токены через /auth/oidc/token. Сами токены не попадают в адрес
+// перенаправления.
+func (service *AuthService) OIDCLoginCode(u *user.User) (string, error) {
+	return service.newActionToken(u.ID, PurposeOIDCLogin, oidcLoginTTL)
+}
+
+// ExchangeOIDCCode возвращает пользователя по коду из OIDCLoginCode.
+func (service *AuthService) ExchangeOIDCCode(code string) (*user.User, error) {
+	action, err := service.ActionTokenRepository.Consume(hashToken(code), PurposeOIDCLogin)
+	if err != nil {
+		return nil, ErrInvalidOIDCLogin
+	}
+	existedUser, err := service.UserRepository.GetByID(action.UserID)
+	if err != nil {
+		return nil, ErrInvalidOIDCLogin
+	}
+	if existedUser.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	if !service.CanLogin(existedUser) {
+		return nil, ErrEmailNotVerified
+	}
+	return existedUser, nil
+}
+
+// unusablePassword — значение Password для аккаунта без пароля (см.
+// user.NoPasswordPrefix). Задать пароль можно через сброс пароля или
+// смену пароля с подтверждением по письму.
+func unusablePassword() string {
+	return user.NoPasswordPrefix + oidc.RandomString()
+}
@@ -0,0 +1,382 @@
+package auth
+
+import (
+	"coffee/configs"
+	"coffee/internal/user"
+	"coffee/pkg/db"
+	"coffee/pkg/oidc/oidctest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const testBaseURL = "https://coffee.example.com"
+
+// oidcTest — API с одним провайдером fake на httptest.Server.
+type oidcTest struct {
+	provider *oidctest.Provider
+	service  *AuthService
+	router   *http.ServeMux
+}
+
+func newOIDCTest(t *testing.T, database *db.Db) *oidcTest {
+	t.Helper()
+	provider, server, err := oidctest.NewServer("coffee", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	conf := &configs.Config{
+		Auth: configs.AuthConfig{UnverifiedLogin: UnverifiedLimited},
+		Qr:   configs.QrConfig{PublicBaseURL: testBaseURL},
+		OIDC: configs.OIDCConfig{
+			Providers: []configs.OIDCProviderConfig{{
+				Name:         "fake",
+				Issuer:       server.URL,
+				ClientID:     "coffee",
+				ClientSecret: "secret",
+				Scopes:       []string{"openid", "email", "profile"},
+			}},
+			LoginPath: "/login",
+			StateTTL:  10 * time.Minute,
+		},
+	}
+	deps := AuthServiceDeps{Config: conf}
+	if database != nil {
+		deps.UserRepository = user.NewUserRepository(database)
+		deps.RefreshTokenRepository = NewRefreshTokenRepository(database)
+		deps.ActionTokenRepository = NewActionTokenRepository(database)
+		deps.IdentityRepository = NewIdentityRepository(database)
+		deps.OIDCStateRepository = NewOIDCStateRepository(database)
+		deps.ImpersonationTokenRepository = NewImpersonationTokenRepository(database)
+		deps.Revocations = NewRevocationStore(NewRevokedTokenRepository(database))
+	}
+	service := NewAuthService(deps)
+	router := http.NewServeMux()
+	NewAuthHandler(router, AuthHandlerDeps{Config: conf, AuthService: service})
+	return &oidcTest{provider: provider, service: service, router: router}
+}
+
+// start открывает /start и возвращает cookie state и адрес провайдера.
+func (test *oidcTest) start(t *testing.T) (*http.Cookie, string) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	test.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/auth/oidc/fake/start", nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("start: status %d: %s", recorder.Code, recorder.Body)
+	}
+	var cookie *http.Cookie
+	for _, c := range recorder.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("start: no state cookie")
+	}
+	return cookie, recorder.Header().Get("Location")
+}
+
+// authorize проходит страницу входа провайдера и возвращает адрес
+// возврата с code и state.
+func (test *oidcTest) authorize(t *testing.T, link string) *url.URL {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	callback, err := url.Parse(response.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(callback.String(), testBaseURL+"/auth/oidc/fake/callback?") {
+		t.Fatalf("authorize: redirected to %q", response.Header.Get("Location"))
+	}
+	return callback
+}
+
+// callback возвращается от провайдера с cookie и возвращает параметры
+// перенаправления на фронтенд.
+func (test *oidcTest) callback(t *testing.T, callback *url.URL, cookie *http.Cookie) url.Values {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	if cookie != nil {
+		request.AddCookie(cookie)
+	}
+	recorder := httptest.NewRecorder()
+	test.router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("callback: status %d: %s", recorder.Code, recorder.Body)
+	}
+	target, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(target.String(), testBaseURL+"/login?") {
+		t.Fatalf("callback: redirected to %q", recorder.Header().Get("Location"))
+	}
+	cleared := false
+	for _, c := range recorder.Result().Cookies() {
+		cleared = cleared || (c.Name == oidcStateCookie && c.MaxAge < 0)
+	}
+	if !cleared {
+		t.Error("callback: state cookie is not cleared")
+	}
+	return target.Query()
+}
+
+// login проходит весь вход и возвращает параметры перенаправления на
+// фронтенд.
+func (test *oidcTest) login(t *testing.T) url.Values {
+	t.Helper()
+	cookie, link := test.start(t)
+	return test.callback(t, test.authorize(t, link), cookie)
+}
+
+func TestOIDCStateCookie(t *testing.T) {
+	test := newOIDCTest(t, nil)
+	cookie := test.service.OIDCStateCookie("raw-state")
+	if cookie.Value == "" || cookie.Value == "raw-state" {
+		t.Errorf("cookie value = %q, want a hash of the state", cookie.Value)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/auth/oidc/" {
+		t.Errorf("cookie = %+v", cookie)
+	}
+	if cookie.MaxAge != 600 {
+		t.Errorf("cookie MaxAge = %d, want 600", cookie.MaxAge)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/auth/oidc/fake/callback", nil)
+	request.AddCookie(cookie)
+	if err := test.service.CheckOIDCState(request, "raw-state"); err != nil {
+		t.Errorf("matching state: %v", err)
+	}
+	if err := test.service.CheckOIDCState(request, "other-state"); err != ErrInvalidOIDCState {
+		t.Errorf("other state: error = %v, want %v", err, ErrInvalidOIDCState)
+	}
+	if err := test.service.CheckOIDCState(request, ""); err != ErrInvalidOIDCState {
+		t.Errorf("empty state: error = %v, want %v", err, ErrInvalidOIDCState)
+	}
+	empty := httptest.NewRequest(http.MethodGet, "/auth/oidc/fake/callback", nil)
+	if err := test.service.CheckOIDCState(empty, "raw-state"); err != ErrInvalidOIDCState {
+		t.Errorf("no cookie: error = %v, want %v", err, ErrInvalidOIDCState)
+	}
+}
+
+// Возврат без cookie или с cookie другого входа отклоняется до обращения к
+// базе и провайдеру, поэтому репозитории здесь не нужны.
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	test := newOIDCTest(t, nil)
+	callback, _ := url.Parse(testBaseURL + "/auth/oidc/fake/callback?code=code&state=victim-state")
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+	}{
+		{"no cookie", nil},
+		{"cookie of another login", test.service.OIDCStateCookie("attacker-state")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			values := test.callback(t, callback, tc.cookie)
+			if values.Get("error") != "invalid_state" || values.Has("code") {
+				t.Errorf("redirect = %v, want error=invalid_state", values)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackPassesProviderError(t *testing.T) {
+	test := newOIDCTest(t, nil)
+	callback, _ := url.Parse(testBaseURL + "/auth/oidc/fake/callback?error=access_denied&state=s")
+	if values := test.callback(t, callback, nil); values.Get("error") != "access_denied" {
+		t.Errorf("redirect = %v, want error=access_denied", values)
+	}
+}
+
+// Тесты ниже работают с базой: TEST_DATABASE_URL — адрес отдельной
+// тестовой базы Postgres, без него тесты пропускаются.
+func openTestDB(t *testing.T) *db.Db {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	database, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = database.AutoMigrate(&user.User{}, &RefreshToken{}, &RevokedToken{}, &ActionToken{}, &Identity{}, &OIDCState{}, &ImpersonationToken{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &db.Db{DB: database}
+}
+
+// testEmail — уникальный email; пользователь и его данные удаляются после
+// теста.
+func testEmail(t *testing.T, database *db.Db) string {
+	t.Helper()
+	email := uuid.NewString() + "@example.com"
+	t.Cleanup(func() {
+		var found user.User
+		if database.Unscoped().Where("email = ?", email).Limit(1).Find(&found).Error != nil || found.ID == 0 {
+			return
+		}
+		database.Where("user_id = ?", found.ID).Delete(&Identity{})
+		database.Where("user_id = ?", found.ID).Delete(&ActionToken{})
+		database.Where("user_id = ?", found.ID).Delete(&RefreshToken{})
+		database.Unscoped().Delete(&found)
+	})
+	return email
+}
+
+func createTestUser(t *testing.T, database *db.Db, email string, verified bool) *user.User {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &user.User{Name: "Existing", Email: email, Password: string(hash), Role: user.RoleCustomer}
+	if verified {
+		now := time.Now()
+		u.EmailVerifiedAt = &now
+	}
+	created, err := user.NewUserRepository(database).CreateUser(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created
+}
+
+func TestOIDCLoginRoundTrip(t *testing.T) {
+	database := openTestDB(t)
+	test := newOIDCTest(t, database)
+	test.provider.Email = testEmail(t, database)
+
+	values := test.login(t)
+	if values.Get("code") == "" {
+		t.Fatalf("redirect = %v, want code", values)
+	}
+	created, err := test.service.ExchangeOIDCCode(values.Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Email != test.provider.Email || !created.EmailVerified() || created.Role != user.RoleCustomer || created.HasPassword() {
+		t.Errorf("created user = %+v", created)
+	}
+	if _, err := test.service.ExchangeOIDCCode(values.Get("code")); err != ErrInvalidOIDCLogin {
+		t.Errorf("reused login code: error = %v, want %v", err, ErrInvalidOIDCLogin)
+	}
+
+	again, err := test.service.ExchangeOIDCCode(test.login(t).Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != created.ID {
+		t.Errorf("second login: user %d, want %d", again.ID, created.ID)
+	}
+}
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	database := openTestDB(t)
+	test := newOIDCTest(t, database)
+	test.provider.Email = testEmail(t, database)
+
+	// Возврат из одного входа с cookie другого входа того же браузера.
+	_, link := test.start(t)
+	otherCookie, _ := test.start(t)
+	values := test.callback(t, test.authorize(t, link), otherCookie)
+	if values.Get("error") != "invalid_state" {
+		t.Errorf("redirect = %v, want error=invalid_state", values)
+	}
+
+	// State, которого нет в базе, даже с подходящей cookie.
+	forged, _ := url.Parse(testBaseURL + "/auth/oidc/fake/callback?code=code&state=forged")
+	values = test.callback(t, forged, test.service.OIDCStateCookie("forged"))
+	if values.Get("error") != "invalid_state" {
+		t.Errorf("redirect = %v, want error=invalid_state", values)
+	}
+
+	// State одноразовый.
+	cookie, link := test.start(t)
+	callback := test.authorize(t, link)
+	if values := test.callback(t, callback, cookie); values.Get("code") == "" {
+		t.Fatalf("redirect = %v, want code", values)
+	}
+	if values := test.callback(t, callback, cookie); values.Get("error") != "invalid_state" {
+		t.Errorf("replayed callback: redirect = %v, want error=invalid_state", values)
+	}
+}
+
+func TestOIDCLinksExistingVerifiedEmail(t *testing.T) {
+	database := openTestDB(t)
+	test := newOIDCTest(t, database)
+	email := testEmail(t, database)
+	existing := createTestUser(t, database, email, true)
+	test.provider.Email = strings.ToUpper(email)
+
+	found, err := test.service.ExchangeOIDCCode(test.login(t).Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != existing.ID {
+		t.Errorf("logged in as user %d, want existing user %d", found.ID, existing.ID)
+	}
+	if found.Password != existing.Password {
+		t.Error("password of a verified account was reset")
+	}
+	identity, err := NewIdentityRepository(database).GetBySubject("fake", "fake|"+strings.ToUpper(email))
+	if err != nil || identity.UserID != existing.ID {
+		t.Errorf("identity = %+v, %v", identity, err)
+	}
+}
+
+func TestOIDCResetsPasswordOfUnverifiedAccount(t *testing.T) {
+	database := openTestDB(t)
+	test := newOIDCTest(t, database)
+	email := testEmail(t, database)
+	existing := createTestUser(t, database, email, false)
+	test.provider.Email = email
+
+	found, err := test.service.ExchangeOIDCCode(test.login(t).Get("code"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != existing.ID || !found.EmailVerified() {
+		t.Errorf("user = %+v", found)
+	}
+	if found.HasPassword() || bcrypt.CompareHashAndPassword([]byte(found.Password), []byte("password")) == nil {
+		t.Error("password of an unverified account still works")
+	}
+}
+
+func TestOIDCRejectsUnverifiedEmail(t *testing.T) {
+	database := openTestDB(t)
+	test := newOIDCTest(t, database)
+	email := testEmail(t, database)
+	existing := createTestUser(t, database, email, true)
+	test.provider.Email = email
+	test.provider.EmailVerified = false
+
+	values := test.login(t)
+	if values.Get("error") != "email_required" || values.Has("code") {
+		t.Errorf("redirect = %v, want error=email_required", values)
+	}
+	if _, err := NewIdentityRepository(database).GetBySubject("fake", "fake|"+email); err == nil {
+		t.Error("identity was linked to an account by an unverified email")
+	}
+	reloaded, err := user.NewUserRepository(database).GetByID(existing.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Password != existing.Password {
+		t.Error("password was reset by an unverified email")
+	}
+}
@@ -6,8 +6,13 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	AccessToken  string `json:"accessToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
-	RefreshToken string `json:"refreshToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	AccessToken   string `json:"accessToken,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken  string `json:"refreshToken,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	EmailVerified bool   `json:"emailVerified" example:"true"`
+	// TwoFactorRequired — токены не выданы, нужно отправить код на
+	// /auth/login/2fa вместе с ChallengeToken
+	TwoFactorRequired bool   `json:"twoFactorRequired,omitempty" example:"false"`
+	ChallengeToken    string `json:"challengeToken,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
 }
 
 type RegisterRequest struct {
@@ -18,9 +23,11 @@ type RegisterRequest struct {
 
 type RegisterResponse struct {
 	// Access token для аутентификации
-	AccessToken string `json:"accessToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	AccessToken string `json:"accessToken,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
 	// Refresh token для обновления токенов
-	RefreshToken string `json:"refreshToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refreshToken,omitempty" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	// VerificationRequired — токены не выданы, нужно подтвердить email
+	VerificationRequired bool `json:"verificationRequired,omitempty" example:"false"`
 }
 
 type RefreshRequest struct {
@@ -28,5 +35,60 @@ type RefreshRequest struct {
 }
 
 type RefreshResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string `json:"access_token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+	RefreshToken string `json:"refresh_token" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..."`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" example:"user@example.com" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" example:"user@example.com" validate:"required,email"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	// Code — 6 цифр из приложения или код восстановления
+	Code string `json:"code" example:"123456" validate:"required"`
+}
+
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"`
+	OtpauthURL string `json:"otpauthUrl" example:"otpauth://totp/Coffee:user@example.com?secret=..."`
+	// QRCode — PNG с otpauthUrl в виде data URI
+	QRCode string `json:"qrCode" example:"data:image/png;base64,iVBORw0KGgo..."`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" example:"123456" validate:"required"`
+}
+
+type TOTPDisableRequest struct {
+	Password string `json:"password" validate:"required_without=ReauthToken"`
+	// ReauthToken — токен из письма POST /me/reauth вместо пароля для
+	// аккаунта без пароля
+	ReauthToken string `json:"reauthToken,omitempty"`
+	Code        string `json:"code" example:"123456" validate:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes" example:"7k3m9-x2pq4,..."`
+}
+
+type OIDCTokenRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type OIDCLinkResponse struct {
+	URL string `json:"url" example:"https://accounts.google.com/o/oauth2/v2/auth?client_id=..."`
 }
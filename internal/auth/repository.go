@@ -0,0 +1,434 @@
+package auth
+
+import (
+	"coffee/pkg/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type RefreshTokenRepository struct {
+	Database *db.Db
+}
+
+func NewRefreshTokenRepository(db *db.Db) *RefreshTokenRepository {
+	return &RefreshTokenRepository{
+		Database: db,
+	}
+}
+
+func (repo *RefreshTokenRepository) Create(token *RefreshToken) (*RefreshToken, error) {
+	result := repo.Database.DB.Create(token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return token, nil
+}
+
+func (repo *RefreshTokenRepository) GetByHash(hash string) (*RefreshToken, error) {
+	var token RefreshToken
+	result := repo.Database.DB.Where("token_hash = ?", hash).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// MarkUsed помечает токен обмененным. Возвращает false, если токен уже
+// был обменен или отозван — в том числе параллельным запросом.
+func (repo *RefreshTokenRepository) MarkUsed(id uint) (bool, error) {
+	result := repo.Database.DB.Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RevokeFamily отзывает все токены семейства.
+func (repo *RefreshTokenRepository) RevokeFamily(familyID string) error {
+	result := repo.Database.DB.Model(&RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now())
+	return result.Error
+}
+
+// DeleteExpired удаляет истекшие токены пользователя.
+func (repo *RefreshTokenRepository) DeleteExpired(userID uint) error {
+	result := repo.Database.DB.
+		Where("user_id = ? AND expires_at < ?", userID, time.Now()).
+		Delete(&RefreshToken{})
+	return result.Error
+}
+
+// GetByAccessJTI возвращает refresh token, выданный вместе с access-токеном.
+func (repo *RefreshTokenRepository) GetByAccessJTI(jti string) (*RefreshToken, error) {
+	var token RefreshToken
+	result := repo.Database.DB.Where("access_jti = ?", jti).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// GetActive возвращает неотозванные и неистекшие токены пользователя.
+func (repo *RefreshTokenRepository) GetActive(userID uint) ([]RefreshToken, error) {
+	var tokens []RefreshToken
+	result := repo.Database.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
+
+// GetActiveFamily возвращает неотозванные и неистекшие токены одного
+// входа.
+func (repo *RefreshTokenRepository) GetActiveFamily(familyID string) ([]RefreshToken, error) {
+	var tokens []RefreshToken
+	result := repo.Database.DB.
+		Where("family_id = ? AND revoked_at IS NULL AND expires_at > ?", familyID, time.Now()).
+		Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
+
+// RevokeUser отзывает все refresh-токены пользователя.
+func (repo *RefreshTokenRepository) RevokeUser(userID uint) error {
+	result := repo.Database.DB.Model(&RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	return result.Error
+}
+
+// DeleteUser удаляет все refresh-токены пользователя, например при
+// удалении аккаунта.
+func (repo *RefreshTokenRepository) DeleteUser(userID uint) error {
+	return repo.Database.DB.Where("user_id = ?", userID).Delete(&RefreshToken{}).Error
+}
+
+type ImpersonationTokenRepository struct {
+	Database *db.Db
+}
+
+func NewImpersonationTokenRepository(db *db.Db) *ImpersonationTokenRepository {
+	return &ImpersonationTokenRepository{
+		Database: db,
+	}
+}
+
+func (repo *ImpersonationTokenRepository) Create(token *ImpersonationToken) error {
+	return repo.Database.DB.Create(token).Error
+}
+
+func (repo *ImpersonationTokenRepository) GetByJTI(jti string) (*ImpersonationToken, error) {
+	var token ImpersonationToken
+	result := repo.Database.DB.Where("jti = ?", jti).First(&token)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// GetActive возвращает неистекшие токены входа под пользователем.
+func (repo *ImpersonationTokenRepository) GetActive(userID uint) ([]ImpersonationToken, error) {
+	var tokens []ImpersonationToken
+	result := repo.Database.DB.Where("user_id = ? AND expires_at > ?", userID, time.Now()).Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
+
+// DeleteExpired удаляет истекшие токены пользователя.
+func (repo *ImpersonationTokenRepository) DeleteExpired(userID uint) error {
+	return repo.Database.DB.Where("user_id = ? AND expires_at <= ?", userID, time.Now()).
+		Delete(&ImpersonationToken{}).Error
+}
+
+func (repo *ImpersonationTokenRepository) DeleteUser(userID uint) error {
+	return repo.Database.DB.Where("user_id = ?", userID).Delete(&ImpersonationToken{}).Error
+}
+
+type RevokedTokenRepository struct {
+	Database *db.Db
+}
+
+func NewRevokedTokenRepository(db *db.Db) *RevokedTokenRepository {
+	return &RevokedTokenRepository{
+		Database: db,
+	}
+}
+
+// Create сохраняет отозванный токен. Повторный отзыв не считается ошибкой.
+func (repo *RevokedTokenRepository) Create(token *RevokedToken) error {
+	result := repo.Database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(token)
+	return result.Error
+}
+
+// CreatedSince возвращает неистекшие токены, отозванные после since.
+func (repo *RevokedTokenRepository) CreatedSince(since, now time.Time) ([]RevokedToken, error) {
+	var tokens []RevokedToken
+	result := repo.Database.DB.
+		Where("created_at >= ? AND expires_at > ?", since, now).
+		Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
+
+func (repo *RevokedTokenRepository) DeleteExpired(now time.Time) error {
+	result := repo.Database.DB.Where("expires_at <= ?", now).Delete(&RevokedToken{})
+	return result.Error
+}
+
+type ActionTokenRepository struct {
+	Database *db.Db
+}
+
+func NewActionTokenRepository(db *db.Db) *ActionTokenRepository {
+	return &ActionTokenRepository{
+		Database: db,
+	}
+}
+
+// Replace удаляет прежние токены пользователя с тем же назначением и
+// сохраняет новый: действует только последняя ссылка.
+func (repo *ActionTokenRepository) Replace(token *ActionToken) error {
+	return repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("user_id = ? AND purpose = ?", token.UserID, token.Purpose).
+			Delete(&ActionToken{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(token).Error
+	})
+}
+
+// Consume помечает токен использованным и возвращает его. Истекший,
+// использованный или чужой по назначению токен не находится.
+func (repo *ActionTokenRepository) Consume(hash, purpose string) (*ActionToken, error) {
+	return repo.ConsumeWith(hash, purpose, nil)
+}
+
+// ConsumeWith — Consume, который в той же транзакции выполняет apply. Если
+// apply вернет ошибку, токен остается неиспользованным.
+func (repo *ActionTokenRepository) ConsumeWith(hash, purpose string, apply func(tx *gorm.DB, token *ActionToken) error) (*ActionToken, error) {
+	var token ActionToken
+	err := repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", hash, purpose, time.Now()).
+			First(&token).Error
+		if err != nil {
+			return err
+		}
+		result := tx.Model(&ActionToken{}).
+			Where("id = ? AND used_at IS NULL", token.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if apply != nil {
+			return apply(tx, &token)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (repo *ActionTokenRepository) DeleteUser(userID uint) error {
+	return repo.Database.DB.Where("user_id = ?", userID).Delete(&ActionToken{}).Error
+}
+
+type RecoveryCodeRepository struct {
+	Database *db.Db
+}
+
+func NewRecoveryCodeRepository(db *db.Db) *RecoveryCodeRepository {
+	return &RecoveryCodeRepository{
+		Database: db,
+	}
+}
+
+// Replace заменяет все коды пользователя новыми.
+func (repo *RecoveryCodeRepository) Replace(userID uint, hashes []string) error {
+	return repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]RecoveryCode, 0, len(hashes))
+		for _, hash := range hashes {
+			codes = append(codes, RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// Use помечает код использованным. Возвращает false, если такого
+// неиспользованного кода нет.
+func (repo *RecoveryCodeRepository) Use(userID uint, hash string) (bool, error) {
+	result := repo.Database.DB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// CountUnused — сколько кодов у пользователя осталось.
+func (repo *RecoveryCodeRepository) CountUnused(userID uint) (int64, error) {
+	var count int64
+	result := repo.Database.DB.Model(&RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count)
+	return count, result.Error
+}
+
+func (repo *RecoveryCodeRepository) DeleteAll(userID uint) error {
+	return repo.Database.DB.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+type IdentityRepository struct {
+	Database *db.Db
+}
+
+func NewIdentityRepository(db *db.Db) *IdentityRepository {
+	return &IdentityRepository{
+		Database: db,
+	}
+}
+
+func (repo *IdentityRepository) Create(identity *Identity) error {
+	return repo.Database.DB.Create(identity).Error
+}
+
+func (repo *IdentityRepository) GetBySubject(provider, subject string) (*Identity, error) {
+	var identity Identity
+	result := repo.Database.DB.Where("provider = ? AND subject = ?", provider, subject).First(&identity)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &identity, nil
+}
+
+func (repo *IdentityRepository) DeleteUser(userID uint) error {
+	return repo.Database.DB.Where("user_id = ?", userID).Delete(&Identity{}).Error
+}
+
+type SessionRepository struct {
+	Database *db.Db
+}
+
+func NewSessionRepository(db *db.Db) *SessionRepository {
+	return &SessionRepository{
+		Database: db,
+	}
+}
+
+// activeFamily — условие «в семействе входа есть действующий refresh token».
+const activeFamily = `EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = sessions.family_id
+	AND t.used_at IS NULL AND t.revoked_at IS NULL AND t.expires_at > ?)`
+
+func (repo *SessionRepository) Create(session *Session) (*Session, error) {
+	result := repo.Database.DB.Create(session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return session, nil
+}
+
+// Seen обновляет время последней активности, IP и User-Agent входа.
+// Возвращает false, если входа нет в базе.
+func (repo *SessionRepository) Seen(familyID string, client ClientInfo) (bool, error) {
+	result := repo.Database.DB.Model(&Session{}).Where("family_id = ?", familyID).Updates(map[string]any{
+		"last_seen_at": time.Now(),
+		"ip":           client.IP,
+		"user_agent":   client.UserAgent,
+	})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// GetActive возвращает действующие входы пользователя, недавно активные
+// первыми.
+func (repo *SessionRepository) GetActive(userID uint) ([]Session, error) {
+	var sessions []Session
+	result := repo.Database.DB.
+		Where("user_id = ? AND "+activeFamily, userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return sessions, nil
+}
+
+func (repo *SessionRepository) GetActiveForUser(userID, id uint) (*Session, error) {
+	var session Session
+	result := repo.Database.DB.
+		Where("user_id = ? AND id = ? AND "+activeFamily, userID, id, time.Now()).
+		First(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// DeleteStale удаляет входы, у которых не осталось refresh-токенов.
+func (repo *SessionRepository) DeleteStale(userID uint) error {
+	result := repo.Database.DB.
+		Where("user_id = ? AND NOT EXISTS (SELECT 1 FROM refresh_tokens t WHERE t.family_id = sessions.family_id)", userID).
+		Delete(&Session{})
+	return result.Error
+}
+
+func (repo *SessionRepository) DeleteUser(userID uint) error {
+	return repo.Database.DB.Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+type OIDCStateRepository struct {
+	Database *db.Db
+}
+
+func NewOIDCStateRepository(db *db.Db) *OIDCStateRepository {
+	return &OIDCStateRepository{
+		Database: db,
+	}
+}
+
+// Create сохраняет state и заодно удаляет брошенные истекшие.
+func (repo *OIDCStateRepository) Create(state *OIDCState) error {
+	if err := repo.Database.DB.Where("expires_at < ?", time.Now()).Delete(&OIDCState{}).Error; err != nil {
+		return err
+	}
+	return repo.Database.DB.Create(state).Error
+}
+
+// Consume удаляет state и возвращает его: один state принимается один
+// раз. Истекший state не находится.
+func (repo *OIDCStateRepository) Consume(hash string) (*OIDCState, error) {
+	var states []OIDCState
+	result := repo.Database.DB.Clauses(clause.Returning{}).
+		Where("state_hash = ? AND expires_at > ?", hash, time.Now()).
+		Delete(&states)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if len(states) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &states[0], nil
+}
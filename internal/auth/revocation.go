@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// revocationSyncInterval — как часто RevocationStore подтягивает из базы
+	// токены, отозванные на других узлах.
+	revocationSyncInterval = 30 * time.Second
+	// revocationRetryInterval — пауза перед повтором неудачной загрузки.
+	revocationRetryInterval = 5 * time.Second
+	// revocationMaxStaleness — сколько копия в памяти может не обновляться,
+	// прежде чем каждая неудачная загрузка попадет в лог как тревога.
+	// Проверка продолжает работать по последней загруженной копии: отказ
+	// всем токенам превратил бы сбой базы в выход всех пользователей.
+	revocationMaxStaleness = 3 * revocationSyncInterval
+)
+
+// RevocationStore — отозванные access-токены по jti. Источник истины —
+// таблица revoked_tokens, проверка идет по копии в памяти, которую Start
+// периодически догружает из базы. Запрос к базе на пути запроса не
+// выполняется.
+type RevocationStore struct {
+	Repository *RevokedTokenRepository
+
+	mu       sync.RWMutex
+	revoked  map[string]time.Time
+	syncedAt time.Time
+}
+
+func NewRevocationStore(repository *RevokedTokenRepository) *RevocationStore {
+	store := &RevocationStore{
+		Repository: repository,
+		revoked:    map[string]time.Time{},
+	}
+	if err := store.sync(); err != nil {
+		log.Printf("auth: не удалось загрузить отозванные токены: %v", err)
+	}
+	return store
+}
+
+// Start догружает отозванные токены раз в revocationSyncInterval, пока не
+// отменен ctx. После ошибки загрузка повторяется раньше.
+func (store *RevocationStore) Start(ctx context.Context) {
+	go func() {
+		timer := time.NewTimer(revocationSyncInterval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+				next := revocationSyncInterval
+				if err := store.sync(); err != nil {
+					if stale := store.staleness(); stale > revocationMaxStaleness {
+						log.Printf("ALERT auth: отозванные токены не обновлялись %s, токены, отозванные на других узлах, здесь еще действуют: %v",
+							stale.Round(time.Second), err)
+					} else {
+						log.Printf("auth: не удалось загрузить отозванные токены: %v", err)
+					}
+					next = revocationRetryInterval
+				}
+				timer.Reset(next)
+			}
+		}
+	}()
+}
+
+// Revoke отзывает токен до момента его истечения.
+func (store *RevocationStore) Revoke(jti string, expiresAt time.Time) error {
+	if jti == "" || time.Now().After(expiresAt) {
+		return nil
+	}
+	if err := store.Repository.Create(&RevokedToken{JTI: jti, ExpiresAt: expiresAt}); err != nil {
+		return err
+	}
+	store.mu.Lock()
+	store.revoked[jti] = expiresAt
+	store.mu.Unlock()
+	return nil
+}
+
+// IsRevoked проверяет токен по копии в памяти. Если копию не удается
+// обновить, проверка идет по последней загруженной, а Start пишет тревогу
+// в лог.
+func (store *RevocationStore) IsRevoked(jti string) bool {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	_, revoked := store.revoked[jti]
+	return revoked
+}
+
+// staleness — сколько прошло с последней успешной загрузки.
+func (store *RevocationStore) staleness() time.Duration {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return time.Since(store.syncedAt)
+}
+
+// sync догружает токены, отозванные после прошлой успешной синхронизации,
+// и выбрасывает истекшие. Запрос к базе выполняется без блокировки; при
+// ошибке syncedAt не меняется, и следующая попытка загрузит тот же период.
+func (store *RevocationStore) sync() error {
+	store.mu.RLock()
+	syncedAt := store.syncedAt
+	store.mu.RUnlock()
+
+	now := time.Now()
+	// Запас на случай расхождения часов и долгих транзакций.
+	since := syncedAt.Add(-revocationSyncInterval)
+	tokens, err := store.Repository.CreatedSince(since, now)
+	if err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	for _, token := range tokens {
+		store.revoked[token.JTI] = token.ExpiresAt
+	}
+	for jti, expiresAt := range store.revoked {
+		if now.After(expiresAt) {
+			delete(store.revoked, jti)
+		}
+	}
+	store.syncedAt = now
+	store.mu.Unlock()
+
+	if err := store.Repository.DeleteExpired(now); err != nil {
+		log.Printf("auth: не удалось удалить истекшие отозванные токены: %v", err)
+	}
+	return nil
+}
@@ -1,48 +1,461 @@
 package auth
 
 import (
+	"coffee/configs"
+	"coffee/internal/notification"
 	"coffee/internal/user"
+	"coffee/pkg/jwt"
+	"coffee/pkg/oidc"
+	"coffee/pkg/req"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"log"
+	"net/url"
+	"slices"
+	"time"
+)
+
+const (
+	accessTTL  = 15 * time.Minute   // access token на 15 минут
+	refreshTTL = 24 * 7 * time.Hour // refresh token на 7 дней
+)
+
+var (
+	ErrUserExists          = errors.New("user already exists")
+	ErrInvalidCredentials  = errors.New("invalid email or password")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+	ErrInvalidActionToken  = errors.New("invalid or expired token")
+	ErrEmailNotVerified    = errors.New("email is not verified")
+	ErrAccountDisabled     = errors.New("account is disabled")
 )
 
 type AuthService struct {
-	UserRepository *user.UserRepository
+	UserRepository               *user.UserRepository
+	Guard                        *LoginGuard
+	RefreshTokenRepository       *RefreshTokenRepository
+	SessionRepository            *SessionRepository
+	ActionTokenRepository        *ActionTokenRepository
+	RecoveryCodeRepository       *RecoveryCodeRepository
+	IdentityRepository           *IdentityRepository
+	OIDCStateRepository          *OIDCStateRepository
+	ImpersonationTokenRepository *ImpersonationTokenRepository
+	Revocations                  *RevocationStore
+	Mailer                       *notification.Mailer
+	Config                       *configs.Config
+	providers                    map[string]*oidc.Provider
 }
 
-func NewAuthService(userRepository *user.UserRepository) *AuthService {
+type AuthServiceDeps struct {
+	UserRepository               *user.UserRepository
+	Guard                        *LoginGuard
+	RefreshTokenRepository       *RefreshTokenRepository
+	SessionRepository            *SessionRepository
+	ActionTokenRepository        *ActionTokenRepository
+	RecoveryCodeRepository       *RecoveryCodeRepository
+	IdentityRepository           *IdentityRepository
+	OIDCStateRepository          *OIDCStateRepository
+	ImpersonationTokenRepository *ImpersonationTokenRepository
+	Revocations                  *RevocationStore
+	Mailer                       *notification.Mailer
+	Config                       *configs.Config
+}
+
+func NewAuthService(deps AuthServiceDeps) *AuthService {
 	return &AuthService{
-		UserRepository: userRepository,
+		UserRepository:               deps.UserRepository,
+		Guard:                        deps.Guard,
+		RefreshTokenRepository:       deps.RefreshTokenRepository,
+		SessionRepository:            deps.SessionRepository,
+		ActionTokenRepository:        deps.ActionTokenRepository,
+		RecoveryCodeRepository:       deps.RecoveryCodeRepository,
+		IdentityRepository:           deps.IdentityRepository,
+		OIDCStateRepository:          deps.OIDCStateRepository,
+		ImpersonationTokenRepository: deps.ImpersonationTokenRepository,
+		providers:                    newOIDCProviders(deps.Config),
+		Revocations:                  deps.Revocations,
+		Mailer:                       deps.Mailer,
+		Config:                       deps.Config,
 	}
 }
 
-func (service *AuthService) Register(name, email, password string) (string, error) {
-	existedUser, err := service.UserRepository.GetByEmail(email)
+func (service *AuthService) Register(name, email, password string) (*user.User, error) {
+	existedUser, _ := service.UserRepository.GetByEmail(email)
 	if existedUser != nil {
-		return "", err
+		return nil, ErrUserExists
 	}
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	user := &user.User{
 		Name:     name,
 		Email:    email,
 		Password: string(hashedPassword),
+		Role:     user.RoleCustomer,
 	}
 	_, err = service.UserRepository.CreateUser(user)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return user.Email, nil
+	if err := service.sendVerification(user); err != nil {
+		log.Printf("auth: не удалось выпустить ссылку подтверждения для %s: %v", user.Email, err)
+	}
+	return user, nil
 }
-func (service *AuthService) Login(email, password string) (string, error) {
-	existedUser, err := service.UserRepository.GetByEmail(email)
+
+func (service *AuthService) Login(email, password string, client ClientInfo) (*user.User, error) {
+	if err := service.Guard.Reserve(email, client.IP); err != nil {
+		return nil, err
+	}
+	existedUser, _ := service.UserRepository.GetByEmail(email)
 	if existedUser == nil {
+		service.loginFailed(email, client, nil)
+		return nil, ErrInvalidCredentials
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(existedUser.Password), []byte(password))
+	if err != nil {
+		service.loginFailed(email, client, existedUser)
+		return nil, ErrInvalidCredentials
+	}
+	if err := service.Guard.Succeed(email, client.IP); err != nil {
+		log.Printf("auth: не удалось сбросить счетчик входов %s: %v", email, err)
+	}
+	if existedUser.Disabled() {
+		return nil, ErrAccountDisabled
+	}
+	if !service.CanLogin(existedUser) {
+		return nil, ErrEmailNotVerified
+	}
+	return existedUser, nil
+}
+
+// loginFailed завершает попытку из Guard.Reserve как неудачную и, если
+// аккаунт заблокирован, сообщает владельцу.
+func (service *AuthService) loginFailed(email string, client ClientInfo, u *user.User) {
+	locked, err := service.Guard.Fail(email, client.IP)
+	if err != nil {
+		log.Printf("auth: не удалось учесть неудачный вход %s: %v", email, err)
+	}
+	if !locked || u == nil {
+		return
+	}
+	log.Printf("auth: аккаунт %s заблокирован после неудачных входов, последний с %s", u.Email, client.IP)
+	if !service.Config.Login.NotifyOnLockout {
+		return
+	}
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Из-за нескольких неудачных попыток входа ваш аккаунт заблокирован на %s. "+
+		"Последняя попытка была с адреса %s.\n\n"+
+		"Если это были не вы, смените пароль через «Забыли пароль».\n",
+		u.Name, humanize(service.Config.Login.LockoutDuration), client.IP)
+	go service.send(u.Email, "Вход в аккаунт заблокирован", body)
+}
+
+// CanLogin сообщает, можно ли выдать пользователю токены с учетом
+// политики для неподтвержденных email. Отключенный аккаунт войти не может.
+func (service *AuthService) CanLogin(u *user.User) bool {
+	if u.Disabled() {
+		return false
+	}
+	return u.EmailVerified() || service.Config.Auth.UnverifiedLogin != UnverifiedDeny
+}
+
+// IssueTokens выпускает пару токенов для нового входа, открывает новое
+// семейство refresh-токенов и записывает вход в список сессий. Роль и
+// права пользователя попадают в claims access-токена.
+func (service *AuthService) IssueTokens(u *user.User, client ClientInfo) (*jwt.TokenPair, error) {
+	familyID := uuid.NewString()
+	tokens, err := service.issue(u, familyID, client)
+	if err != nil {
+		return nil, err
+	}
+	if err := service.startSession(u.ID, familyID, client); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Refresh обменивает refresh token на новую пару. Старый токен больше не
+// действует; его повторное предъявление означает кражу, и тогда отзывается
+// все семейство. Роль перечитывается из базы, поэтому ее изменение
+// вступает в силу при следующем обновлении.
+func (service *AuthService) Refresh(refreshToken string, client ClientInfo) (*jwt.TokenPair, error) {
+	data, err := service.jwt().ParseRefreshToken(refreshToken)
+	if errors.Is(err, jwt.ErrExpired) {
+		return nil, ErrRefreshTokenExpired
+	}
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	stored, err := service.RefreshTokenRepository.GetByHash(hashToken(refreshToken))
+	if err != nil || stored.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	fresh, err := service.RefreshTokenRepository.MarkUsed(stored.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		if err := service.RefreshTokenRepository.RevokeFamily(stored.FamilyID); err != nil {
+			return nil, err
+		}
+		log.Printf("auth: повторное использование refresh-токена пользователя %d (%s), семейство %s отозвано",
+			stored.UserID, client.IP, stored.FamilyID)
+		return nil, ErrRefreshTokenReused
+	}
+	existedUser, err := service.UserRepository.GetByEmail(data.Email)
+	if err != nil || existedUser.ID != stored.UserID || !service.CanLogin(existedUser) {
+		return nil, ErrInvalidRefreshToken
+	}
+	tokens, err := service.issue(existedUser, stored.FamilyID, client)
+	if err != nil {
+		return nil, err
+	}
+	service.touchSession(existedUser.ID, stored.FamilyID, client)
+	return tokens, nil
+}
+
+// Logout отзывает access token запроса и refresh-токены, полученные из
+// того же входа.
+func (service *AuthService) Logout(access *jwt.JWTData) error {
+	if err := service.Revocations.Revoke(access.ID, access.ExpiresAt); err != nil {
+		return err
+	}
+	stored, err := service.RefreshTokenRepository.GetByAccessJTI(access.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Токен выпущен до учета refresh-токенов — отзывать больше нечего.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return service.RefreshTokenRepository.RevokeFamily(stored.FamilyID)
+}
+
+// LogoutAll завершает все входы пользователя: отзывает все refresh-токены
+// и выданные вместе с ними access-токены.
+func (service *AuthService) LogoutAll(access *jwt.JWTData) error {
+	existedUser, err := service.UserRepository.GetByEmail(access.Email)
+	if err != nil {
+		return err
+	}
+	if err := service.Revocations.Revoke(access.ID, access.ExpiresAt); err != nil {
+		return err
+	}
+	return service.revokeUser(existedUser.ID)
+}
+
+// ForgotPassword отправляет на email ссылку для сброса пароля. Для
+// неизвестного email ничего не происходит, чтобы по ответу нельзя было
+// проверить, зарегистрирован ли адрес.
+func (service *AuthService) ForgotPassword(email string) error {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	token, err := service.newActionToken(existedUser.ID, PurposePasswordReset, service.Config.Auth.PasswordResetTTL)
+	if err != nil {
+		return err
+	}
+	link := service.Config.Qr.PublicBaseURL + service.Config.Auth.PasswordResetPath +
+		"?" + url.Values{"token": {token}}.Encode()
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Чтобы задать новый пароль, перейдите по ссылке:\n%s\n\n"+
+		"Ссылка действует %s и только один раз. Если вы не запрашивали сброс, просто проигнорируйте это письмо.\n",
+		existedUser.Name, link, humanize(service.Config.Auth.PasswordResetTTL))
+	go service.send(existedUser.Email, "Сброс пароля", body)
+	return nil
+}
+
+// ResendVerification заново отправляет ссылку подтверждения email. Для
+// неизвестного или уже подтвержденного адреса ничего не происходит.
+func (service *AuthService) ResendVerification(email string) error {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil || existedUser.EmailVerified() {
+		return nil
+	}
+	return service.sendVerification(existedUser)
+}
+
+// VerifyEmail подтверждает email по токену из письма.
+func (service *AuthService) VerifyEmail(token string) error {
+	action, err := service.ActionTokenRepository.Consume(hashToken(token), PurposeEmailVerify)
+	if err != nil {
+		return ErrInvalidActionToken
+	}
+	return service.UserRepository.MarkEmailVerified(action.UserID)
+}
+
+func (service *AuthService) sendVerification(u *user.User) error {
+	token, err := service.newActionToken(u.ID, PurposeEmailVerify, service.Config.Auth.EmailVerifyTTL)
+	if err != nil {
+		return err
+	}
+	link := service.Config.Qr.PublicBaseURL + service.Config.Auth.EmailVerifyPath +
+		"?" + url.Values{"token": {token}}.Encode()
+	body := fmt.Sprintf("Здравствуйте, %s!\n\n"+
+		"Подтвердите email, перейдя по ссылке:\n%s\n\n"+
+		"Ссылка действует %s. Если вы не регистрировались, просто проигнорируйте это письмо.\n",
+		u.Name, link, humanize(service.Config.Auth.EmailVerifyTTL))
+	go service.send(u.Email, "Подтверждение email", body)
+	return nil
+}
+
+// ResetPassword задает новый пароль по токену из письма и завершает все
+// входы пользователя.
+func (service *AuthService) ResetPassword(token, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	// Токен расходуется вместе со сменой пароля: если она не удалась,
+	// ссылкой из письма можно воспользоваться снова.
+	action, err := service.ActionTokenRepository.ConsumeWith(hashToken(token), PurposePasswordReset,
+		func(tx *gorm.DB, action *ActionToken) error {
+			users := service.UserRepository.WithTx(tx)
+			if err := users.UpdatePassword(action.UserID, string(hashedPassword)); err != nil {
+				return err
+			}
+			// Письмо дошло — значит, адрес принадлежит пользователю.
+			return users.MarkEmailVerified(action.UserID)
+		})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidActionToken
+	}
+	if err != nil {
+		return err
+	}
+	return service.revokeUser(action.UserID)
+}
+
+// newActionToken выпускает одноразовый токен для письма. Прежние токены
+// пользователя с тем же назначением перестают действовать.
+func (service *AuthService) newActionToken(userID uint, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
 		return "", err
 	}
-	err = bcrypt.CompareHashAndPassword([]byte(existedUser.Password), []byte(password))
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	err := service.ActionTokenRepository.Replace(&ActionToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	})
 	if err != nil {
 		return "", err
 	}
-	return existedUser.Email, nil
+	return token, nil
+}
+
+// send отправляет письмо в фоне: ошибки SMTP не должны влиять на ответ.
+func (service *AuthService) send(to, subject, body string) {
+	if err := service.Mailer.Send(to, subject, body); err != nil {
+		log.Printf("auth: не удалось отправить письмо %q на %s: %v", subject, to, err)
+	}
+}
+
+// revokeUser отзывает все токены пользователя.
+func (service *AuthService) revokeUser(userID uint) error {
+	tokens, err := service.RefreshTokenRepository.GetActive(userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := service.Revocations.Revoke(token.AccessJTI, token.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+	impersonations, err := service.ImpersonationTokenRepository.GetActive(userID)
+	if err != nil {
+		return err
+	}
+	for _, token := range impersonations {
+		if err := service.Revocations.Revoke(token.JTI, token.ExpiresAt); err != nil {
+			return err
+		}
+	}
+	if err := service.ImpersonationTokenRepository.DeleteUser(userID); err != nil {
+		return err
+	}
+	return service.RefreshTokenRepository.RevokeUser(userID)
+}
+
+func (service *AuthService) issue(u *user.User, familyID string, client ClientInfo) (*jwt.TokenPair, error) {
+	tokens, err := service.jwt().CreateTokenPair(service.claims(u), accessTTL, refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+	_, err = service.RefreshTokenRepository.Create(&RefreshToken{
+		UserID:          u.ID,
+		FamilyID:        familyID,
+		TokenHash:       hashToken(tokens.RefreshToken),
+		ExpiresAt:       time.Now().Add(refreshTTL),
+		UserAgent:       req.Truncate(client.UserAgent, 255),
+		IP:              req.Truncate(client.IP, 45),
+		AccessJTI:       tokens.AccessTokenID,
+		AccessExpiresAt: tokens.AccessExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := service.RefreshTokenRepository.DeleteExpired(u.ID); err != nil {
+		log.Printf("auth: не удалось удалить истекшие refresh-токены пользователя %d: %v", u.ID, err)
+	}
+	if err := service.SessionRepository.DeleteStale(u.ID); err != nil {
+		log.Printf("auth: не удалось удалить завершенные входы пользователя %d: %v", u.ID, err)
+	}
+	return tokens, nil
+}
+
+func (service *AuthService) jwt() *jwt.JWT {
+	conf := service.Config.Auth
+	return jwt.NewJWT(conf.AccessKeys, conf.RefreshKeys, conf.Issuer, conf.Audience, conf.RequireIssuer, conf.ClockSkew)
+}
+
+// Permissions — действующие права пользователя. Права роли не выдаются,
+// пока email не подтвержден (при политике "limited") и пока роль из
+// TwoFactorRoles не включила TOTP.
+func (service *AuthService) Permissions(u *user.User) []string {
+	if !u.EmailVerified() && service.Config.Auth.UnverifiedLogin != UnverifiedAllow {
+		return []string{}
+	}
+	if !u.TwoFactorEnabled() && slices.Contains(service.Config.Auth.TwoFactorRoles, string(u.Role)) {
+		return []string{}
+	}
+	return u.Role.Permissions()
+}
+
+// claims — данные access-токена.
+func (service *AuthService) claims(u *user.User) jwt.JWTData {
+	return jwt.JWTData{
+		Email:       u.Email,
+		Role:        string(u.Role),
+		Permissions: service.Permissions(u),
+	}
+}
+
+// humanize — срок действия ссылки для текста письма.
+func humanize(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		return fmt.Sprintf("%d ч", d/time.Hour)
+	}
+	return fmt.Sprintf("%d мин", d/time.Minute)
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"coffee/pkg/req"
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// Sessions возвращает действующие входы пользователя и FamilyID входа, к
+// которому относится access token accessJTI (пусто, если определить не
+// удалось).
+func (service *AuthService) Sessions(email, accessJTI string) ([]Session, string, error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	sessions, err := service.SessionRepository.GetActive(existedUser.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	current := ""
+	if accessJTI != "" {
+		if stored, err := service.RefreshTokenRepository.GetByAccessJTI(accessJTI); err == nil {
+			current = stored.FamilyID
+		}
+	}
+	return sessions, current, nil
+}
+
+// RevokeSession завершает вход пользователя: отзывает его refresh token и
+// выданный вместе с ним access token.
+func (service *AuthService) RevokeSession(email string, id uint) error {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+	session, err := service.SessionRepository.GetActiveForUser(existedUser.ID, id)
+	if err != nil {
+		return ErrSessionNotFound
+	}
+	return service.revokeFamily(session.FamilyID)
+}
+
+// startSession записывает новый вход.
+func (service *AuthService) startSession(userID uint, familyID string, client ClientInfo) error {
+	_, err := service.SessionRepository.Create(&Session{
+		UserID:     userID,
+		FamilyID:   familyID,
+		UserAgent:  req.Truncate(client.UserAgent, 255),
+		IP:         req.Truncate(client.IP, 45),
+		LastSeenAt: time.Now(),
+	})
+	return err
+}
+
+// touchSession отмечает активность входа при обмене refresh-токена. Для
+// входов, начатых до учета сессий, запись создается.
+func (service *AuthService) touchSession(userID uint, familyID string, client ClientInfo) {
+	found, err := service.SessionRepository.Seen(familyID, ClientInfo{
+		UserAgent: req.Truncate(client.UserAgent, 255),
+		IP:        req.Truncate(client.IP, 45),
+	})
+	if err == nil && !found {
+		err = service.startSession(userID, familyID, client)
+	}
+	if err != nil {
+		log.Printf("auth: не удалось обновить вход %s: %v", familyID, err)
+	}
+}
+
+func (service *AuthService) revokeFamily(familyID string) error {
+	tokens, err := service.RefreshTokenRepository.GetActiveFamily(familyID)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if err := service.Revocations.Revoke(token.AccessJTI, token.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+	return service.RefreshTokenRepository.RevokeFamily(familyID)
+}
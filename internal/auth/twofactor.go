@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"coffee/internal/user"
+	"coffee/pkg/jwt"
+	"coffee/pkg/totp"
+	"crypto/rand"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	recoveryCodeCount = 10
+	// totpSkew — сколько соседних 30-секундных интервалов принимается
+	// из-за расхождения часов телефона.
+	totpSkew = 1
+)
+
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotPending = errors.New("start enrollment first")
+	ErrInvalidCode         = errors.New("invalid code")
+	ErrInvalidChallenge    = errors.New("invalid or expired challenge token")
+)
+
+// EnrollTOTP выпускает новый секрет TOTP. Двухфакторный вход включится
+// после ConfirmTOTP с кодом из приложения.
+func (service *AuthService) EnrollTOTP(email string) (secret, link string, err error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return "", "", err
+	}
+	if existedUser.TwoFactorEnabled() {
+		return "", "", ErrTwoFactorEnabled
+	}
+	secret, err = totp.NewSecret()
+	if err != nil {
+		return "", "", err
+	}
+	if err := service.UserRepository.SetTOTP(existedUser.ID, secret, nil); err != nil {
+		return "", "", err
+	}
+	return secret, totp.URL(service.Config.Auth.TOTPIssuer, existedUser.Email, secret), nil
+}
+
+// ConfirmTOTP включает двухфакторный вход и возвращает коды
+// восстановления. Коды показываются один раз.
+func (service *AuthService) ConfirmTOTP(email, code string) ([]string, error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if existedUser.TwoFactorEnabled() {
+		return nil, ErrTwoFactorEnabled
+	}
+	if existedUser.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotPending
+	}
+	step, ok := totp.Validate(existedUser.TOTPSecret, code, time.Now(), totpSkew, 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	now := time.Now()
+	if err := service.UserRepository.SetTOTP(existedUser.ID, existedUser.TOTPSecret, &now); err != nil {
+		return nil, err
+	}
+	if _, err := service.UserRepository.UseTOTPStep(existedUser.ID, step); err != nil {
+		return nil, err
+	}
+	return service.newRecoveryCodes(existedUser.ID)
+}
+
+// DisableTOTP выключает двухфакторный вход. Нужны пароль (или токен из
+// письма для аккаунта без пароля) и код из приложения или код
+// восстановления.
+func (service *AuthService) DisableTOTP(email string, reauth Reauth) error {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+	if !existedUser.TwoFactorEnabled() {
+		return ErrTwoFactorNotEnabled
+	}
+	if _, err := service.Reauthenticate(email, reauth); err != nil {
+		if errors.Is(err, ErrWrongPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+	if err := service.UserRepository.SetTOTP(existedUser.ID, "", nil); err != nil {
+		return err
+	}
+	return service.RecoveryCodeRepository.DeleteAll(existedUser.ID)
+}
+
+// RegenerateRecoveryCodes заменяет коды восстановления новыми.
+func (service *AuthService) RegenerateRecoveryCodes(email, code string, client ClientInfo) ([]string, error) {
+	existedUser, err := service.UserRepository.GetByEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if !existedUser.TwoFactorEnabled() {
+		return nil, ErrTwoFactorNotEnabled
+	}
+	if err := service.checkSecondFactor(existedUser, code, client); err != nil {
+		return nil, err
+	}
+	return service.newRecoveryCodes(existedUser.ID)
+}
+
+// Challenge выпускает токен второго шага входа для пользователя, который
+// верно ввел пароль. jti токена сохраняется, прежний токен второго шага
+// перестает действовать.
+func (service *AuthService) Challenge(u *user.User) (string, error) {
+	data := jwt.JWTData{
+		ID:        uuid.NewString(),
+		Email:     u.Email,
+		ExpiresAt: time.Now().Add(service.Config.Auth.ChallengeTTL),
+		TokenType: jwt.ChallengeToken,
+	}
+	err := service.ActionTokenRepository.Replace(&ActionToken{
+		UserID:    u.ID,
+		Purpose:   PurposeTwoFactorChallenge,
+		TokenHash: hashToken(data.ID),
+		ExpiresAt: data.ExpiresAt,
+	})
+	if err != nil {
+		return "", err
+	}
+	return service.jwt().Create(data, service.Config.Auth.AccessKeys)
+}
+
+// CompleteLogin завершает вход по токену второго шага и коду из
+// приложения или коду восстановления. Токен одноразовый: он помечается
+// использованным в базе в одной транзакции с проверкой кода, поэтому
+// неверный код его не расходует, а повторно войти с ним нельзя ни на
+// одном узле. Неверные коды считаются неудачными входами.
+func (service *AuthService) CompleteLogin(challenge, code string, client ClientInfo) (*user.User, error) {
+	data, err := service.jwt().ParseChallengeToken(challenge)
+	if err != nil || data.ID == "" {
+		return nil, ErrInvalidChallenge
+	}
+	if err := service.Guard.Reserve(data.Email, client.IP); err != nil {
+		return nil, err
+	}
+	existedUser, err := service.UserRepository.GetByEmail(data.Email)
+	if err != nil || !existedUser.TwoFactorEnabled() || existedUser.Disabled() {
+		service.Guard.Cancel(data.Email, client.IP)
+		return nil, ErrInvalidChallenge
+	}
+	_, err = service.ActionTokenRepository.ConsumeWith(hashToken(data.ID), PurposeTwoFactorChallenge,
+		func(tx *gorm.DB, token *ActionToken) error {
+			if token.UserID != existedUser.ID {
+				return ErrInvalidChallenge
+			}
+			return service.verifySecondFactor(existedUser, code)
+		})
+	switch {
+	case errors.Is(err, ErrInvalidCode):
+		service.loginFailed(data.Email, client, existedUser)
+		return nil, err
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, ErrInvalidChallenge):
+		service.Guard.Cancel(data.Email, client.IP)
+		return nil, ErrInvalidChallenge
+	case err != nil:
+		service.Guard.Cancel(data.Email, client.IP)
+		return nil, err
+	}
+	if err := service.Guard.Succeed(data.Email, client.IP); err != nil {
+		log.Printf("auth: не удалось сбросить счетчик входов %s: %v", existedUser.Email, err)
+	}
+	return existedUser, nil
+}
+
+// checkSecondFactor проверяет код, если у пользователя включен TOTP, перед
+// действием в уже открытом входе. Неверные коды считаются неудачными
+// входами по аккаунту и IP, как на втором шаге входа: иначе с украденным
+// access token и паролем код можно было бы перебирать без ограничений.
+func (service *AuthService) checkSecondFactor(u *user.User, code string, client ClientInfo) error {
+	if !u.TwoFactorEnabled() {
+		return nil
+	}
+	if err := service.Guard.Reserve(u.Email, client.IP); err != nil {
+		return err
+	}
+	if err := service.verifySecondFactor(u, code); err != nil {
+		if errors.Is(err, ErrInvalidCode) {
+			service.loginFailed(u.Email, client, u)
+		} else {
+			service.Guard.Cancel(u.Email, client.IP)
+		}
+		return err
+	}
+	if err := service.Guard.Succeed(u.Email, client.IP); err != nil {
+		log.Printf("auth: не удалось сбросить счетчик входов %s: %v", u.Email, err)
+	}
+	return nil
+}
+
+// verifySecondFactor принимает код из приложения (6 цифр) или код
+// восстановления.
+func (service *AuthService) verifySecondFactor(u *user.User, code string) error {
+	code = strings.TrimSpace(code)
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(u.TOTPSecret, code, time.Now(), totpSkew, u.TOTPLastStep)
+		if !ok {
+			return ErrInvalidCode
+		}
+		fresh, err := service.UserRepository.UseTOTPStep(u.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidCode
+		}
+		return nil
+	}
+	used, err := service.RecoveryCodeRepository.Use(u.ID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidCode
+	}
+	if left, err := service.RecoveryCodeRepository.CountUnused(u.ID); err == nil && left <= 2 {
+		log.Printf("auth: у пользователя %d осталось кодов восстановления: %d", u.ID, left)
+	}
+	return nil
+}
+
+// recoveryAlphabet — base32 Крокфорда: без i, l, o и u, которые легко
+// спутать с другими символами.
+const recoveryAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+func (service *AuthService) newRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := make([]byte, len(raw))
+		for j, b := range raw {
+			code[j] = recoveryAlphabet[b&31]
+		}
+		codes[i] = string(code[:5]) + "-" + string(code[5:])
+		hashes[i] = hashToken(normalizeRecoveryCode(codes[i]))
+	}
+	if err := service.RecoveryCodeRepository.Replace(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
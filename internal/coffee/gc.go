@@ -0,0 +1,133 @@
+package coffee
+
+import (
+	"coffee/internal/media"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// GCFile — файл в одной из директорий загрузок.
+type GCFile struct {
+	Dir      string    `json:"dir"`
+	Filename string    `json:"filename"`
+	ModTime  time.Time `json:"modTime,omitempty"`
+}
+
+// GCReport — результат сверки файлов с таблицей coffees.
+// Orphans — файлы, на которые не ссылается ни одна запись, Missing — ссылки
+// на файлы, которых нет на диске, Deleted — удаленные сиротские файлы.
+type GCReport struct {
+	Orphans []GCFile `json:"orphans"`
+	Missing []GCFile `json:"missing"`
+	Deleted []GCFile `json:"deleted"`
+	// ExpiredUploads — число удаленных неподтвержденных прямых загрузок
+	ExpiredUploads int `json:"expiredUploads"`
+}
+
+// GarbageCollector удаляет из директорий загрузок файлы, оставшиеся после
+// неудачных CreateCoffee и замен в UpdateCoffee. Файлы моложе GracePeriod
+// не трогаются: запись о них могла еще не успеть попасть в базу.
+type GarbageCollector struct {
+	CoffeeRepository *CoffeeRepository
+	MediaService     *media.MediaService
+	Root             string
+	GracePeriod      time.Duration
+}
+
+func NewGarbageCollector(repo *CoffeeRepository, mediaService *media.MediaService, gracePeriod time.Duration) *GarbageCollector {
+	return &GarbageCollector{
+		CoffeeRepository: repo,
+		MediaService:     mediaService,
+		Root:             uploadDir,
+		GracePeriod:      gracePeriod,
+	}
+}
+
+// Run сверяет файлы с базой. При dryRun ничего не удаляет, только отчитывается.
+func (gc *GarbageCollector) Run(dryRun bool) (*GCReport, error) {
+	referenced, err := gc.CoffeeRepository.ReferencedFiles()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения файлов из базы: %w", err)
+	}
+	report := &GCReport{}
+	deadline := time.Now().Add(-gc.GracePeriod)
+	for dir, names := range referenced {
+		fullDir := filepath.Join(gc.Root, dir)
+		entries, err := os.ReadDir(fullDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("ошибка чтения директории %s: %w", fullDir, err)
+		}
+		onDisk := make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			onDisk[entry.Name()] = true
+			if names[entry.Name()] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			file := GCFile{Dir: dir, Filename: entry.Name(), ModTime: info.ModTime()}
+			report.Orphans = append(report.Orphans, file)
+			if dryRun || info.ModTime().After(deadline) {
+				continue
+			}
+			if err := gc.remove(dir, entry.Name()); err != nil {
+				log.Printf("gc: не удалось удалить %s/%s: %v", dir, entry.Name(), err)
+				continue
+			}
+			report.Deleted = append(report.Deleted, file)
+		}
+		for name := range names {
+			if !onDisk[name] {
+				report.Missing = append(report.Missing, GCFile{Dir: dir, Filename: name})
+			}
+		}
+	}
+	if !dryRun {
+		purged, err := gc.MediaService.PurgeExpiredUploads(deadline)
+		if err != nil {
+			return nil, fmt.Errorf("ошибка очистки загрузок: %w", err)
+		}
+		report.ExpiredUploads = purged
+	}
+	return report, nil
+}
+
+// remove удаляет сиротский файл. Файлы из хранилища удаляются вместе с
+// записью blob, QR-коды в хранилище не попадают.
+func (gc *GarbageCollector) remove(dir, filename string) error {
+	if dir == qrDir {
+		return os.Remove(filepath.Join(gc.Root, dir, filename))
+	}
+	return gc.MediaService.Purge(dir, filename)
+}
+
+// Start запускает Run раз в interval, пока не отменен ctx.
+func (gc *GarbageCollector) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := gc.Run(false)
+				if err != nil {
+					log.Printf("gc: %v", err)
+					continue
+				}
+				log.Printf("gc: сирот %d, удалено %d, отсутствует %d",
+					len(report.Orphans), len(report.Deleted), len(report.Missing))
+			}
+		}
+	}()
+}
@@ -2,40 +2,79 @@ package coffee
 
 import (
 	"coffee/configs"
+	"coffee/internal/media"
+	"coffee/internal/user"
 	"coffee/pkg/middleware"
 	"coffee/pkg/qr"
+	"coffee/pkg/req"
 	"coffee/pkg/res"
+	"coffee/pkg/signurl"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
+	"time"
 )
 
 type CoffeeHandler struct {
 	CoffeeRepository *CoffeeRepository
+	MediaService     *media.MediaService
+	Config           *configs.Config
+	Signer           *signurl.Signer
+	QRCache          *qr.Cache
+	logo             logoCache
 }
 
 type CoffeeHandlerDeps struct {
 	CoffeeRepository *CoffeeRepository
+	MediaService     *media.MediaService
 	Config           *configs.Config
+	Auth             *middleware.Auth
 }
 
 func NewCoffeeHandler(router *http.ServeMux, deps CoffeeHandlerDeps) {
 	handler := &CoffeeHandler{
 		CoffeeRepository: deps.CoffeeRepository,
+		MediaService:     deps.MediaService,
+		Config:           deps.Config,
+		Signer:           signurl.NewSigner(deps.Config.Media.SigningSecret),
+		QRCache:          qr.NewCache(qrCacheSize),
 	}
-	router.Handle("POST /coffees", middleware.IsAuthed(handler.CreateCoffee(), deps.Config))
+	router.Handle("POST /coffees", middleware.RequirePermission(user.PermCoffeeWrite, handler.CreateCoffee(), deps.Auth))
 	router.HandleFunc("GET /coffees", handler.GetAllCoffee())
 	router.HandleFunc("GET /coffees/{slug}", handler.GetCoffee())
 	router.HandleFunc("GET /coffees/static/images/{dir}/{filename}", handler.GetCoffeeImage())
-	router.Handle("DELETE /coffees/{slug}", middleware.IsAuthed(handler.DeleteCoffee(), deps.Config))
-	router.Handle("PUT /coffees/{slug}", middleware.IsAuthed(handler.UpdateCoffee(), deps.Config))
+	router.Handle("POST /coffees/static/images/{dir}/{filename}/sign", middleware.RequirePermission(user.PermMediaUpload, handler.SignCoffeeImage(), deps.Auth))
+	router.Handle("DELETE /coffees/{slug}", middleware.RequirePermission(user.PermCoffeeDelete, handler.DeleteCoffee(), deps.Auth))
+	router.Handle("PUT /coffees/{slug}", middleware.RequirePermission(user.PermCoffeeWrite, handler.UpdateCoffee(), deps.Auth))
+	router.HandleFunc("GET /coffees/{slug}/qr", handler.GetCoffeeQR())
+	router.HandleFunc("GET /coffees/{slug}/images", handler.GetCoffeeImages())
+	router.Handle("POST /coffees/{slug}/images", middleware.RequirePermission(user.PermCoffeeWrite, handler.AddCoffeeImage(), deps.Auth))
+	router.Handle("PATCH /coffees/{slug}/images/{id}", middleware.RequirePermission(user.PermCoffeeWrite, handler.UpdateCoffeeImage(), deps.Auth))
+	router.Handle("DELETE /coffees/{slug}/images/{id}", middleware.RequirePermission(user.PermCoffeeWrite, handler.DeleteCoffeeImage(), deps.Auth))
+	router.Handle("PUT /coffees/{slug}/images/order", middleware.RequirePermission(user.PermCoffeeWrite, handler.ReorderCoffeeImages(), deps.Auth))
+	router.Handle("POST /coffees/{slug}/uploads", middleware.RequirePermission(user.PermCoffeeWrite, handler.ConfirmCoffeeUpload(), deps.Auth))
 }
 
 const (
 	maxFileSize = 10 << 20 // 10 MB
-	uploadDir   = "static/images"
+	uploadDir   = media.UploadDir
+	productsDir = "products"
+	flagsDir    = "flagsIcon"
+	qrDir       = "qr"
+	galleryDir  = "gallery"
+	// avatarsDir — аватары пользователей, их загружает internal/account.
+	avatarsDir = media.AvatarsDir
+
+	maxSignedURLTTL = 24 * time.Hour
+	qrCacheSize     = 512
+	// MaxLocationLen — длина метки места l в QR-коде и в сканах.
+	MaxLocationLen = 50
 )
 
 // CreateCoffee ... Create Coffee
@@ -56,6 +95,7 @@ const (
 // @Param flagIcon formData file true "Иконка флага страны происхождения"
 // @Success 201 {object} Coffee
 // @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Router /coffees [post]
 func (handler *CoffeeHandler) CreateCoffee() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -64,29 +104,25 @@ func (handler *CoffeeHandler) CreateCoffee() http.HandlerFunc {
 			return
 		}
 
-		imagePath, err := handler.saveFile(r, "image", uploadDir+"/products")
+		price, dollar, ruble, err := handler.parseNumericValues(r)
 		if err != nil {
-			http.Error(w, "Ошибка при сохранении изображения: "+err.Error(), http.StatusBadRequest)
+			http.Error(w, "Ошибка в числовых значениях: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		flagIconPath, err := handler.saveFile(r, "flagIcon", uploadDir+"/flagsIcon")
+		imagePath, err := handler.saveFile(r, "image", productsDir)
 		if err != nil {
-			http.Error(w, "Ошибка при сохранении иконки флага: "+err.Error(), http.StatusBadRequest)
+			http.Error(w, "Ошибка при сохранении изображения: "+err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		price, dollar, ruble, err := handler.parseNumericValues(r)
+		flagIconPath, err := handler.saveFile(r, "flagIcon", flagsDir)
 		if err != nil {
-			http.Error(w, "Ошибка в числовых значениях: "+err.Error(), http.StatusBadRequest)
+			_ = handler.MediaService.Release(productsDir, imagePath)
+			http.Error(w, "Ошибка при сохранении иконки флага: "+err.Error(), http.StatusBadRequest)
 			return
 		}
-		qrCode := qr.SimpleQRCode{Content: "http://139.59.2.151:8081/coffee/coffee/" + r.FormValue("slug"), Size: 256}
 
-		qrImage, err := qrCode.SaveToFile(uploadDir + "/qr")
-		if err != nil {
-			http.Error(w, "Qr code create error", http.StatusBadRequest)
-		}
 		coffee := NewCoffee(
 			r.FormValue("name"),
 			r.FormValue("slug"),
@@ -96,11 +132,23 @@ func (handler *CoffeeHandler) CreateCoffee() http.HandlerFunc {
 			ruble,
 			imagePath,
 			flagIconPath,
-			qrImage,
+			"",
 		)
+		coffee.ShortCode = NewShortCode()
+
+		coffee.QrImage, err = saveQRCode(handler.Config, coffee)
+		if err != nil {
+			_ = handler.MediaService.Release(productsDir, imagePath)
+			_ = handler.MediaService.Release(flagsDir, flagIconPath)
+			http.Error(w, "Qr code create error", http.StatusBadRequest)
+			return
+		}
 
 		createdCoffee, err := handler.CoffeeRepository.CreateCoffee(coffee)
 		if err != nil {
+			_ = handler.MediaService.Release(productsDir, imagePath)
+			_ = handler.MediaService.Release(flagsDir, flagIconPath)
+			_ = removeFile(filepath.Join(uploadDir, qrDir, coffee.QrImage))
 			http.Error(w, "Ошибка при создании записи: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -154,6 +202,7 @@ func (handler *CoffeeHandler) GetAllCoffee() http.HandlerFunc {
 // @Success 200 {object} nil "Успешное удаление"
 // @Failure 400 {string} string "Неверный ID"
 // @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Router /coffees/{slug} [delete]
 // ]
 func (handler *CoffeeHandler) DeleteCoffee() http.HandlerFunc {
@@ -161,17 +210,21 @@ func (handler *CoffeeHandler) DeleteCoffee() http.HandlerFunc {
 		slug := r.PathValue("slug")
 
 		coffee, err := handler.CoffeeRepository.GetBySlug(slug)
-		_ = handler.deleteFile(coffee.Image)
-		_ = handler.deleteFile(coffee.FlagIcon)
-		_ = handler.deleteFile(coffee.QrImage)
 		if err != nil {
 			http.Error(w, "invalid id", http.StatusBadRequest)
+			return
 		}
 		err = handler.CoffeeRepository.Delete(slug)
 		if err != nil {
 			http.Error(w, "invalid id", http.StatusBadRequest)
 			return
 		}
+		_ = handler.MediaService.Release(productsDir, coffee.Image)
+		_ = handler.MediaService.Release(flagsDir, coffee.FlagIcon)
+		_ = removeFile(filepath.Join(uploadDir, qrDir, filepath.Base(coffee.QrImage)))
+		for _, image := range coffee.Images {
+			_ = handler.MediaService.Release(galleryDir, image.Filename)
+		}
 
 		res.Json(w, CoffeeDeleteResponse{
 			Message: "Товар удален",
@@ -199,6 +252,7 @@ func (handler *CoffeeHandler) DeleteCoffee() http.HandlerFunc {
 // @Success 200 {object} Coffee "Обновленная информация о кофе"
 // @Failure 400 {string} string "Ошибка в запросе или неверный ID"
 // @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
 // @Router /coffees/{slug} [put]
 func (handler *CoffeeHandler) UpdateCoffee() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -236,26 +290,26 @@ func (handler *CoffeeHandler) UpdateCoffee() http.HandlerFunc {
 			ruble = existingCoffee.Ruble
 		}
 
-		imagePath := existingCoffee.Image
+		imagePath, imageStored := existingCoffee.Image, false
 		if _, fileHeader, _ := r.FormFile("image"); fileHeader != nil {
-			newImagePath, err := handler.saveFile(r, "image", uploadDir+"/products")
+			newImagePath, err := handler.saveFile(r, "image", productsDir)
 			if err == nil {
-				_ = handler.deleteFile(filepath.Join(uploadDir+"/products", filepath.Base(imagePath)))
-				imagePath = newImagePath
+				imagePath, imageStored = newImagePath, true
 			}
 		}
 
-		flagIconPath := existingCoffee.FlagIcon
+		flagIconPath, flagIconStored := existingCoffee.FlagIcon, false
 		if _, fileHeader, _ := r.FormFile("flagIcon"); fileHeader != nil {
-			newFlagIconPath, err := handler.saveFile(r, "flagIcon", uploadDir+"/flagsIcon")
+			newFlagIconPath, err := handler.saveFile(r, "flagIcon", flagsDir)
 			if err == nil {
-				_ = handler.deleteFile(filepath.Join(uploadDir+"/flagsIcon", filepath.Base(flagIconPath)))
-
-				flagIconPath = newFlagIconPath
+				flagIconPath, flagIconStored = newFlagIconPath, true
 			}
 		}
 
-		updatedCoffee, err := handler.CoffeeRepository.Update(&Coffee{
+		// Старые файлы освобождаются только после успешного обновления:
+		// до него на них еще ссылается запись. При ошибке освобождаются
+		// новые.
+		updatedCoffee, err := handler.CoffeeRepository.Update(existingCoffee.ID, &Coffee{
 			Name:        name,
 			Slug:        slug,
 			Price:       price,
@@ -267,9 +321,21 @@ func (handler *CoffeeHandler) UpdateCoffee() http.HandlerFunc {
 		})
 
 		if err != nil {
+			if imageStored {
+				_ = handler.MediaService.Release(productsDir, imagePath)
+			}
+			if flagIconStored {
+				_ = handler.MediaService.Release(flagsDir, flagIconPath)
+			}
 			http.Error(w, "failed to update coffee: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		if imageStored {
+			_ = handler.MediaService.Release(productsDir, existingCoffee.Image)
+		}
+		if flagIconStored {
+			_ = handler.MediaService.Release(flagsDir, existingCoffee.FlagIcon)
+		}
 
 		res.Json(w, updatedCoffee, http.StatusOK)
 	}
@@ -300,18 +366,425 @@ func (handler *CoffeeHandler) GetCoffee() http.HandlerFunc {
 	}
 }
 
+// @Summary QR-код кофе
+// @Description Рисует QR-код с короткой ссылкой /q/{code} на кофе. SVG и PDF векторные и подходят для печати. С логотипом уровень коррекции всегда H.
+// @Tags Coffee
+// @Produce image/png,image/svg+xml,application/pdf
+// @Param slug path string true "slug кофе"
+// @Param format query string false "Формат" Enums(png, svg, pdf) default(png)
+// @Param size query int false "Сторона в пикселях (PNG) или пунктах (SVG, PDF)" minimum(64) maximum(2048) default(256)
+// @Param ecc query string false "Уровень коррекции ошибок" Enums(L, M, Q, H) default(M)
+// @Param margin query int false "Пустая рамка в модулях" minimum(0) maximum(16) default(4)
+// @Param fg query string false "Цвет модулей, rrggbb" default(000000)
+// @Param bg query string false "Цвет фона, rrggbb" default(ffffff)
+// @Param rounded query bool false "Скругленные модули"
+// @Param logo query bool false "Логотип кафе в центре"
+// @Param caption query string false "Подпись под кодом" maxlength(40)
+// @Param l query string false "Метка места (стол, витрина), сохраняется в сканах" maxlength(50)
+// @Success 200 {file} file
+// @Failure 400 {string} string "Неверные параметры"
+// @Failure 404 {string} string "coffee not found"
+// @Router /coffees/{slug}/qr [get]
+func (handler *CoffeeHandler) GetCoffeeQR() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := handler.parseQROptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		location := r.URL.Query().Get("l")
+		if req.Truncate(location, MaxLocationLen) != location {
+			http.Error(w, fmt.Sprintf("l: не длиннее %d символов", MaxLocationLen), http.StatusBadRequest)
+			return
+		}
+		coffee, err := handler.CoffeeRepository.GetBySlug(r.PathValue("slug"))
+		if err != nil {
+			http.Error(w, "coffee not found", http.StatusNotFound)
+			return
+		}
+		content := QRContent(handler.Config, coffee, location)
+		key := opts.Key(content)
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(key)))
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		data, ok := handler.QRCache.Get(key)
+		if !ok {
+			data, err = qr.Render(content, opts)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			handler.QRCache.Set(key, data)
+		}
+		w.Header().Set("Content-Type", opts.Format.ContentType())
+		w.Write(data)
+	}
+}
+
+// @Summary Галерея кофе
+// @Description Возвращает изображения галереи в порядке показа
+// @Tags Coffee
+// @Produce json
+// @Param slug path string true "slug кофе"
+// @Success 200 {object} CoffeeImagesResponse "Галерея"
+// @Failure 404 {string} string "coffee not found"
+// @Router /coffees/{slug}/images [get]
+func (handler *CoffeeHandler) GetCoffeeImages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coffee, err := handler.CoffeeRepository.GetBySlug(r.PathValue("slug"))
+		if err != nil {
+			http.Error(w, "coffee not found", http.StatusNotFound)
+			return
+		}
+		res.Json(w, CoffeeImagesResponse{Images: coffee.Images}, http.StatusOK)
+	}
+}
+
+// @Summary Добавление изображения в галерею
+// @Description Добавляет изображение в конец галереи. Первое изображение становится главным.
+// @Tags Coffee
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param slug path string true "slug кофе"
+// @Param image formData file true "Изображение"
+// @Param alt formData string false "Alt-тексты по локалям, JSON-объект локаль → текст"
+// @Param primary formData bool false "Сделать главным"
+// @Success 201 {object} CoffeeImage
+// @Failure 400 {string} string "Ошибка в запросе"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "coffee not found"
+// @Router /coffees/{slug}/images [post]
+func (handler *CoffeeHandler) AddCoffeeImage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coffee, err := handler.CoffeeRepository.GetBySlug(r.PathValue("slug"))
+		if err != nil {
+			http.Error(w, "coffee not found", http.StatusNotFound)
+			return
+		}
+		if err := r.ParseMultipartForm(maxFileSize); err != nil {
+			http.Error(w, "Ошибка при обработке формы: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		alts, err := parseAlts(r.FormValue("alt"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		primary, _ := strconv.ParseBool(r.FormValue("primary"))
+
+		filename, err := handler.saveFile(r, "image", galleryDir)
+		if err != nil {
+			http.Error(w, "Ошибка при сохранении изображения: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		image := &CoffeeImage{
+			CoffeeID:  coffee.ID,
+			Filename:  filename,
+			IsPrimary: primary,
+		}
+		for locale, text := range alts {
+			if text != "" {
+				image.Alts = append(image.Alts, CoffeeImageAlt{Locale: locale, Text: text})
+			}
+		}
+		created, err := handler.CoffeeRepository.AddImage(image)
+		if err != nil {
+			_ = handler.MediaService.Release(galleryDir, filename)
+			http.Error(w, "Ошибка при добавлении изображения: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, created, http.StatusCreated)
+	}
+}
+
+// @Summary Изменение изображения галереи
+// @Description Меняет alt-тексты и флаг главного изображения
+// @Tags Coffee
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param slug path string true "slug кофе"
+// @Param id path int true "ID изображения"
+// @Param request body CoffeeImageUpdateRequest true "Изменения"
+// @Success 200 {object} CoffeeImage
+// @Failure 400 {string} string "Ошибка в запросе"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "image not found"
+// @Router /coffees/{slug}/images/{id} [patch]
+func (handler *CoffeeHandler) UpdateCoffeeImage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		image, ok := handler.findImage(w, r)
+		if !ok {
+			return
+		}
+		body, err := req.HandleBody[CoffeeImageUpdateRequest](&w, r)
+		if err != nil {
+			return
+		}
+		if err := validateAlts(body.Alt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if body.IsPrimary != nil {
+			if !*body.IsPrimary && image.IsPrimary {
+				http.Error(w, "нельзя снять флаг с главного изображения, назначьте главным другое", http.StatusBadRequest)
+				return
+			}
+			image.IsPrimary = *body.IsPrimary
+		}
+		updated, err := handler.CoffeeRepository.UpdateImage(image, body.Alt)
+		if err != nil {
+			http.Error(w, "failed to update image: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, updated, http.StatusOK)
+	}
+}
+
+// @Summary Удаление изображения из галереи
+// @Description Удаляет изображение. Если оно было главным, главным становится первое по порядку.
+// @Tags Coffee
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param slug path string true "slug кофе"
+// @Param id path int true "ID изображения"
+// @Success 200 {object} CoffeeDeleteResponse "Успешное удаление"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "image not found"
+// @Router /coffees/{slug}/images/{id} [delete]
+func (handler *CoffeeHandler) DeleteCoffeeImage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		image, ok := handler.findImage(w, r)
+		if !ok {
+			return
+		}
+		if err := handler.CoffeeRepository.DeleteImage(image); err != nil {
+			http.Error(w, "failed to delete image: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = handler.MediaService.Release(galleryDir, image.Filename)
+		res.Json(w, CoffeeDeleteResponse{
+			Message: "Изображение удалено",
+		}, http.StatusOK)
+	}
+}
+
+// @Summary Порядок изображений галереи
+// @Description Задает порядок показа. Нужно передать ID всех изображений галереи.
+// @Tags Coffee
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param slug path string true "slug кофе"
+// @Param request body CoffeeImageOrderRequest true "ID изображений в новом порядке"
+// @Success 200 {object} CoffeeImagesResponse "Галерея"
+// @Failure 400 {string} string "Ошибка в запросе"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "coffee not found"
+// @Router /coffees/{slug}/images/order [put]
+func (handler *CoffeeHandler) ReorderCoffeeImages() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coffee, err := handler.CoffeeRepository.GetBySlug(r.PathValue("slug"))
+		if err != nil {
+			http.Error(w, "coffee not found", http.StatusNotFound)
+			return
+		}
+		body, err := req.HandleBody[CoffeeImageOrderRequest](&w, r)
+		if err != nil {
+			return
+		}
+		err = handler.CoffeeRepository.ReorderImages(coffee.ID, body.IDs)
+		if errors.Is(err, ErrImageOrderMismatch) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to reorder images: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		images, err := handler.CoffeeRepository.GetImages(coffee.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, CoffeeImagesResponse{Images: images}, http.StatusOK)
+	}
+}
+
+// @Summary Подтверждение прямой загрузки
+// @Description Проверяет файл, загруженный по ссылке из /uploads/presign, и прикрепляет его к кофе как изображение, иконку флага или изображение галереи
+// @Tags Coffee
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param slug path string true "slug кофе"
+// @Param request body CoffeeUploadConfirmRequest true "Загрузка"
+// @Success 200 {object} CoffeeGetResponse "Кофе с прикрепленным файлом"
+// @Failure 400 {string} string "Файл не прошел проверку"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "coffee not found"
+// @Failure 409 {string} string "загрузка уже подтверждена"
+// @Router /coffees/{slug}/uploads [post]
+func (handler *CoffeeHandler) ConfirmCoffeeUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		coffee, err := handler.CoffeeRepository.GetBySlug(r.PathValue("slug"))
+		if err != nil {
+			http.Error(w, "coffee not found", http.StatusNotFound)
+			return
+		}
+		body, err := req.HandleBody[CoffeeUploadConfirmRequest](&w, r)
+		if err != nil {
+			return
+		}
+		if err := validateAlts(body.Alt); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		dir := map[string]string{
+			"image":    productsDir,
+			"flagIcon": flagsDir,
+			"gallery":  galleryDir,
+		}[body.Target]
+
+		owner, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		filename, err := handler.MediaService.ConfirmUpload(body.Key, owner, dir)
+		switch {
+		case errors.Is(err, media.ErrUploadNotFound):
+			http.Error(w, "upload not found", http.StatusNotFound)
+			return
+		case errors.Is(err, media.ErrUploadInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case errors.Is(err, media.ErrUploadConfirmed):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if err := handler.attachUpload(coffee, body, dir, filename); err != nil {
+			_ = handler.MediaService.Release(dir, filename)
+			http.Error(w, "Ошибка при сохранении файла: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		updated, err := handler.CoffeeRepository.GetBySlug(coffee.Slug)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, CoffeeGetResponse{Coffee: *updated}, http.StatusOK)
+	}
+}
+
+// @Summary Получение файла
+// @Description Отдает файл из static/images. Файлы из приватных директорий доступны только по подписанной ссылке.
+// @Tags Coffee
+// @Produce image/jpeg
+// @Param dir path string true "Директория" Enums(products, flagsIcon, qr, gallery, avatars, drafts, labels)
+// @Param filename path string true "Имя файла"
+// @Param expires query int false "Срок действия подписанной ссылки (unix)"
+// @Param signature query string false "Подпись ссылки"
+// @Success 200 {file} file
+// @Failure 403 {string} string "invalid signature"
+// @Failure 404 {string} string "image not found"
+// @Router /coffees/static/images/{dir}/{filename} [get]
 func (handler *CoffeeHandler) GetCoffeeImage() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		filename := r.PathValue("filename")
 		dir := r.PathValue("dir")
+		private, known := handler.dirAccess(dir)
+		if !known || filename != filepath.Base(filename) {
+			http.Error(w, "image not found", http.StatusNotFound)
+			return
+		}
+		if private {
+			err := handler.Signer.Verify(r.URL.Path, r.URL.Query())
+			if errors.Is(err, signurl.ErrExpired) {
+				http.Error(w, "signed url has expired", http.StatusForbidden)
+				return
+			}
+			if err != nil {
+				http.Error(w, "invalid signature", http.StatusForbidden)
+				return
+			}
+			w.Header().Set("Cache-Control", "private, no-store")
+		}
 		imagePath := path.Join(uploadDir+"/"+dir, filename)
 		if _, err := os.Stat(imagePath); os.IsNotExist(err) {
 			http.Error(w, "image not found", http.StatusNotFound)
 			return
 		}
-		contentType := "image/jpeg"
+		contentType := mime.TypeByExtension(filepath.Ext(filename))
+		if contentType == "" {
+			contentType = "image/jpeg"
+		}
 		w.Header().Set("Content-Type", contentType)
 		w.Header().Set("Content-Disposition", "inline")
 		http.ServeFile(w, r, imagePath)
 	}
 }
+
+// @Summary Подписанная ссылка на файл
+// @Description Выдает ссылку на файл из приватной директории со сроком действия
+// @Tags Coffee
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param dir path string true "Приватная директория" Enums(drafts, labels)
+// @Param filename path string true "Имя файла"
+// @Param ttl query string false "Срок действия, например 15m (не больше 24h)"
+// @Success 200 {object} SignedURLResponse
+// @Failure 400 {string} string "Ошибка в запросе"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "image not found"
+// @Router /coffees/static/images/{dir}/{filename}/sign [post]
+func (handler *CoffeeHandler) SignCoffeeImage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filename := r.PathValue("filename")
+		dir := r.PathValue("dir")
+		private, known := handler.dirAccess(dir)
+		if !known || filename != filepath.Base(filename) {
+			http.Error(w, "image not found", http.StatusNotFound)
+			return
+		}
+		if !private {
+			http.Error(w, "directory is public", http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(path.Join(uploadDir, dir, filename)); os.IsNotExist(err) {
+			http.Error(w, "image not found", http.StatusNotFound)
+			return
+		}
+		ttl := handler.Config.Media.SignedURLTTL
+		if raw := r.URL.Query().Get("ttl"); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed <= 0 || parsed > maxSignedURLTTL {
+				http.Error(w, "invalid ttl", http.StatusBadRequest)
+				return
+			}
+			ttl = parsed
+		}
+		expiresAt := time.Now().Add(ttl)
+		res.Json(w, SignedURLResponse{
+			URL:       handler.Signer.Sign(path.Join("/coffees", uploadDir, dir, filename), expiresAt),
+			ExpiresAt: expiresAt,
+		}, http.StatusOK)
+	}
+}
@@ -6,15 +6,37 @@ type Coffee struct {
 	ID          uint `gorm:"primaryKey"`
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
-	Name        string  `json:"name" example:"Espresso" gorm:"size:50;not null"`
-	Slug        string  `json:"slug" example:"espresso" gorm:"size:50;unique;index;not null"`
-	Price       float64 `json:"price" example:"4.99" gorm:"type:decimal(20,2);not null"`
-	Description string  `json:"description" example:"Strong Italian coffee" gorm:"type:text;not null"`
-	Dollar      float64 `json:"dollar" example:"1.0" gorm:"type:decimal(20,2);not null"`
-	Ruble       float64 `json:"ruble" example:"89.5" gorm:"type:decimal(20,2);not null"`
-	Image       string  `json:"image" example:"espresso.jpg" gorm:"type:varchar(500);not null"`
-	FlagIcon    string  `json:"flag_icon" example:"italy.png" gorm:"type:varchar(500);not null"`
-	QrImage     string  `json:"qrImage" example:"espresso.png" gorm:"type:varchar(500);not null"`
+	Name        string        `json:"name" example:"Espresso" gorm:"size:50;not null"`
+	Slug        string        `json:"slug" example:"espresso" gorm:"size:50;unique;index;not null"`
+	Price       float64       `json:"price" example:"4.99" gorm:"type:decimal(20,2);not null"`
+	Description string        `json:"description" example:"Strong Italian coffee" gorm:"type:text;not null"`
+	Dollar      float64       `json:"dollar" example:"1.0" gorm:"type:decimal(20,2);not null"`
+	Ruble       float64       `json:"ruble" example:"89.5" gorm:"type:decimal(20,2);not null"`
+	Image       string        `json:"image" example:"espresso.jpg" gorm:"type:varchar(500);not null"`
+	FlagIcon    string        `json:"flag_icon" example:"italy.png" gorm:"type:varchar(500);not null"`
+	QrImage     string        `json:"qrImage" example:"espresso.png" gorm:"type:varchar(500);not null"`
+	ShortCode   string        `json:"shortCode" example:"k3Xp9QaZ" gorm:"size:16;uniqueIndex"`
+	Images      []CoffeeImage `json:"images,omitempty" gorm:"foreignKey:CoffeeID;constraint:OnDelete:CASCADE"`
+}
+
+// CoffeeImage — изображение из галереи кофе. Position задает порядок показа,
+// IsPrimary помечает главное изображение (у кофе оно одно).
+type CoffeeImage struct {
+	ID        uint             `json:"id" example:"1" gorm:"primaryKey"`
+	CreatedAt time.Time        `json:"createdAt"`
+	CoffeeID  uint             `json:"-" gorm:"index;not null"`
+	Filename  string           `json:"filename" example:"espresso-cup.jpg" gorm:"type:varchar(500);not null"`
+	Position  int              `json:"position" example:"0" gorm:"not null;default:0"`
+	IsPrimary bool             `json:"isPrimary" example:"true" gorm:"not null;default:false"`
+	Alts      []CoffeeImageAlt `json:"alts" gorm:"foreignKey:ImageID;constraint:OnDelete:CASCADE"`
+}
+
+// CoffeeImageAlt — alt-текст изображения для одной локали.
+type CoffeeImageAlt struct {
+	ID      uint   `json:"-" gorm:"primaryKey"`
+	ImageID uint   `json:"-" gorm:"uniqueIndex:idx_image_locale;not null"`
+	Locale  string `json:"locale" example:"ru" gorm:"size:10;uniqueIndex:idx_image_locale;not null"`
+	Text    string `json:"text" example:"Чашка эспрессо" gorm:"size:255;not null"`
 }
 
 func NewCoffee(name string, coffeeSlug string, price float64, Description string, dollar, ruble float64, image, flagIcon, qrImage string) *Coffee {
@@ -1,6 +1,9 @@
 package coffee
 
-import "mime/multipart"
+import (
+	"mime/multipart"
+	"time"
+)
 
 type CoffeeCreateRequest struct {
 	Name        string          `json:"name" validate:"required,max=50"`
@@ -34,3 +37,31 @@ type CoffeeUpdateRequest struct {
 	Image       *multipart.Form `json:"image" validate:"required"`
 	FlagIcon    *multipart.Form `json:"flag_icon" validate:"required"`
 }
+
+type CoffeeImageUpdateRequest struct {
+	// Alt-тексты по локалям, пустая строка удаляет alt-текст локали
+	Alt       map[string]string `json:"alt" example:"ru:Чашка эспрессо"`
+	IsPrimary *bool             `json:"isPrimary" example:"true"`
+}
+
+type CoffeeImageOrderRequest struct {
+	IDs []uint `json:"ids" example:"3,1,2" validate:"required,min=1"`
+}
+
+type CoffeeImagesResponse struct {
+	Images []CoffeeImage `json:"images"`
+}
+
+type SignedURLResponse struct {
+	URL       string    `json:"url" example:"/coffees/static/images/labels/label.pdf?expires=1767225600&signature=..."`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type CoffeeUploadConfirmRequest struct {
+	// Ключ из POST /uploads/presign
+	Key string `json:"key" example:"3f8a1c2e-6f1b-4a3d-9a57-1d2c3b4a5e6f.jpg" validate:"required"`
+	// Куда прикрепить файл
+	Target    string            `json:"target" example:"gallery" validate:"required,oneof=image flagIcon gallery"`
+	Alt       map[string]string `json:"alt" example:"ru:Чашка эспрессо"`
+	IsPrimary bool              `json:"isPrimary" example:"false"`
+}
@@ -0,0 +1,149 @@
+package coffee
+
+import (
+	"coffee/configs"
+	"coffee/pkg/qr"
+	"crypto/rand"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PublicURL — публичная ссылка на страницу кофе, которая кодируется в QR.
+func PublicURL(conf *configs.Config, slug string) string {
+	path := strings.ReplaceAll(conf.Qr.PathTemplate, "{slug}", url.PathEscape(slug))
+	return conf.Qr.PublicBaseURL + path
+}
+
+// ScanURL — короткая ссылка /q/{code}, которая кодируется в QR. Переход по
+// ней засчитывается как скан и перенаправляет на PublicURL. location
+// помечает, где висит код, например стол или витрину.
+func ScanURL(conf *configs.Config, code, location string) string {
+	link := conf.Qr.PublicBaseURL + "/q/" + url.PathEscape(code)
+	if location != "" {
+		link += "?" + url.Values{"l": {location}}.Encode()
+	}
+	return link
+}
+
+// QRContent — что кодируется в QR-коде кофе. Для записей без короткого
+// кода, созданных до учета сканов, это прямая ссылка.
+func QRContent(conf *configs.Config, coffee *Coffee, location string) string {
+	if coffee.ShortCode == "" {
+		return PublicURL(conf, coffee.Slug)
+	}
+	return ScanURL(conf, coffee.ShortCode, location)
+}
+
+// FlagIconPath — путь к файлу иконки флага кофе.
+func FlagIconPath(coffee *Coffee) string {
+	return filepath.Join(uploadDir, flagsDir, filepath.Base(coffee.FlagIcon))
+}
+
+const shortCodeAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// NewShortCode возвращает случайный код из 8 символов base62.
+func NewShortCode() string {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(shortCodeAlphabet))))
+		if err != nil {
+			panic(err)
+		}
+		code[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+// saveQRCode рисует QR-код со ссылкой на кофе и возвращает имя файла.
+func saveQRCode(conf *configs.Config, coffee *Coffee) (string, error) {
+	qrCode := qr.SimpleQRCode{Content: QRContent(conf, coffee, ""), Size: qr.DefaultSize}
+	return qrCode.SaveToFile(filepath.Join(uploadDir, qrDir))
+}
+
+// RegenerateQRCodes перерисовывает QR-коды всех кофе по текущему
+// PublicBaseURL, например после смены домена. Записям без короткого кода
+// он выдается. Возвращает число обновленных записей.
+func RegenerateQRCodes(repo *CoffeeRepository, conf *configs.Config) (int, error) {
+	coffees, err := repo.GetAllForQR()
+	if err != nil {
+		return 0, fmt.Errorf("ошибка получения списка кофе: %w", err)
+	}
+	updated := 0
+	for _, coffee := range coffees {
+		if coffee.ShortCode == "" {
+			coffee.ShortCode = NewShortCode()
+		}
+		filename, err := saveQRCode(conf, &coffee)
+		if err != nil {
+			return updated, fmt.Errorf("%s: %w", coffee.Slug, err)
+		}
+		if err := repo.UpdateQrImage(coffee.ID, filename, coffee.ShortCode); err != nil {
+			_ = removeFile(filepath.Join(uploadDir, qrDir, filename))
+			return updated, fmt.Errorf("%s: %w", coffee.Slug, err)
+		}
+		if coffee.QrImage != "" {
+			if err := removeFile(filepath.Join(uploadDir, qrDir, filepath.Base(coffee.QrImage))); err != nil {
+				log.Printf("qr: не удалось удалить %s: %v", coffee.QrImage, err)
+			}
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// parseQROptions разбирает параметры отрисовки и оформления из запроса и
+// подключает логотип при logo=true.
+func (handler *CoffeeHandler) parseQROptions(query url.Values) (qr.Options, error) {
+	opts, err := qr.ParseOptions(query)
+	if err != nil {
+		return opts, err
+	}
+	if withLogo, _ := strconv.ParseBool(query.Get("logo")); withLogo {
+		if opts.Logo, opts.LogoKey, err = handler.logo.load(handler.Config.Qr.LogoPath); err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// logoCache держит декодированный логотип и перечитывает файл, когда он
+// меняется.
+type logoCache struct {
+	mu      sync.Mutex
+	modTime time.Time
+	image   image.Image
+}
+
+func (c *logoCache) load(path string) (image.Image, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("логотип не найден")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.image == nil || !info.ModTime().Equal(c.modTime) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, "", fmt.Errorf("ошибка чтения логотипа: %w", err)
+		}
+		defer file.Close()
+		logo, _, err := image.Decode(file)
+		if err != nil {
+			return nil, "", fmt.Errorf("ошибка чтения логотипа: %w", err)
+		}
+		c.image = logo
+		c.modTime = info.ModTime()
+	}
+	return c.image, strconv.FormatInt(c.modTime.UnixNano(), 10), nil
+}
@@ -2,6 +2,9 @@ package coffee
 
 import (
 	"coffee/pkg/db"
+	"errors"
+	"gorm.io/gorm"
+	"path/filepath"
 )
 
 type CoffeeRepository struct {
@@ -17,7 +20,7 @@ func NewCoffeeRepository(db *db.Db) *CoffeeRepository {
 func (repo *CoffeeRepository) CreateCoffee(coffee *Coffee) (*Coffee, error) {
 	result := repo.Database.DB.Create(coffee)
 	if result.Error != nil {
-		panic(result.Error)
+		return nil, result.Error
 	}
 	return coffee, nil
 }
@@ -46,19 +49,266 @@ func (repo *CoffeeRepository) Delete(slug string) error {
 
 func (repo *CoffeeRepository) GetBySlug(slug string) (*Coffee, error) {
 	var coffee Coffee
-	result := repo.Database.DB.Where("slug = ?", slug).First(&coffee)
+	result := repo.Database.DB.
+		Preload("Images", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, id ASC")
+		}).
+		Preload("Images.Alts").
+		Where("slug = ?", slug).
+		First(&coffee)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &coffee, nil
 }
 
-func (repo *CoffeeRepository) Update(coffee *Coffee) (*Coffee, error) {
+// Update меняет непустые поля кофе с данным id. Если кофе не найден,
+// возвращает gorm.ErrRecordNotFound.
+func (repo *CoffeeRepository) Update(id uint, coffee *Coffee) (*Coffee, error) {
 	result := repo.Database.DB.Model(&Coffee{}).
-		Where("slug = ?", coffee.Slug).
+		Where("id = ?", id).
 		Updates(coffee)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	coffee.ID = id
 	return coffee, nil
 }
+
+func (repo *CoffeeRepository) GetImages(coffeeID uint) ([]CoffeeImage, error) {
+	var images []CoffeeImage
+	result := repo.Database.DB.
+		Preload("Alts").
+		Where("coffee_id = ?", coffeeID).
+		Order("position ASC, id ASC").
+		Find(&images)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return images, nil
+}
+
+func (repo *CoffeeRepository) GetImage(coffeeID, imageID uint) (*CoffeeImage, error) {
+	var image CoffeeImage
+	result := repo.Database.DB.
+		Preload("Alts").
+		Where("coffee_id = ? AND id = ?", coffeeID, imageID).
+		First(&image)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &image, nil
+}
+
+// AddImage добавляет изображение в конец галереи. Первое изображение
+// галереи всегда становится главным.
+func (repo *CoffeeRepository) AddImage(image *CoffeeImage) (*CoffeeImage, error) {
+	err := repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&CoffeeImage{}).Where("coffee_id = ?", image.CoffeeID).Count(&count).Error; err != nil {
+			return err
+		}
+		var maxPosition int
+		if count > 0 {
+			if err := tx.Model(&CoffeeImage{}).
+				Where("coffee_id = ?", image.CoffeeID).
+				Select("MAX(position)").
+				Scan(&maxPosition).Error; err != nil {
+				return err
+			}
+			image.Position = maxPosition + 1
+		} else {
+			image.Position = 0
+			image.IsPrimary = true
+		}
+		if image.IsPrimary {
+			if err := unsetPrimary(tx, image.CoffeeID); err != nil {
+				return err
+			}
+		}
+		return tx.Create(image).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+// UpdateImage сохраняет флаг главного изображения и заменяет alt-тексты
+// для переданных локалей. Пустой текст удаляет alt-текст локали.
+func (repo *CoffeeRepository) UpdateImage(image *CoffeeImage, alts map[string]string) (*CoffeeImage, error) {
+	err := repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		if image.IsPrimary {
+			if err := unsetPrimary(tx, image.CoffeeID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&CoffeeImage{}).
+			Where("id = ?", image.ID).
+			Update("is_primary", image.IsPrimary).Error; err != nil {
+			return err
+		}
+		for locale, text := range alts {
+			if err := tx.Where("image_id = ? AND locale = ?", image.ID, locale).
+				Delete(&CoffeeImageAlt{}).Error; err != nil {
+				return err
+			}
+			if text == "" {
+				continue
+			}
+			if err := tx.Create(&CoffeeImageAlt{ImageID: image.ID, Locale: locale, Text: text}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetImage(image.CoffeeID, image.ID)
+}
+
+// DeleteImage удаляет изображение и уплотняет позиции оставшихся. Если
+// удалено главное изображение, главным становится первое по порядку.
+func (repo *CoffeeRepository) DeleteImage(image *CoffeeImage) error {
+	return repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&CoffeeImage{}, image.ID).Error; err != nil {
+			return err
+		}
+		var rest []CoffeeImage
+		if err := tx.Where("coffee_id = ?", image.CoffeeID).
+			Order("position ASC, id ASC").
+			Find(&rest).Error; err != nil {
+			return err
+		}
+		for i, img := range rest {
+			updates := map[string]any{"position": i}
+			if image.IsPrimary && i == 0 {
+				updates["is_primary"] = true
+			}
+			if err := tx.Model(&CoffeeImage{}).Where("id = ?", img.ID).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ReorderImages выставляет позиции по порядку ids. Список должен содержать
+// каждое изображение галереи ровно один раз.
+func (repo *CoffeeRepository) ReorderImages(coffeeID uint, ids []uint) error {
+	return repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		var existing []uint
+		if err := tx.Model(&CoffeeImage{}).Where("coffee_id = ?", coffeeID).Pluck("id", &existing).Error; err != nil {
+			return err
+		}
+		if len(existing) != len(ids) {
+			return ErrImageOrderMismatch
+		}
+		known := make(map[uint]bool, len(existing))
+		for _, id := range existing {
+			known[id] = true
+		}
+		for position, id := range ids {
+			if !known[id] {
+				return ErrImageOrderMismatch
+			}
+			delete(known, id)
+			if err := tx.Model(&CoffeeImage{}).Where("id = ?", id).Update("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+var ErrImageOrderMismatch = errors.New("список изображений не совпадает с галереей")
+
+func unsetPrimary(tx *gorm.DB, coffeeID uint) error {
+	return tx.Model(&CoffeeImage{}).
+		Where("coffee_id = ? AND is_primary = ?", coffeeID, true).
+		Update("is_primary", false).Error
+}
+
+// ReferencedFiles возвращает имена файлов, на которые ссылаются записи,
+// сгруппированные по поддиректориям загрузок.
+func (repo *CoffeeRepository) ReferencedFiles() (map[string]map[string]bool, error) {
+	var coffees []Coffee
+	result := repo.Database.DB.Select("image", "flag_icon", "qr_image").Find(&coffees)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	var gallery []string
+	result = repo.Database.DB.Model(&CoffeeImage{}).Pluck("filename", &gallery)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	files := map[string]map[string]bool{
+		productsDir: {},
+		flagsDir:    {},
+		qrDir:       {},
+		galleryDir:  {},
+	}
+	add := func(dir, name string) {
+		if name != "" {
+			files[dir][filepath.Base(name)] = true
+		}
+	}
+	for _, coffee := range coffees {
+		add(productsDir, coffee.Image)
+		add(flagsDir, coffee.FlagIcon)
+		add(qrDir, coffee.QrImage)
+	}
+	for _, name := range gallery {
+		add(galleryDir, name)
+	}
+	return files, nil
+}
+
+// GetAllForQR возвращает slug, короткий код и текущий QR-код каждой записи.
+func (repo *CoffeeRepository) GetAllForQR() ([]Coffee, error) {
+	var coffees []Coffee
+	result := repo.Database.DB.Select("id", "slug", "qr_image", "short_code").Order("id").Find(&coffees)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return coffees, nil
+}
+
+func (repo *CoffeeRepository) UpdateQrImage(id uint, filename, shortCode string) error {
+	return repo.Database.DB.Model(&Coffee{}).
+		Where("id = ?", id).
+		Updates(map[string]any{"qr_image": filename, "short_code": shortCode}).Error
+}
+
+func (repo *CoffeeRepository) GetByShortCode(code string) (*Coffee, error) {
+	var coffee Coffee
+	result := repo.Database.DB.Where("short_code = ?", code).First(&coffee)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &coffee, nil
+}
+
+// GetBySlugs возвращает записи в порядке slugs. Неизвестные slug пропускаются.
+func (repo *CoffeeRepository) GetBySlugs(slugs []string) ([]Coffee, error) {
+	var found []Coffee
+	result := repo.Database.DB.Where("slug IN ?", slugs).Find(&found)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	bySlug := make(map[string]Coffee, len(found))
+	for _, coffee := range found {
+		bySlug[coffee.Slug] = coffee
+	}
+	coffees := make([]Coffee, 0, len(slugs))
+	for _, slug := range slugs {
+		if coffee, ok := bySlug[slug]; ok {
+			coffees = append(coffees, coffee)
+		}
+	}
+	return coffees, nil
+}
@@ -1,12 +1,12 @@
 package coffee
 
 import (
+	"encoding/json"
 	"fmt"
-	"github.com/google/uuid"
-	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 )
 
@@ -29,43 +29,128 @@ func (handler *CoffeeHandler) parseNumericValues(r *http.Request) (price, dollar
 	return price, dollar, ruble, nil
 }
 
-func (handler *CoffeeHandler) saveFile(r *http.Request, fieldName, imagePath string) (string, error) {
+// saveFile сохраняет файл из поля формы в директорию dir хранилища и
+// возвращает имя файла.
+func (handler *CoffeeHandler) saveFile(r *http.Request, fieldName, dir string) (string, error) {
 	file, fileHeader, err := r.FormFile(fieldName)
 	if err != nil {
 		return "", fmt.Errorf("ошибка получения файла %s: %w", fieldName, err)
 	}
 	defer file.Close()
 
-	if err := os.MkdirAll(imagePath, 0755); err != nil {
-		return "", fmt.Errorf("ошибка создания директории: %w", err)
+	return handler.MediaService.Store(file, dir, filepath.Ext(fileHeader.Filename))
+}
+
+func removeFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
 	}
 
-	ext := filepath.Ext(fileHeader.Filename)
+	return os.Remove(path)
+}
 
-	filename := fmt.Sprintf("%s%s", uuid.New().String(), ext)
+// parseAlts разбирает alt-тексты вида {"ru": "...", "en": "..."}.
+func parseAlts(raw string) (map[string]string, error) {
+	alts := map[string]string{}
+	if raw == "" {
+		return alts, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &alts); err != nil {
+		return nil, fmt.Errorf("некорректный alt: %w", err)
+	}
+	if err := validateAlts(alts); err != nil {
+		return nil, err
+	}
+	return alts, nil
+}
+
+func validateAlts(alts map[string]string) error {
+	for locale, text := range alts {
+		if !localePattern.MatchString(locale) {
+			return fmt.Errorf("некорректная локаль %q", locale)
+		}
+		if len(text) > 255 {
+			return fmt.Errorf("alt для локали %q длиннее 255 символов", locale)
+		}
+	}
+	return nil
+}
 
-	fullPath := filepath.Join(imagePath, filename)
+var localePattern = regexp.MustCompile(`^[a-z]{2}(-[A-Z]{2})?$`)
 
-	dest, err := os.Create(fullPath)
+// findImage ищет изображение галереи по slug и id из пути и сам пишет
+// ответ об ошибке, если найти не удалось.
+func (handler *CoffeeHandler) findImage(w http.ResponseWriter, r *http.Request) (*CoffeeImage, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
 	if err != nil {
-		return "", fmt.Errorf("ошибка создания файла: %w", err)
+		http.Error(w, "invalid image id", http.StatusBadRequest)
+		return nil, false
 	}
-	defer dest.Close()
-
-	if _, err := io.Copy(dest, file); err != nil {
-		return "", fmt.Errorf("ошибка копирования файла: %w", err)
+	coffee, err := handler.CoffeeRepository.GetBySlug(r.PathValue("slug"))
+	if err != nil {
+		http.Error(w, "coffee not found", http.StatusNotFound)
+		return nil, false
+	}
+	image, err := handler.CoffeeRepository.GetImage(coffee.ID, uint(id))
+	if err != nil {
+		http.Error(w, "image not found", http.StatusNotFound)
+		return nil, false
 	}
+	return image, true
+}
 
-	return filename, nil
+var publicDirs = map[string]bool{
+	productsDir: true,
+	flagsDir:    true,
+	qrDir:       true,
+	galleryDir:  true,
+	avatarsDir:  true,
 }
 
-func (handler *CoffeeHandler) deleteFile(path string) error {
-	if path == "" {
-		return nil
+// dirAccess сообщает, известна ли директория и требует ли она подписи.
+func (handler *CoffeeHandler) dirAccess(dir string) (private, known bool) {
+	if publicDirs[dir] {
+		return false, true
 	}
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil
+	for _, privateDir := range handler.Config.Media.PrivateDirs {
+		if dir == privateDir {
+			return true, true
+		}
 	}
+	return false, false
+}
 
-	return os.Remove(path)
+// attachUpload прикрепляет подтвержденный файл к кофе. Замененные
+// изображение и иконка флага освобождаются в хранилище.
+func (handler *CoffeeHandler) attachUpload(coffee *Coffee, body *CoffeeUploadConfirmRequest, dir, filename string) error {
+	switch body.Target {
+	case "image":
+		if _, err := handler.CoffeeRepository.Update(coffee.ID, &Coffee{Image: filename}); err != nil {
+			return err
+		}
+		_ = handler.MediaService.Release(dir, coffee.Image)
+		return nil
+	case "flagIcon":
+		if _, err := handler.CoffeeRepository.Update(coffee.ID, &Coffee{FlagIcon: filename}); err != nil {
+			return err
+		}
+		_ = handler.MediaService.Release(dir, coffee.FlagIcon)
+		return nil
+	default:
+		image := &CoffeeImage{
+			CoffeeID:  coffee.ID,
+			Filename:  filename,
+			IsPrimary: body.IsPrimary,
+		}
+		for locale, text := range body.Alt {
+			if text != "" {
+				image.Alts = append(image.Alts, CoffeeImageAlt{Locale: locale, Text: text})
+			}
+		}
+		_, err := handler.CoffeeRepository.AddImage(image)
+		return err
+	}
 }
@@ -0,0 +1,105 @@
+package label
+
+import (
+	"coffee/configs"
+	"coffee/internal/coffee"
+	"coffee/internal/user"
+	"coffee/pkg/middleware"
+	"coffee/pkg/qr"
+	"coffee/pkg/req"
+	"coffee/pkg/res"
+	"errors"
+	"image/color"
+	"net/http"
+	"strconv"
+)
+
+type LabelHandler struct {
+	LabelService *LabelService
+}
+
+type LabelHandlerDeps struct {
+	CoffeeRepository *coffee.CoffeeRepository
+	Config           *configs.Config
+	Auth             *middleware.Auth
+}
+
+func NewLabelHandler(router *http.ServeMux, deps LabelHandlerDeps) {
+	handler := &LabelHandler{
+		LabelService: NewLabelService(deps.CoffeeRepository, deps.Config),
+	}
+	router.Handle("GET /labels/layouts", middleware.RequirePermission(user.PermLabelsPrint, handler.GetLayouts(), deps.Auth))
+	router.Handle("POST /labels", middleware.RequirePermission(user.PermLabelsPrint, handler.Render(), deps.Auth))
+}
+
+// @Summary Раскладки листов этикеток
+// @Description Список поддерживаемых форматов бумаги для этикеток
+// @Tags Labels
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Success 200 {array} Layout
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /labels/layouts [get]
+func (handler *LabelHandler) GetLayouts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res.Json(w, Layouts(), http.StatusOK)
+	}
+}
+
+// @Summary Лист этикеток
+// @Description Рисует PDF с этикетками выбранных кофе: название, цена, флаг и QR-код. Для складных табличек используйте раскладку a4-tent.
+// @Tags Labels
+// @Accept json
+// @Produce application/pdf
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body LabelRequest true "Кофе и формат листа"
+// @Success 200 {file} file "PDF"
+// @Failure 400 {string} string "Неверные параметры"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "no coffees found"
+// @Router /labels [post]
+func (handler *LabelHandler) Render() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[LabelRequest](&w, r)
+		if err != nil {
+			return
+		}
+		layout, ok := GetLayout(body.Layout)
+		if !ok {
+			http.Error(w, ErrUnknownLayout.Error(), http.StatusBadRequest)
+			return
+		}
+		var background color.RGBA
+		if body.Background != "" {
+			background, err = qr.ParseColor(body.Background)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		data, err := handler.LabelService.RenderSlugs(body.Slugs, Options{
+			Layout:     layout,
+			Bleed:      body.Bleed,
+			Copies:     body.Copies,
+			Background: background,
+			Location:   body.Location,
+		})
+		if errors.Is(err, ErrNoCoffees) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `attachment; filename="labels-`+layout.Name+`.pdf"`)
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(data)
+	}
+}
@@ -0,0 +1,103 @@
+package label
+
+import "sort"
+
+// Layout — раскладка этикеток на листе. Размеры в миллиметрах.
+type Layout struct {
+	Name        string  `json:"name" example:"a4-3x8"`
+	Description string  `json:"description" example:"A4, 3 x 8, 70 x 37 мм"`
+	PageWidth   float64 `json:"pageWidth" example:"210"`
+	PageHeight  float64 `json:"pageHeight" example:"297"`
+	Columns     int     `json:"columns" example:"3"`
+	Rows        int     `json:"rows" example:"8"`
+	LabelWidth  float64 `json:"labelWidth" example:"70"`
+	LabelHeight float64 `json:"labelHeight" example:"37"`
+	MarginTop   float64 `json:"marginTop" example:"0.5"`
+	MarginLeft  float64 `json:"marginLeft" example:"0"`
+	GapX        float64 `json:"gapX" example:"0"`
+	GapY        float64 `json:"gapY" example:"0"`
+	// Tent — складная табличка: лист сгибается пополам, верхняя половина
+	// печатается перевернутой.
+	Tent bool `json:"tent" example:"false"`
+}
+
+// PerPage — число этикеток на странице.
+func (layout Layout) PerPage() int {
+	return layout.Columns * layout.Rows
+}
+
+// Single сообщает, что на странице одна этикетка и вылеты можно
+// добавлять к размеру страницы.
+func (layout Layout) Single() bool {
+	return layout.PerPage() == 1
+}
+
+var layouts = map[string]Layout{
+	"a4-3x8": {
+		Description: "A4, 3 x 8, 70 x 37 мм",
+		PageWidth:   210, PageHeight: 297,
+		Columns: 3, Rows: 8,
+		LabelWidth: 70, LabelHeight: 37,
+		MarginTop: 0.5,
+	},
+	"a4-3x7": {
+		Description: "A4, 3 x 7, 63.5 x 38.1 мм (L7160)",
+		PageWidth:   210, PageHeight: 297,
+		Columns: 3, Rows: 7,
+		LabelWidth: 63.5, LabelHeight: 38.1,
+		MarginTop: 15.15, MarginLeft: 7.25, GapX: 2.5,
+	},
+	"a4-2x7": {
+		Description: "A4, 2 x 7, 99.1 x 38.1 мм (L7163)",
+		PageWidth:   210, PageHeight: 297,
+		Columns: 2, Rows: 7,
+		LabelWidth: 99.1, LabelHeight: 38.1,
+		MarginTop: 15.15, MarginLeft: 4.65, GapX: 2.5,
+	},
+	"a4-2x4": {
+		Description: "A4, 2 x 4, 99.1 x 67.7 мм (L7165)",
+		PageWidth:   210, PageHeight: 297,
+		Columns: 2, Rows: 4,
+		LabelWidth: 99.1, LabelHeight: 67.7,
+		MarginTop: 13.1, MarginLeft: 4.65, GapX: 2.5,
+	},
+	"a4-tent": {
+		Description: "A4, складная табличка на стол",
+		PageWidth:   210, PageHeight: 297,
+		Columns: 1, Rows: 1,
+		LabelWidth: 210, LabelHeight: 148.5,
+		Tent: true,
+	},
+	"roll-62": {
+		Description: "Рулон 62 мм, этикетка 62 x 40 мм",
+		PageWidth:   62, PageHeight: 40,
+		Columns: 1, Rows: 1,
+		LabelWidth: 62, LabelHeight: 40,
+	},
+	"roll-62x100": {
+		Description: "Рулон 62 мм, этикетка 62 x 100 мм",
+		PageWidth:   62, PageHeight: 100,
+		Columns: 1, Rows: 1,
+		LabelWidth: 62, LabelHeight: 100,
+	},
+}
+
+// GetLayout возвращает раскладку по имени.
+func GetLayout(name string) (Layout, bool) {
+	layout, ok := layouts[name]
+	layout.Name = name
+	return layout, ok
+}
+
+// Layouts возвращает все раскладки, отсортированные по имени.
+func Layouts() []Layout {
+	list := make([]Layout, 0, len(layouts))
+	for name := range layouts {
+		layout, _ := GetLayout(name)
+		list = append(list, layout)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
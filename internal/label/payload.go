@@ -0,0 +1,10 @@
+package label
+
+type LabelRequest struct {
+	Slugs      []string `json:"slugs" example:"espresso,latte" validate:"required,min=1,max=500,dive,required"`
+	Layout     string   `json:"layout" example:"a4-3x8" validate:"required"`
+	Bleed      float64  `json:"bleed" example:"2" validate:"gte=0,lte=5"`
+	Copies     int      `json:"copies" example:"1" validate:"gte=0,lte=100"`
+	Background string   `json:"background" example:"#f5efe6"`
+	Location   string   `json:"location" example:"bar" validate:"max=50"`
+}
@@ -0,0 +1,224 @@
+package label
+
+import (
+	"coffee/configs"
+	"coffee/internal/coffee"
+	"coffee/pkg/pdf"
+	"coffee/pkg/qr"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnknownLayout = errors.New("unknown layout")
+	ErrNoCoffees     = errors.New("no coffees found")
+)
+
+const (
+	maxBleed  = 5
+	maxCopies = 100
+)
+
+// Options — параметры листа этикеток. Bleed — вылеты в миллиметрах:
+// фон этикетки выходит за линию реза на эту величину.
+type Options struct {
+	Layout     Layout
+	Bleed      float64
+	Copies     int
+	Background color.RGBA
+	Location   string
+}
+
+type LabelService struct {
+	CoffeeRepository *coffee.CoffeeRepository
+	Config           *configs.Config
+}
+
+func NewLabelService(coffeeRepository *coffee.CoffeeRepository, conf *configs.Config) *LabelService {
+	return &LabelService{
+		CoffeeRepository: coffeeRepository,
+		Config:           conf,
+	}
+}
+
+// RenderSlugs рисует лист этикеток для кофе с указанными slug в том же
+// порядке. Неизвестные slug пропускаются.
+func (service *LabelService) RenderSlugs(slugs []string, opts Options) ([]byte, error) {
+	coffees, err := service.CoffeeRepository.GetBySlugs(slugs)
+	if err != nil {
+		return nil, err
+	}
+	return service.Render(coffees, opts)
+}
+
+// Render рисует PDF с этикетками: по Copies штук на каждый кофе.
+func (service *LabelService) Render(coffees []coffee.Coffee, opts Options) ([]byte, error) {
+	if len(coffees) == 0 {
+		return nil, ErrNoCoffees
+	}
+	if opts.Bleed < 0 || opts.Bleed > maxBleed {
+		return nil, fmt.Errorf("bleed must be between 0 and %d mm", maxBleed)
+	}
+	if opts.Copies <= 0 {
+		opts.Copies = 1
+	}
+	if opts.Copies > maxCopies {
+		return nil, fmt.Errorf("copies must be at most %d", maxCopies)
+	}
+
+	items := make([]*coffee.Coffee, 0, len(coffees)*opts.Copies)
+	for i := range coffees {
+		for range opts.Copies {
+			items = append(items, &coffees[i])
+		}
+	}
+
+	layout := opts.Layout
+	bleed := opts.Bleed * pdf.Mm
+	// На листе с одной этикеткой вылеты увеличивают страницу, на сетке
+	// фон просто заходит в промежутки между этикетками.
+	offset := 0.0
+	if layout.Single() {
+		offset = bleed
+	}
+	pageWidth := layout.PageWidth*pdf.Mm + 2*offset
+	pageHeight := layout.PageHeight*pdf.Mm + 2*offset
+	width, height := layout.LabelWidth*pdf.Mm, layout.LabelHeight*pdf.Mm
+
+	flags := map[string]image.Image{}
+	doc := pdf.New()
+	var page *pdf.Page
+	for i, item := range items {
+		cell := i % layout.PerPage()
+		if cell == 0 {
+			page = doc.AddPage(pageWidth, pageHeight)
+		}
+		col, row := cell%layout.Columns, cell/layout.Columns
+		x := offset + (layout.MarginLeft+float64(col)*(layout.LabelWidth+layout.GapX))*pdf.Mm
+		y := offset + (layout.MarginTop+float64(row)*(layout.LabelHeight+layout.GapY))*pdf.Mm
+		flag := service.flag(flags, item)
+
+		if layout.Tent {
+			// Верхняя половина перевернута, чтобы после сгиба надпись
+			// читалась с обеих сторон стола.
+			page.Rotate180(x+width/2, y+height/2)
+			if err := service.draw(page, x, y, width, height, bleed, item, flag, opts); err != nil {
+				return nil, err
+			}
+			page.Restore()
+			y += height
+		}
+		if err := service.draw(page, x, y, width, height, bleed, item, flag, opts); err != nil {
+			return nil, err
+		}
+	}
+	return doc.Bytes()
+}
+
+// flag загружает иконку флага один раз на документ. Без иконки этикетка
+// печатается без флага.
+func (service *LabelService) flag(cache map[string]image.Image, item *coffee.Coffee) image.Image {
+	if item.FlagIcon == "" {
+		return nil
+	}
+	path := coffee.FlagIconPath(item)
+	if img, ok := cache[path]; ok {
+		return img
+	}
+	var img image.Image
+	file, err := os.Open(path)
+	if err == nil {
+		img, _, err = image.Decode(file)
+		file.Close()
+	}
+	if err != nil {
+		img = nil
+	}
+	cache[path] = img
+	return img
+}
+
+// draw рисует одну этикетку: слева флаг, название и цены, справа QR-код.
+func (service *LabelService) draw(page *pdf.Page, x, y, width, height, bleed float64, item *coffee.Coffee, flag image.Image, opts Options) error {
+	background := opts.Background
+	if background != (color.RGBA{}) {
+		page.SetFill(background.R, background.G, background.B)
+		page.Rect(x-bleed, y-bleed, width+2*bleed, height+2*bleed)
+		page.Fill()
+	}
+
+	pad := min(width, height) * 0.07
+	side := min(height-2*pad, width*0.45)
+	err := qr.DrawPDF(page, x+width-pad-side, y+(height-side)/2, side,
+		coffee.QRContent(service.Config, item, opts.Location),
+		qr.Options{Margin: 1, Background: background})
+	if err != nil {
+		return err
+	}
+
+	left := x + pad
+	textWidth := width - 3*pad - side
+	top := y + pad
+	if flag != nil {
+		bounds := flag.Bounds()
+		flagHeight := height * 0.2
+		flagWidth := flagHeight * float64(bounds.Dx()) / float64(bounds.Dy())
+		if flagWidth > textWidth/2 {
+			flagWidth = textWidth / 2
+			flagHeight = flagWidth * float64(bounds.Dy()) / float64(bounds.Dx())
+		}
+		page.Image(flag, left, top, flagWidth, flagHeight)
+		top += flagHeight + pad/2
+	}
+
+	page.SetFill(0, 0, 0)
+	name, size := fitText(item.Name, textWidth, height*0.16, height*0.09)
+	top += size
+	page.Text(left, top, size, name)
+
+	priceSize := height * 0.13
+	top += priceSize * 1.3
+	page.Text(left, top, priceSize, fmt.Sprintf("%.2f", item.Price))
+
+	rates := make([]string, 0, 2)
+	if item.Dollar > 0 {
+		rates = append(rates, fmt.Sprintf("$%.2f", item.Dollar))
+	}
+	if item.Ruble > 0 {
+		rates = append(rates, fmt.Sprintf("%.2f руб.", item.Ruble))
+	}
+	if len(rates) > 0 {
+		rateSize := height * 0.08
+		top += rateSize * 1.5
+		rate, size := fitText(strings.Join(rates, " · "), textWidth, rateSize, rateSize*0.7)
+		page.Text(left, top, size, rate)
+	}
+	return nil
+}
+
+// fitText уменьшает кегль от maxSize до minSize, пока строка не влезет
+// в ширину, а если не влезла и так — обрезает ее с многоточием.
+func fitText(text string, width, maxSize, minSize float64) (string, float64) {
+	size := maxSize
+	for size > minSize && pdf.TextWidth(text, size) > width {
+		size *= 0.9
+	}
+	size = max(size, minSize)
+	if pdf.TextWidth(text, size) <= width {
+		return text, size
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && pdf.TextWidth(string(runes)+"…", size) > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimSpace(string(runes)) + "…", size
+}
@@ -0,0 +1,90 @@
+package media
+
+import (
+	"coffee/configs"
+	"coffee/pkg/signurl"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// UploadTarget — куда и как клиент загружает файл в обход API.
+type UploadTarget struct {
+	Key     string            `json:"key" example:"3f8a1c2e-6f1b-4a3d-9a57-1d2c3b4a5e6f.jpg"`
+	URL     string            `json:"url" example:"/uploads/3f8a1c2e-6f1b-4a3d-9a57-1d2c3b4a5e6f.jpg?expires=1767225600&signature=..."`
+	Method  string            `json:"method" example:"PUT"`
+	Headers map[string]string `json:"headers"`
+}
+
+// Backend — хранилище, в которое клиент загружает файлы напрямую.
+// Загруженный объект остается во временной области, пока его не подтвердят.
+type Backend interface {
+	PresignUpload(upload *Upload) (*UploadTarget, error)
+	Open(key string) (io.ReadCloser, error)
+	Remove(key string) error
+}
+
+// NewBackend создает хранилище, выбранное в конфиге.
+func NewBackend(conf *configs.Config) (Backend, error) {
+	switch conf.Storage.Backend {
+	case "", "local":
+		return &LocalBackend{
+			Dir:    conf.Storage.UploadDir,
+			Signer: signurl.NewSigner(conf.Media.SigningSecret),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", conf.Storage.Backend)
+	}
+}
+
+// LocalBackend принимает загрузки на PUT /uploads/{key} этого же сервиса
+// и складывает их на диск потоком, без разбора multipart-формы в памяти.
+type LocalBackend struct {
+	Dir    string
+	Signer *signurl.Signer
+}
+
+func (backend *LocalBackend) PresignUpload(upload *Upload) (*UploadTarget, error) {
+	return &UploadTarget{
+		Key:    upload.Key,
+		URL:    backend.Signer.Sign(backend.path(upload.Key), upload.ExpiresAt),
+		Method: "PUT",
+		Headers: map[string]string{
+			"Content-Type": upload.ContentType,
+		},
+	}, nil
+}
+
+func (backend *LocalBackend) Open(key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(backend.Dir, key))
+}
+
+func (backend *LocalBackend) Remove(key string) error {
+	return removeIfExists(filepath.Join(backend.Dir, key))
+}
+
+// Write сохраняет тело загрузки под ключом key.
+func (backend *LocalBackend) Write(key string, src io.Reader) error {
+	if err := os.MkdirAll(backend.Dir, 0755); err != nil {
+		return fmt.Errorf("ошибка создания директории: %w", err)
+	}
+	dest, err := os.Create(filepath.Join(backend.Dir, key))
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла: %w", err)
+	}
+	_, err = io.Copy(dest, src)
+	closeErr := dest.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = backend.Remove(key)
+		return fmt.Errorf("ошибка записи файла: %w", err)
+	}
+	return nil
+}
+
+func (backend *LocalBackend) path(key string) string {
+	return "/uploads/" + key
+}
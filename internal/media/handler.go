@@ -0,0 +1,106 @@
+package media
+
+import (
+	"coffee/configs"
+	"coffee/internal/user"
+	"coffee/pkg/middleware"
+	"coffee/pkg/req"
+	"coffee/pkg/res"
+	"coffee/pkg/signurl"
+	"errors"
+	"net/http"
+)
+
+type UploadHandler struct {
+	MediaService *MediaService
+	Signer       *signurl.Signer
+}
+
+type UploadHandlerDeps struct {
+	MediaService *MediaService
+	Config       *configs.Config
+	Auth         *middleware.Auth
+}
+
+func NewUploadHandler(router *http.ServeMux, deps UploadHandlerDeps) {
+	handler := &UploadHandler{
+		MediaService: deps.MediaService,
+		Signer:       signurl.NewSigner(deps.Config.Media.SigningSecret),
+	}
+	router.Handle("POST /uploads/presign", middleware.RequirePermission(user.PermMediaUpload, handler.PresignUpload(), deps.Auth))
+	if _, ok := deps.MediaService.Backend.(*LocalBackend); ok {
+		router.HandleFunc("PUT /uploads/{key}", handler.ReceiveUpload())
+	}
+}
+
+// @Summary Цель для прямой загрузки
+// @Description Выдает подписанную ссылку, по которой клиент загружает файл в хранилище в обход multipart-формы. После загрузки файл нужно подтвердить через POST /coffees/{slug}/uploads.
+// @Tags Uploads
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body PresignUploadRequest true "Тип и размер файла"
+// @Success 201 {object} UploadTarget
+// @Failure 400 {string} string "Ошибка в запросе"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /uploads/presign [post]
+func (handler *UploadHandler) PresignUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[PresignUploadRequest](&w, r)
+		if err != nil {
+			return
+		}
+		owner, _ := r.Context().Value(middleware.ContextEmailKey).(string)
+		target, err := handler.MediaService.PresignUpload(owner, body.ContentType, body.Size)
+		if errors.Is(err, ErrUploadInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, target, http.StatusCreated)
+	}
+}
+
+// @Summary Прямая загрузка файла
+// @Description Принимает тело файла по подписанной ссылке из /uploads/presign
+// @Tags Uploads
+// @Accept image/jpeg
+// @Produce json
+// @Param key path string true "Ключ загрузки"
+// @Param expires query int true "Срок действия ссылки (unix)"
+// @Param signature query string true "Подпись ссылки"
+// @Success 200 {object} UploadReceivedResponse
+// @Failure 400 {string} string "Файл не прошел проверку"
+// @Failure 403 {string} string "invalid signature"
+// @Failure 404 {string} string "upload not found"
+// @Failure 409 {string} string "файл уже загружен"
+// @Router /uploads/{key} [put]
+func (handler *UploadHandler) ReceiveUpload() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := handler.Signer.Verify(r.URL.Path, r.URL.Query()); err != nil {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		key := r.PathValue("key")
+		err := handler.MediaService.ReceiveUpload(key, r.Header.Get("Content-Type"), r.Body)
+		switch {
+		case errors.Is(err, ErrUploadNotFound):
+			http.Error(w, "upload not found", http.StatusNotFound)
+		case errors.Is(err, ErrUploadExpired):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		case errors.Is(err, ErrUploadDone):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, ErrUploadInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		default:
+			res.Json(w, UploadReceivedResponse{Key: key}, http.StatusOK)
+		}
+	}
+}
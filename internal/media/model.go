@@ -0,0 +1,31 @@
+package media
+
+import "time"
+
+// Blob — файл в хранилище, адресуемый по содержимому. Filename состоит из
+// sha256 содержимого и расширения, поэтому одинаковые загрузки в одну
+// директорию хранятся один раз. RefCount — число записей, ссылающихся на файл.
+type Blob struct {
+	ID        uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Dir       string `gorm:"size:50;uniqueIndex:idx_blob_dir_filename;not null"`
+	Filename  string `gorm:"size:100;uniqueIndex:idx_blob_dir_filename;not null"`
+	Hash      string `gorm:"size:64;index;not null"`
+	Size      int64  `gorm:"not null"`
+	RefCount  int    `gorm:"not null;default:0"`
+}
+
+// Upload — выданная цель для прямой загрузки файла в хранилище. Запись
+// живет до подтверждения загрузки или до истечения ExpiresAt.
+type Upload struct {
+	ID          uint `gorm:"primaryKey"`
+	CreatedAt   time.Time
+	Key         string     `gorm:"size:100;uniqueIndex;not null"`
+	Owner       string     `gorm:"size:50;not null"`
+	ContentType string     `gorm:"size:100;not null"`
+	Size        int64      `gorm:"not null"`
+	ExpiresAt   time.Time  `gorm:"index;not null"`
+	UploadedAt  *time.Time `gorm:"default:null"`
+	ConfirmedAt *time.Time `gorm:"default:null"`
+}
@@ -0,0 +1,10 @@
+package media
+
+type PresignUploadRequest struct {
+	ContentType string `json:"contentType" example:"image/jpeg" validate:"required,oneof=image/jpeg image/png image/webp image/gif"`
+	Size        int64  `json:"size" example:"2048000" validate:"required,gt=0"`
+}
+
+type UploadReceivedResponse struct {
+	Key string `json:"key" example:"3f8a1c2e-6f1b-4a3d-9a57-1d2c3b4a5e6f.jpg"`
+}
@@ -0,0 +1,140 @@
+package media
+
+import (
+	"coffee/pkg/db"
+	"errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type BlobRepository struct {
+	Database *db.Db
+}
+
+func NewBlobRepository(db *db.Db) *BlobRepository {
+	return &BlobRepository{
+		Database: db,
+	}
+}
+
+// Acquire увеличивает счетчик ссылок на blob, создавая запись при первой
+// ссылке. fn вызывается внутри той же транзакции, пока строка заблокирована.
+func (repo *BlobRepository) Acquire(blob *Blob, fn func() error) error {
+	return repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		blob.RefCount = 1
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "dir"}, {Name: "filename"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"ref_count":  gorm.Expr("blobs.ref_count + 1"),
+				"updated_at": gorm.Expr("NOW()"),
+			}),
+		}).Create(blob).Error
+		if err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// Release уменьшает счетчик ссылок. Когда ссылок не остается, запись
+// удаляется и вызывается fn (внутри транзакции). Если записи нет, fn
+// вызывается сразу: так удаляются файлы, загруженные до появления blobs.
+func (repo *BlobRepository) Release(dir, filename string, fn func() error) error {
+	return repo.Database.DB.Transaction(func(tx *gorm.DB) error {
+		var blob Blob
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("dir = ? AND filename = ?", dir, filename).
+			First(&blob).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fn()
+		}
+		if err != nil {
+			return err
+		}
+		if blob.RefCount > 1 {
+			return tx.Model(&blob).Update("ref_count", blob.RefCount-1).Error
+		}
+		if err := tx.Delete(&blob).Error; err != nil {
+			return err
+		}
+		return fn()
+	})
+}
+
+// Delete удаляет запись независимо от счетчика ссылок.
+func (repo *BlobRepository) Delete(dir, filename string) error {
+	return repo.Database.DB.
+		Where("dir = ? AND filename = ?", dir, filename).
+		Delete(&Blob{}).Error
+}
+
+func (repo *BlobRepository) CreateUpload(upload *Upload) (*Upload, error) {
+	result := repo.Database.DB.Create(upload)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return upload, nil
+}
+
+func (repo *BlobRepository) GetUpload(key string) (*Upload, error) {
+	var upload Upload
+	result := repo.Database.DB.Where("key = ?", key).First(&upload)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &upload, nil
+}
+
+// MarkUploaded отмечает загрузку принятой. Возвращает false, если файл по
+// этому ключу уже загружен: отметка ставится одним запросом, поэтому из
+// параллельных PUT проходит только один.
+func (repo *BlobRepository) MarkUploaded(key string, at time.Time) (bool, error) {
+	result := repo.Database.DB.Model(&Upload{}).
+		Where("key = ? AND uploaded_at IS NULL", key).
+		Update("uploaded_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ResetUploaded снимает отметку после неудачной загрузки.
+func (repo *BlobRepository) ResetUploaded(key string) error {
+	return repo.Database.DB.Model(&Upload{}).
+		Where("key = ?", key).
+		Update("uploaded_at", nil).Error
+}
+
+// MarkConfirmed отмечает, что загрузку подтверждает ее владелец. Как и
+// MarkUploaded, возвращает false, если загрузку уже подтверждают: из
+// параллельных подтверждений проходит только одно.
+func (repo *BlobRepository) MarkConfirmed(key, owner string, at time.Time) (bool, error) {
+	result := repo.Database.DB.Model(&Upload{}).
+		Where("key = ? AND owner = ? AND confirmed_at IS NULL", key, owner).
+		Update("confirmed_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ResetConfirmed снимает отметку после неудачного подтверждения.
+func (repo *BlobRepository) ResetConfirmed(key string) error {
+	return repo.Database.DB.Model(&Upload{}).
+		Where("key = ?", key).
+		Update("confirmed_at", nil).Error
+}
+
+func (repo *BlobRepository) DeleteUpload(key string) error {
+	return repo.Database.DB.Where("key = ?", key).Delete(&Upload{}).Error
+}
+
+func (repo *BlobRepository) ExpiredUploads(before time.Time) ([]Upload, error) {
+	var uploads []Upload
+	result := repo.Database.DB.Where("expires_at < ?", before).Find(&uploads)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return uploads, nil
+}
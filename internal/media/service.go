@@ -0,0 +1,107 @@
+package media
+
+import (
+	"coffee/configs"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// UploadDir — корень хранилища загруженных файлов.
+const UploadDir = "static/images"
+
+// AvatarsDir — директория аватаров пользователей внутри UploadDir.
+const AvatarsDir = "avatars"
+
+// MediaService хранит загруженные файлы в Root/<dir>/<sha256><ext> и ведет
+// счетчики ссылок на них в таблице blobs.
+type MediaService struct {
+	BlobRepository *BlobRepository
+	Backend        Backend
+	Storage        configs.StorageConfig
+	Root           string
+}
+
+func NewMediaService(blobRepository *BlobRepository, backend Backend, storage configs.StorageConfig) *MediaService {
+	return &MediaService{
+		BlobRepository: blobRepository,
+		Backend:        backend,
+		Storage:        storage,
+		Root:           UploadDir,
+	}
+}
+
+// Store сохраняет содержимое src в директорию dir и возвращает имя файла.
+// Если такой файл уже есть, он не перезаписывается, а получает еще одну ссылку.
+func (service *MediaService) Store(src io.Reader, dir, ext string) (string, error) {
+	fullDir := filepath.Join(service.Root, dir)
+	if err := os.MkdirAll(fullDir, 0755); err != nil {
+		return "", fmt.Errorf("ошибка создания директории: %w", err)
+	}
+	tmp, err := os.CreateTemp(fullDir, ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("ошибка создания файла: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	closeErr := tmp.Close()
+	if err != nil {
+		return "", fmt.Errorf("ошибка копирования файла: %w", err)
+	}
+	if closeErr != nil {
+		return "", fmt.Errorf("ошибка записи файла: %w", closeErr)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	blob := &Blob{
+		Dir:      dir,
+		Filename: sum + strings.ToLower(ext),
+		Hash:     sum,
+		Size:     size,
+	}
+	target := filepath.Join(fullDir, blob.Filename)
+	err = service.BlobRepository.Acquire(blob, func() error {
+		if _, err := os.Stat(target); err == nil {
+			return nil
+		}
+		return os.Rename(tmp.Name(), target)
+	})
+	if err != nil {
+		return "", fmt.Errorf("ошибка сохранения файла: %w", err)
+	}
+	return blob.Filename, nil
+}
+
+// Release снимает ссылку на файл и удаляет его, когда ссылок не осталось.
+func (service *MediaService) Release(dir, filename string) error {
+	if filename == "" {
+		return nil
+	}
+	filename = filepath.Base(filename)
+	return service.BlobRepository.Release(dir, filename, func() error {
+		return removeIfExists(filepath.Join(service.Root, dir, filename))
+	})
+}
+
+// Purge удаляет файл и его запись без учета ссылок. Используется сборщиком
+// мусора для файлов, на которые не ссылается ни одна запись.
+func (service *MediaService) Purge(dir, filename string) error {
+	if err := removeIfExists(filepath.Join(service.Root, dir, filename)); err != nil {
+		return err
+	}
+	return service.BlobRepository.Delete(dir, filename)
+}
+
+func removeIfExists(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
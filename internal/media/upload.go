@@ -0,0 +1,196 @@
+package media
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrUploadNotFound  = errors.New("загрузка не найдена")
+	ErrUploadExpired   = errors.New("срок загрузки истек")
+	ErrUploadInvalid   = errors.New("загруженный файл не прошел проверку")
+	ErrUploadDone      = errors.New("файл уже загружен")
+	ErrUploadConfirmed = errors.New("загрузка уже подтверждена")
+)
+
+// uploadExtensions — типы файлов, которые можно загружать напрямую.
+var uploadExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// PresignUpload регистрирует будущую загрузку и возвращает цель, в которую
+// клиент отправляет файл.
+func (service *MediaService) PresignUpload(owner, contentType string, size int64) (*UploadTarget, error) {
+	ext, ok := uploadExtensions[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: неподдерживаемый тип %s", ErrUploadInvalid, contentType)
+	}
+	if size <= 0 || size > service.Storage.MaxUploadSize {
+		return nil, fmt.Errorf("%w: размер должен быть от 1 до %d байт", ErrUploadInvalid, service.Storage.MaxUploadSize)
+	}
+	upload, err := service.BlobRepository.CreateUpload(&Upload{
+		Key:         uuid.New().String() + ext,
+		Owner:       owner,
+		ContentType: contentType,
+		Size:        size,
+		ExpiresAt:   time.Now().Add(service.Storage.UploadURLTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return service.Backend.PresignUpload(upload)
+}
+
+// ReceiveUpload принимает тело загрузки для LocalBackend. Размер тела
+// должен совпадать с заявленным. Файл по одной ссылке принимается один
+// раз: повторный PUT не может подменить содержимое до подтверждения.
+func (service *MediaService) ReceiveUpload(key, contentType string, body io.Reader) error {
+	local, ok := service.Backend.(*LocalBackend)
+	if !ok {
+		return errors.New("хранилище не принимает загрузки через API")
+	}
+	upload, err := service.getUpload(key)
+	if err != nil {
+		return err
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return ErrUploadExpired
+	}
+	if contentType != upload.ContentType {
+		return fmt.Errorf("%w: ожидался Content-Type %s", ErrUploadInvalid, upload.ContentType)
+	}
+	claimed, err := service.BlobRepository.MarkUploaded(key, time.Now())
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrUploadDone
+	}
+	counter := &countingReader{reader: io.LimitReader(body, upload.Size+1)}
+	err = local.Write(key, counter)
+	if err == nil && counter.n != upload.Size {
+		_ = local.Remove(key)
+		err = fmt.Errorf("%w: размер %d не совпадает с заявленным %d", ErrUploadInvalid, counter.n, upload.Size)
+	}
+	if err != nil {
+		// Неудачную загрузку можно повторить по той же ссылке.
+		if resetErr := service.BlobRepository.ResetUploaded(key); resetErr != nil {
+			log.Printf("media: не удалось сбросить загрузку %s: %v", key, resetErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// ConfirmUpload проверяет загруженный объект, переносит его в хранилище
+// в директорию dir и возвращает имя файла. Подтвердить загрузку может
+// только тот, кто ее запросил, и только один раз: параллельное
+// подтверждение получает ErrUploadConfirmed. Срок действия ссылки здесь не
+// проверяется: он ограничивает только саму загрузку.
+func (service *MediaService) ConfirmUpload(key, owner, dir string) (string, error) {
+	upload, err := service.getUpload(key)
+	if err != nil {
+		return "", err
+	}
+	if upload.Owner != owner {
+		return "", ErrUploadNotFound
+	}
+	claimed, err := service.BlobRepository.MarkConfirmed(key, owner, time.Now())
+	if err != nil {
+		return "", err
+	}
+	if !claimed {
+		return "", ErrUploadConfirmed
+	}
+	filename, err := service.storeUpload(upload, dir)
+	if err != nil {
+		// Неудачное подтверждение можно повторить.
+		if resetErr := service.BlobRepository.ResetConfirmed(key); resetErr != nil {
+			log.Printf("media: не удалось сбросить подтверждение %s: %v", key, resetErr)
+		}
+		return "", err
+	}
+	if err := service.Backend.Remove(key); err != nil {
+		log.Printf("media: не удалось удалить загрузку %s: %v", key, err)
+	}
+	if err := service.BlobRepository.DeleteUpload(key); err != nil {
+		log.Printf("media: не удалось удалить запись загрузки %s: %v", key, err)
+	}
+	return filename, nil
+}
+
+// storeUpload проверяет тип и размер загруженного объекта и сохраняет его
+// в директорию dir.
+func (service *MediaService) storeUpload(upload *Upload, dir string) (string, error) {
+	object, err := service.Backend.Open(upload.Key)
+	if err != nil {
+		return "", fmt.Errorf("%w: файл не загружен", ErrUploadInvalid)
+	}
+	defer object.Close()
+
+	reader := bufio.NewReaderSize(object, 512)
+	head, _ := reader.Peek(512)
+	if detected := http.DetectContentType(head); detected != upload.ContentType {
+		return "", fmt.Errorf("%w: содержимое %s не совпадает с заявленным %s", ErrUploadInvalid, detected, upload.ContentType)
+	}
+	counter := &countingReader{reader: io.LimitReader(reader, upload.Size+1)}
+	filename, err := service.Store(counter, dir, uploadExtensions[upload.ContentType])
+	if err != nil {
+		return "", err
+	}
+	if counter.n != upload.Size {
+		_ = service.Release(dir, filename)
+		return "", fmt.Errorf("%w: размер %d не совпадает с заявленным %d", ErrUploadInvalid, counter.n, upload.Size)
+	}
+	return filename, nil
+}
+
+// PurgeExpiredUploads удаляет неподтвержденные загрузки, срок которых истек
+// раньше before.
+func (service *MediaService) PurgeExpiredUploads(before time.Time) (int, error) {
+	uploads, err := service.BlobRepository.ExpiredUploads(before)
+	if err != nil {
+		return 0, err
+	}
+	for _, upload := range uploads {
+		if err := service.Backend.Remove(upload.Key); err != nil {
+			return 0, err
+		}
+		if err := service.BlobRepository.DeleteUpload(upload.Key); err != nil {
+			return 0, err
+		}
+	}
+	return len(uploads), nil
+}
+
+func (service *MediaService) getUpload(key string) (*Upload, error) {
+	upload, err := service.BlobRepository.GetUpload(key)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+type countingReader struct {
+	reader io.Reader
+	n      int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
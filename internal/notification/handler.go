@@ -6,7 +6,6 @@ import (
 	"coffee/pkg/res"
 	"fmt"
 	"net/http"
-	"net/smtp"
 )
 
 type NotificationHandler struct {
@@ -39,27 +38,7 @@ func (handler *NotificationHandler) SendEmail() http.HandlerFunc {
 			return
 		}
 
-		smtpHost := handler.Config.Smtp.SmtpHost
-		smtpPort := handler.Config.Smtp.SmtpPort
-
-		from := handler.Config.Smtp.From
-		password := handler.Config.Smtp.Password
-		fmt.Println(smtpHost, smtpPort)
-		fmt.Println(from, password)
-		to := []string{
-			body.Email,
-		}
-
-		subject := body.Subject
-		bodyMessage := body.Body
-		message := []byte("To: " + to[0] + "\r\n" +
-			"Subject: " + subject + "\r\n" +
-			"\r\n" +
-			bodyMessage + "\r\n")
-
-		auth := smtp.PlainAuth("", from, password, smtpHost)
-
-		err = smtp.SendMail(smtpHost+":"+smtpPort, auth, from, to, message)
+		err = NewMailer(handler.Config).Send(body.Email, body.Subject, body.Body)
 		if err != nil {
 			fmt.Println(err)
 			return
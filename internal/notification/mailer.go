@@ -0,0 +1,43 @@
+package notification
+
+import (
+	"coffee/configs"
+	"errors"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+var ErrMailerNotConfigured = errors.New("smtp is not configured")
+
+// Mailer отправляет письма через SMTP из configs.SmtpConfig.
+type Mailer struct {
+	Config *configs.Config
+}
+
+func NewMailer(conf *configs.Config) *Mailer {
+	return &Mailer{
+		Config: conf,
+	}
+}
+
+// Send отправляет текстовое письмо в UTF-8.
+func (mailer *Mailer) Send(to, subject, body string) error {
+	conf := mailer.Config.Smtp
+	if conf.SmtpHost == "" || conf.From == "" {
+		return ErrMailerNotConfigured
+	}
+	headers := []string{
+		"From: " + conf.From,
+		"To: " + to,
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	message := strings.Join(headers, "\r\n") + "\r\n\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n") + "\r\n"
+
+	auth := smtp.PlainAuth("", conf.From, conf.Password, conf.SmtpHost)
+	return smtp.SendMail(conf.SmtpHost+":"+conf.SmtpPort, auth, conf.From, []string{to}, []byte(message))
+}
@@ -0,0 +1,123 @@
+package scan
+
+import (
+	"coffee/configs"
+	"coffee/internal/coffee"
+	"coffee/internal/user"
+	"coffee/pkg/middleware"
+	"coffee/pkg/req"
+	"coffee/pkg/res"
+	"log"
+	"net/http"
+	"time"
+)
+
+type ScanHandler struct {
+	ScanRepository   *ScanRepository
+	CoffeeRepository *coffee.CoffeeRepository
+	Config           *configs.Config
+}
+
+type ScanHandlerDeps struct {
+	ScanRepository   *ScanRepository
+	CoffeeRepository *coffee.CoffeeRepository
+	Config           *configs.Config
+	Auth             *middleware.Auth
+}
+
+func NewScanHandler(router *http.ServeMux, deps ScanHandlerDeps) {
+	handler := &ScanHandler{
+		ScanRepository:   deps.ScanRepository,
+		CoffeeRepository: deps.CoffeeRepository,
+		Config:           deps.Config,
+	}
+	router.HandleFunc("GET /q/{code}", handler.Redirect())
+	router.Handle("GET /coffees/{slug}/scans", middleware.RequirePermission(user.PermScansRead, handler.Stats(), deps.Auth))
+}
+
+const (
+	dateLayout    = "2006-01-02"
+	defaultPeriod = 30 * 24 * time.Hour
+)
+
+// @Summary Переход по QR-коду
+// @Description Засчитывает скан и перенаправляет на страницу кофе
+// @Tags Scans
+// @Param code path string true "Короткий код"
+// @Param l query string false "Метка места"
+// @Success 302 {string} string "Redirect"
+// @Failure 404 {string} string "not found"
+// @Router /q/{code} [get]
+func (handler *ScanHandler) Redirect() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := handler.CoffeeRepository.GetByShortCode(r.PathValue("code"))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		_, err = handler.ScanRepository.Create(&Event{
+			CoffeeID:  target.ID,
+			UserAgent: req.Truncate(r.UserAgent(), 255),
+			Referrer:  req.Truncate(r.Referer(), 500),
+			Location:  req.Truncate(r.URL.Query().Get("l"), coffee.MaxLocationLen),
+		})
+		if err != nil {
+			log.Printf("scan: не удалось сохранить скан %s: %v", target.Slug, err)
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, coffee.PublicURL(handler.Config, target.Slug), http.StatusFound)
+	}
+}
+
+// @Summary Статистика сканов
+// @Description Число сканов QR-кода кофе по дням и местам
+// @Tags Scans
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param slug path string true "slug кофе"
+// @Param from query string false "Начало периода, YYYY-MM-DD (по умолчанию 30 дней назад)"
+// @Param to query string false "Конец периода включительно, YYYY-MM-DD (по умолчанию сегодня)"
+// @Success 200 {object} StatsResponse
+// @Failure 400 {string} string "Неверные параметры"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "coffee not found"
+// @Router /coffees/{slug}/scans [get]
+func (handler *ScanHandler) Stats() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := handler.CoffeeRepository.GetBySlug(r.PathValue("slug"))
+		if err != nil {
+			http.Error(w, "coffee not found", http.StatusNotFound)
+			return
+		}
+		to := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+		if raw := r.URL.Query().Get("to"); raw != "" {
+			day, err := time.Parse(dateLayout, raw)
+			if err != nil {
+				http.Error(w, "invalid to", http.StatusBadRequest)
+				return
+			}
+			to = day.Add(24 * time.Hour)
+		}
+		from := to.Add(-defaultPeriod)
+		if raw := r.URL.Query().Get("from"); raw != "" {
+			from, err = time.Parse(dateLayout, raw)
+			if err != nil {
+				http.Error(w, "invalid from", http.StatusBadRequest)
+				return
+			}
+		}
+		if !from.Before(to) {
+			http.Error(w, "from must be before to", http.StatusBadRequest)
+			return
+		}
+		stats, err := handler.ScanRepository.Stats(target.ID, from, to)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		stats.Slug = target.Slug
+		res.Json(w, stats, http.StatusOK)
+	}
+}
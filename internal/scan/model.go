@@ -0,0 +1,17 @@
+package scan
+
+import "time"
+
+// Event — переход по короткой ссылке из QR-кода кофе.
+type Event struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+	CoffeeID  uint      `json:"-" gorm:"index;not null"`
+	UserAgent string    `json:"userAgent" gorm:"size:255"`
+	Referrer  string    `json:"referrer" gorm:"size:500"`
+	Location  string    `json:"location" example:"table-5" gorm:"size:50;index"`
+}
+
+func (Event) TableName() string {
+	return "scan_events"
+}
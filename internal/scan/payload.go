@@ -0,0 +1,23 @@
+package scan
+
+import "time"
+
+type DayCount struct {
+	Day   string `json:"day" example:"2026-10-19"`
+	Count int64  `json:"count" example:"42"`
+}
+
+type LocationCount struct {
+	Location string `json:"location" example:"table-5"`
+	Count    int64  `json:"count" example:"12"`
+}
+
+type StatsResponse struct {
+	Slug       string          `json:"slug" example:"espresso"`
+	From       time.Time       `json:"from"`
+	To         time.Time       `json:"to"`
+	Total      int64           `json:"total" example:"120"`
+	LastScanAt *time.Time      `json:"lastScanAt"`
+	ByDay      []DayCount      `json:"byDay"`
+	ByLocation []LocationCount `json:"byLocation"`
+}
@@ -0,0 +1,64 @@
+package scan
+
+import (
+	"coffee/pkg/db"
+	"gorm.io/gorm"
+	"time"
+)
+
+type ScanRepository struct {
+	Database *db.Db
+}
+
+func NewScanRepository(db *db.Db) *ScanRepository {
+	return &ScanRepository{
+		Database: db,
+	}
+}
+
+func (repo *ScanRepository) Create(event *Event) (*Event, error) {
+	result := repo.Database.DB.Create(event)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return event, nil
+}
+
+// Stats считает сканы кофе за период [from, to).
+func (repo *ScanRepository) Stats(coffeeID uint, from, to time.Time) (*StatsResponse, error) {
+	stats := &StatsResponse{
+		From:       from,
+		To:         to,
+		ByDay:      []DayCount{},
+		ByLocation: []LocationCount{},
+	}
+	scope := repo.Database.DB.Model(&Event{}).
+		Where("coffee_id = ? AND created_at >= ? AND created_at < ?", coffeeID, from, to)
+
+	if err := scope.Session(&gorm.Session{}).Count(&stats.Total).Error; err != nil {
+		return nil, err
+	}
+	if err := scope.Session(&gorm.Session{}).
+		Select("to_char(date_trunc('day', created_at), 'YYYY-MM-DD') AS day, COUNT(*) AS count").
+		Group("day").
+		Order("day").
+		Scan(&stats.ByDay).Error; err != nil {
+		return nil, err
+	}
+	if err := scope.Session(&gorm.Session{}).
+		Select("location, COUNT(*) AS count").
+		Group("location").
+		Order("count DESC").
+		Scan(&stats.ByLocation).Error; err != nil {
+		return nil, err
+	}
+	var last Event
+	result := scope.Session(&gorm.Session{}).Order("created_at DESC").Limit(1).Find(&last)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		stats.LastScanAt = &last.CreatedAt
+	}
+	return stats, nil
+}
@@ -0,0 +1,337 @@
+package table
+
+import (
+	"coffee/configs"
+	"coffee/internal/user"
+	"coffee/pkg/middleware"
+	"coffee/pkg/qr"
+	"coffee/pkg/req"
+	"coffee/pkg/res"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+type TableHandler struct {
+	TableService *TableService
+}
+
+type TableHandlerDeps struct {
+	TableRepository *TableRepository
+	Config          *configs.Config
+	Auth            *middleware.Auth
+}
+
+func NewTableHandler(router *http.ServeMux, deps TableHandlerDeps) {
+	handler := &TableHandler{
+		TableService: NewTableService(deps.TableRepository, deps.Config),
+	}
+	router.HandleFunc("GET /t/{token}", handler.Open())
+	router.HandleFunc("GET /table-sessions/{id}", handler.GetSession())
+	router.Handle("GET /tables", middleware.RequirePermission(user.PermTablesManage, handler.GetAll(), deps.Auth))
+	router.Handle("POST /tables", middleware.RequirePermission(user.PermTablesManage, handler.Create(), deps.Auth))
+	router.Handle("GET /tables/{id}", middleware.RequirePermission(user.PermTablesManage, handler.Get(), deps.Auth))
+	router.Handle("PATCH /tables/{id}", middleware.RequirePermission(user.PermTablesManage, handler.Update(), deps.Auth))
+	router.Handle("DELETE /tables/{id}", middleware.RequirePermission(user.PermTablesManage, handler.Delete(), deps.Auth))
+	router.Handle("POST /tables/{id}/rotate", middleware.RequirePermission(user.PermTablesManage, handler.Rotate(), deps.Auth))
+	router.Handle("GET /tables/{id}/qr", middleware.RequirePermission(user.PermTablesManage, handler.GetQR(), deps.Auth))
+}
+
+// @Summary Переход по QR-коду стола
+// @Description Проверяет подпись ссылки, открывает сессию меню, привязанную к столу, и перенаправляет в меню
+// @Tags Tables
+// @Param token path string true "Подписанный токен стола"
+// @Success 302 {string} string "Redirect"
+// @Failure 404 {string} string "invalid table link"
+// @Failure 410 {string} string "table is not active"
+// @Router /t/{token} [get]
+func (handler *TableHandler) Open() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := handler.TableService.OpenSession(r.PathValue("token"), req.Truncate(r.UserAgent(), 255))
+		if errors.Is(err, ErrInvalidLink) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, ErrTableInactive) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, handler.TableService.MenuURL(session), http.StatusFound)
+	}
+}
+
+// @Summary Сессия меню стола
+// @Description Возвращает стол, к которому привязана сессия меню
+// @Tags Tables
+// @Produce json
+// @Param id path string true "ID сессии"
+// @Success 200 {object} Session
+// @Failure 404 {string} string "session not found"
+// @Failure 410 {string} string "table session has expired"
+// @Router /table-sessions/{id} [get]
+func (handler *TableHandler) GetSession() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session, err := handler.TableService.GetSession(r.PathValue("id"))
+		if errors.Is(err, ErrSessionExpired) || errors.Is(err, ErrTableInactive) {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		if err != nil {
+			http.Error(w, "session not found", http.StatusNotFound)
+			return
+		}
+		res.Json(w, session, http.StatusOK)
+	}
+}
+
+// @Summary Список столов
+// @Tags Tables
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param location query string false "Фильтр по месту"
+// @Success 200 {array} TableResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Router /tables [get]
+func (handler *TableHandler) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tables, err := handler.TableService.TableRepository.GetAll(r.URL.Query().Get("location"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		response := make([]TableResponse, 0, len(tables))
+		for i := range tables {
+			response = append(response, handler.response(&tables[i]))
+		}
+		res.Json(w, response, http.StatusOK)
+	}
+}
+
+// @Summary Создать стол
+// @Tags Tables
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param request body TableCreateRequest true "Стол"
+// @Success 201 {object} TableResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 409 {string} string "table already exists"
+// @Router /tables [post]
+func (handler *TableHandler) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := req.HandleBody[TableCreateRequest](&w, r)
+		if err != nil {
+			return
+		}
+		table, err := handler.TableService.TableRepository.Create(&Table{
+			Name:     body.Name,
+			Location: body.Location,
+			Active:   true,
+			Version:  1,
+		})
+		if errors.Is(err, ErrTableExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, handler.response(table), http.StatusCreated)
+	}
+}
+
+// @Summary Получить стол
+// @Tags Tables
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID стола"
+// @Success 200 {object} TableResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "table not found"
+// @Router /tables/{id} [get]
+func (handler *TableHandler) Get() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		table, ok := handler.table(w, r)
+		if !ok {
+			return
+		}
+		res.Json(w, handler.response(table), http.StatusOK)
+	}
+}
+
+// @Summary Изменить стол
+// @Description Меняет название, место или активность стола. Неактивный стол не открывает сессии меню.
+// @Tags Tables
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID стола"
+// @Param request body TableUpdateRequest true "Изменения"
+// @Success 200 {object} TableResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "table not found"
+// @Failure 409 {string} string "table already exists"
+// @Router /tables/{id} [patch]
+func (handler *TableHandler) Update() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		table, ok := handler.table(w, r)
+		if !ok {
+			return
+		}
+		body, err := req.HandleBody[TableUpdateRequest](&w, r)
+		if err != nil {
+			return
+		}
+		if body.Name != nil {
+			table.Name = *body.Name
+		}
+		if body.Location != nil {
+			table.Location = *body.Location
+		}
+		if body.Active != nil {
+			table.Active = *body.Active
+		}
+		table, err = handler.TableService.TableRepository.Update(table)
+		if errors.Is(err, ErrTableExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, handler.response(table), http.StatusOK)
+	}
+}
+
+// @Summary Удалить стол
+// @Tags Tables
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID стола"
+// @Success 204 {string} string "No Content"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "table not found"
+// @Router /tables/{id} [delete]
+func (handler *TableHandler) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		table, ok := handler.table(w, r)
+		if !ok {
+			return
+		}
+		if err := handler.TableService.TableRepository.Delete(table.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// @Summary Перевыпустить QR-код стола
+// @Description Меняет подпись ссылки стола. Старые напечатанные QR-коды перестают работать.
+// @Tags Tables
+// @Produce json
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID стола"
+// @Success 200 {object} TableResponse
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "table not found"
+// @Router /tables/{id}/rotate [post]
+func (handler *TableHandler) Rotate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		table, ok := handler.table(w, r)
+		if !ok {
+			return
+		}
+		table, err := handler.TableService.Rotate(table)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res.Json(w, handler.response(table), http.StatusOK)
+	}
+}
+
+// @Summary QR-код стола
+// @Description Рисует QR-код с подписанной ссылкой стола
+// @Tags Tables
+// @Produce image/png
+// @Produce image/svg+xml
+// @Produce application/pdf
+// @Security BearerAuth
+// @Param Authorization header string true "Bearer токен авторизации" default(Bearer <token>)
+// @Param id path int true "ID стола"
+// @Param format query string false "png, svg или pdf" default(png)
+// @Param size query int false "Сторона кода" default(256)
+// @Param ecc query string false "Уровень коррекции: L, M, Q, H" default(M)
+// @Param margin query int false "Рамка в модулях" default(4)
+// @Param fg query string false "Цвет кода, rrggbb"
+// @Param bg query string false "Цвет фона, rrggbb"
+// @Param rounded query bool false "Скругленные модули"
+// @Param caption query string false "Подпись под кодом"
+// @Success 200 {file} file "QR-код"
+// @Failure 400 {string} string "Неверные параметры"
+// @Failure 401 {string} string "Unauthorized"
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "table not found"
+// @Router /tables/{id}/qr [get]
+func (handler *TableHandler) GetQR() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		table, ok := handler.table(w, r)
+		if !ok {
+			return
+		}
+		opts, err := qr.ParseOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := qr.Render(handler.TableService.Link(table), opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", opts.Format.ContentType())
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Write(data)
+	}
+}
+
+// table загружает стол из пути запроса или отвечает 404.
+func (handler *TableHandler) table(w http.ResponseWriter, r *http.Request) (*Table, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "table not found", http.StatusNotFound)
+		return nil, false
+	}
+	table, err := handler.TableService.TableRepository.GetByID(uint(id))
+	if err != nil {
+		http.Error(w, "table not found", http.StatusNotFound)
+		return nil, false
+	}
+	return table, true
+}
+
+func (handler *TableHandler) response(table *Table) TableResponse {
+	return TableResponse{
+		Table: *table,
+		Link:  handler.TableService.Link(table),
+	}
+}
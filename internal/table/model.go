@@ -0,0 +1,30 @@
+package table
+
+import "time"
+
+// Table — стол в зале. Version входит в подпись QR-кода: после ротации
+// старые напечатанные коды перестают работать.
+type Table struct {
+	ID        uint      `json:"id" example:"1" gorm:"primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Name      string    `json:"name" example:"5" gorm:"size:50;uniqueIndex:idx_table_location_name;not null"`
+	Location  string    `json:"location" example:"terrace" gorm:"size:50;uniqueIndex:idx_table_location_name;not null"`
+	Active    bool      `json:"active" example:"true" gorm:"not null;default:true"`
+	Version   int       `json:"version" example:"1" gorm:"not null;default:1"`
+}
+
+// Session — сессия меню, открытая по QR-коду стола. Заказы из меню
+// привязываются к столу через ее ID.
+type Session struct {
+	ID        string    `json:"id" example:"3f8a1c2e-6f1b-4a3d-9a57-1d2c3b4a5e6f" gorm:"size:36;primaryKey"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"index;not null"`
+	TableID   uint      `json:"-" gorm:"index;not null"`
+	Table     Table     `json:"table" gorm:"constraint:OnDelete:CASCADE"`
+	UserAgent string    `json:"-" gorm:"size:255"`
+}
+
+func (Session) TableName() string {
+	return "table_sessions"
+}
@@ -0,0 +1,18 @@
+package table
+
+type TableCreateRequest struct {
+	Name     string `json:"name" example:"5" validate:"required,max=50"`
+	Location string `json:"location" example:"terrace" validate:"required,max=50"`
+}
+
+type TableUpdateRequest struct {
+	Name     *string `json:"name" example:"5" validate:"omitempty,min=1,max=50"`
+	Location *string `json:"location" example:"terrace" validate:"omitempty,min=1,max=50"`
+	Active   *bool   `json:"active" example:"true"`
+}
+
+// TableResponse — стол вместе со ссылкой для его QR-кода.
+type TableResponse struct {
+	Table
+	Link string `json:"link" example:"https://coffee.example.com/t/1.1.Zm9v"`
+}
@@ -0,0 +1,95 @@
+package table
+
+import (
+	"coffee/pkg/db"
+	"time"
+)
+
+type TableRepository struct {
+	Database *db.Db
+}
+
+func NewTableRepository(db *db.Db) *TableRepository {
+	return &TableRepository{
+		Database: db,
+	}
+}
+
+// Create сохраняет новый стол. Стол с тем же названием в том же месте —
+// ErrTableExists.
+func (repo *TableRepository) Create(table *Table) (*Table, error) {
+	result := repo.Database.DB.Create(table)
+	if db.IsUniqueViolation(result.Error) {
+		return nil, ErrTableExists
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return table, nil
+}
+
+// GetAll возвращает столы, отфильтрованные по месту, если оно задано.
+func (repo *TableRepository) GetAll(location string) ([]Table, error) {
+	var tables []Table
+	query := repo.Database.DB.Order("location, name")
+	if location != "" {
+		query = query.Where("location = ?", location)
+	}
+	result := query.Find(&tables)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tables, nil
+}
+
+func (repo *TableRepository) GetByID(id uint) (*Table, error) {
+	var table Table
+	result := repo.Database.DB.First(&table, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &table, nil
+}
+
+func (repo *TableRepository) Update(table *Table) (*Table, error) {
+	result := repo.Database.DB.Save(table)
+	if db.IsUniqueViolation(result.Error) {
+		return nil, ErrTableExists
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return table, nil
+}
+
+func (repo *TableRepository) Delete(id uint) error {
+	result := repo.Database.DB.Delete(&Table{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (repo *TableRepository) CreateSession(session *Session) (*Session, error) {
+	result := repo.Database.DB.Omit("Table").Create(session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return session, nil
+}
+
+// GetSession возвращает сессию вместе со столом.
+func (repo *TableRepository) GetSession(id string) (*Session, error) {
+	var session Session
+	result := repo.Database.DB.Preload("Table").Where("id = ?", id).First(&session)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &session, nil
+}
+
+// DeleteExpiredSessions удаляет сессии стола, истекшие до before.
+func (repo *TableRepository) DeleteExpiredSessions(tableID uint, before time.Time) (int64, error) {
+	result := repo.Database.DB.Where("table_id = ? AND expires_at < ?", tableID, before).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
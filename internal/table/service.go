@@ -0,0 +1,107 @@
+package table
+
+import (
+	"coffee/configs"
+	"coffee/pkg/signurl"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidLink    = errors.New("invalid table link")
+	ErrTableInactive  = errors.New("table is not active")
+	ErrSessionExpired = errors.New("table session has expired")
+	ErrTableExists    = errors.New("table already exists")
+)
+
+type TableService struct {
+	TableRepository *TableRepository
+	Config          *configs.Config
+	Signer          *signurl.Signer
+}
+
+func NewTableService(tableRepository *TableRepository, conf *configs.Config) *TableService {
+	return &TableService{
+		TableRepository: tableRepository,
+		Config:          conf,
+		Signer:          signurl.NewSigner(conf.Table.SigningSecret),
+	}
+}
+
+// Link — ссылка, которая кодируется в QR-коде стола. В подписанный
+// токен входят ID стола и версия, поэтому подделать номер стола нельзя.
+func (service *TableService) Link(table *Table) string {
+	token := service.Signer.Seal(fmt.Sprintf("%d.%d", table.ID, table.Version))
+	return service.Config.Qr.PublicBaseURL + "/t/" + url.PathEscape(token)
+}
+
+// MenuURL — страница меню, открытая в рамках сессии стола.
+func (service *TableService) MenuURL(session *Session) string {
+	return service.Config.Qr.PublicBaseURL + service.Config.Table.MenuPath +
+		"?" + url.Values{"session": {session.ID}}.Encode()
+}
+
+// OpenSession проверяет токен из QR-кода и открывает сессию меню.
+func (service *TableService) OpenSession(token, userAgent string) (*Session, error) {
+	payload, err := service.Signer.Open(token)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	id, version, ok := strings.Cut(payload, ".")
+	if !ok {
+		return nil, ErrInvalidLink
+	}
+	tableID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidLink
+	}
+	table, err := service.TableRepository.GetByID(uint(tableID))
+	if err != nil || strconv.Itoa(table.Version) != version {
+		return nil, ErrInvalidLink
+	}
+	if !table.Active {
+		return nil, ErrTableInactive
+	}
+
+	now := time.Now()
+	if _, err := service.TableRepository.DeleteExpiredSessions(table.ID, now); err != nil {
+		log.Printf("table: не удалось удалить истекшие сессии стола %d: %v", table.ID, err)
+	}
+	session := &Session{
+		ID:        uuid.NewString(),
+		ExpiresAt: now.Add(service.Config.Table.SessionTTL),
+		TableID:   table.ID,
+		Table:     *table,
+		UserAgent: userAgent,
+	}
+	return service.TableRepository.CreateSession(session)
+}
+
+// GetSession возвращает действующую сессию меню.
+func (service *TableService) GetSession(id string) (*Session, error) {
+	session, err := service.TableRepository.GetSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+	if !session.Table.Active {
+		return nil, ErrTableInactive
+	}
+	return session, nil
+}
+
+// Rotate увеличивает версию стола: ранее напечатанные QR-коды перестают
+// действовать.
+func (service *TableService) Rotate(table *Table) (*Table, error) {
+	table.Version++
+	return service.TableRepository.Update(table)
+}
@@ -1,10 +1,52 @@
 package user
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
 
 type User struct {
 	gorm.Model
 	Name     string `json:"name" example:"John" gorm:"size:50;not null"`
 	Email    string `json:"email" example:"<EMAIL>" gorm:"size:50;unique;index;not null"`
 	Password string `json:"password" example:"<PASSWORD>" gorm:"size:100;not null"`
+	Role     Role   `json:"role" example:"customer" gorm:"size:20;not null;default:customer"`
+	// EmailVerifiedAt — когда пользователь подтвердил email, nil — не подтвердил.
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// TOTPSecret — секрет приложения-аутентификатора. Пока TOTPEnabledAt
+	// пуст, секрет ждет подтверждения кодом. TOTPLastStep — интервал
+	// последнего принятого кода, чтобы один код нельзя было ввести дважды.
+	TOTPSecret    string     `json:"-" gorm:"size:64"`
+	TOTPEnabledAt *time.Time `json:"totpEnabledAt"`
+	TOTPLastStep  int64      `json:"-" gorm:"not null;default:0"`
+	// Avatar — имя файла аватара в static/images/avatars.
+	Avatar string `json:"avatar" example:"3f1c...e9.jpg" gorm:"size:100"`
+	// PendingEmail — новый email, который ждет подтверждения по ссылке из
+	// письма. До подтверждения вход идет по старому.
+	PendingEmail string `json:"-" gorm:"size:50"`
+	// DisabledAt — когда администратор отключил аккаунт, nil — активен.
+	DisabledAt *time.Time `json:"disabledAt"`
+}
+
+// NoPasswordPrefix начинает Password аккаунта без пароля: созданного при
+// входе через провайдера или сброшенного администратором. Это не хеш
+// bcrypt, поэтому войти по паролю в такой аккаунт нельзя.
+const NoPasswordPrefix = "!"
+
+// HasPassword сообщает, задан ли у аккаунта пароль.
+func (user *User) HasPassword() bool {
+	return !strings.HasPrefix(user.Password, NoPasswordPrefix)
+}
+
+func (user *User) Disabled() bool {
+	return user.DisabledAt != nil
+}
+
+func (user *User) TwoFactorEnabled() bool {
+	return user.TOTPEnabledAt != nil
+}
+
+func (user *User) EmailVerified() bool {
+	return user.EmailVerifiedAt != nil
 }
@@ -1,6 +1,11 @@
 package user
 
-import "coffee/pkg/db"
+import (
+	"coffee/pkg/db"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
 
 type UserRepository struct {
 	database *db.Db
@@ -12,6 +17,13 @@ func NewUserRepository(db *db.Db) *UserRepository {
 	}
 }
 
+// WithTx возвращает репозиторий, который работает внутри транзакции tx.
+func (repo *UserRepository) WithTx(tx *gorm.DB) *UserRepository {
+	return &UserRepository{
+		database: &db.Db{DB: tx},
+	}
+}
+
 func (repo *UserRepository) CreateUser(user *User) (*User, error) {
 	result := repo.database.DB.Create(user)
 	if result.Error != nil {
@@ -28,3 +40,137 @@ func (repo *UserRepository) GetByEmail(email string) (*User, error) {
 	}
 	return &user, nil
 }
+
+func (repo *UserRepository) SetRole(email string, role Role) error {
+	result := repo.database.DB.Model(&User{}).Where("email = ?", email).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+func (repo *UserRepository) GetByID(id uint) (*User, error) {
+	var user User
+	result := repo.database.DB.First(&user, id)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// UpdatePassword сохраняет новый bcrypt-хеш пароля.
+func (repo *UserRepository) UpdatePassword(id uint, hash string) error {
+	result := repo.database.DB.Model(&User{}).Where("id = ?", id).Update("password", hash)
+	return result.Error
+}
+
+// MarkEmailVerified отмечает email пользователя подтвержденным.
+func (repo *UserRepository) MarkEmailVerified(id uint) error {
+	result := repo.database.DB.Model(&User{}).
+		Where("id = ? AND email_verified_at IS NULL", id).
+		Update("email_verified_at", time.Now())
+	return result.Error
+}
+
+// SetTOTP сохраняет секрет TOTP. enabledAt nil означает, что секрет еще
+// не подтвержден; пустой secret отключает двухфакторный вход.
+func (repo *UserRepository) SetTOTP(id uint, secret string, enabledAt *time.Time) error {
+	result := repo.database.DB.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_enabled_at": enabledAt,
+		"totp_last_step":  0,
+	})
+	return result.Error
+}
+
+// UseTOTPStep запоминает интервал принятого кода. Возвращает false, если
+// код этого или более позднего интервала уже был принят.
+func (repo *UserRepository) UseTOTPStep(id uint, step int64) (bool, error) {
+	result := repo.database.DB.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (repo *UserRepository) UpdateName(id uint, name string) error {
+	result := repo.database.DB.Model(&User{}).Where("id = ?", id).Update("name", name)
+	return result.Error
+}
+
+// SetAvatar сохраняет имя файла аватара, пустое имя удаляет аватар.
+func (repo *UserRepository) SetAvatar(id uint, filename string) error {
+	result := repo.database.DB.Model(&User{}).Where("id = ?", id).Update("avatar", filename)
+	return result.Error
+}
+
+func (repo *UserRepository) SetPendingEmail(id uint, email string) error {
+	result := repo.database.DB.Model(&User{}).Where("id = ?", id).Update("pending_email", email)
+	return result.Error
+}
+
+// ChangeEmail заменяет email подтвержденным новым.
+func (repo *UserRepository) ChangeEmail(id uint, email string) error {
+	result := repo.database.DB.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"email":             email,
+		"email_verified_at": time.Now(),
+		"pending_email":     "",
+	})
+	return result.Error
+}
+
+// Delete удаляет пользователя окончательно, а не помечает удаленным:
+// email должен освободиться для новой регистрации.
+func (repo *UserRepository) Delete(id uint) error {
+	result := repo.database.DB.Unscoped().Delete(&User{}, id)
+	return result.Error
+}
+
+// SetDisabled отключает аккаунт (at — время отключения) или включает его
+// (at == nil).
+func (repo *UserRepository) SetDisabled(id uint, at *time.Time) error {
+	result := repo.database.DB.Model(&User{}).Where("id = ?", id).Update("disabled_at", at)
+	return result.Error
+}
+
+// UserFilter — условия поиска пользователей. Query ищет по подстроке в
+// имени и email, Status — "active" или "disabled".
+type UserFilter struct {
+	Query  string
+	Role   Role
+	Status string
+}
+
+// Search возвращает страницу пользователей по фильтру, новые первыми, и
+// общее число найденных.
+func (repo *UserRepository) Search(filter UserFilter, limit, offset int) ([]User, int64, error) {
+	query := repo.database.DB.Model(&User{})
+	if filter.Query != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(filter.Query) + "%"
+		query = query.Where("name ILIKE ? OR email ILIKE ?", pattern, pattern)
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	switch filter.Status {
+	case "active":
+		query = query.Where("disabled_at IS NULL")
+	case "disabled":
+		query = query.Where("disabled_at IS NOT NULL")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []User
+	err := query.Order("id DESC").Limit(limit).Offset(offset).Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
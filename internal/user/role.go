@@ -0,0 +1,70 @@
+package user
+
+import "slices"
+
+type Role string
+
+const (
+	RoleCustomer Role = "customer"
+	RoleBarista  Role = "barista"
+	RoleManager  Role = "manager"
+	RoleAdmin    Role = "admin"
+)
+
+// Права, которые проверяет middleware.RequirePermission. Попадают в
+// claims access-токена.
+const (
+	PermCoffeeWrite  = "coffee:write"
+	PermCoffeeDelete = "coffee:delete"
+	PermMediaUpload  = "media:upload"
+	PermLabelsPrint  = "labels:print"
+	PermTablesManage = "tables:manage"
+	PermScansRead    = "scans:read"
+	PermUsersManage  = "users:manage"
+	// PermUsersImpersonate — вход под другим пользователем для поддержки.
+	PermUsersImpersonate = "users:impersonate"
+)
+
+var rolePermissions = map[Role][]string{
+	RoleCustomer: {},
+	RoleBarista:  {PermLabelsPrint, PermScansRead},
+	RoleManager: {
+		PermCoffeeWrite, PermCoffeeDelete, PermMediaUpload,
+		PermLabelsPrint, PermTablesManage, PermScansRead, PermUsersManage,
+	},
+	RoleAdmin: {
+		PermCoffeeWrite, PermCoffeeDelete, PermMediaUpload,
+		PermLabelsPrint, PermTablesManage, PermScansRead, PermUsersManage,
+		PermUsersImpersonate,
+	},
+}
+
+// Roles возвращает все роли от младшей к старшей.
+func Roles() []Role {
+	return []Role{RoleCustomer, RoleBarista, RoleManager, RoleAdmin}
+}
+
+func (role Role) Valid() bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Permissions возвращает права роли. У неизвестной роли прав нет.
+func (role Role) Permissions() []string {
+	return slices.Clone(rolePermissions[role])
+}
+
+func (role Role) Can(permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// Rank — старшинство роли: индекс в Roles, -1 у неизвестной роли.
+func (role Role) Rank() int {
+	return slices.Index(Roles(), role)
+}
+
+// Outranks сообщает, что роль старше other. Управлять пользователями
+// можно только с ролью младше своей.
+func (role Role) Outranks(other Role) bool {
+	return role.Rank() > other.Rank()
+}
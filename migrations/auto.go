@@ -1,7 +1,13 @@
 package main
 
 import (
+	"coffee/internal/apikey"
+	"coffee/internal/audit"
+	"coffee/internal/auth"
 	"coffee/internal/coffee"
+	"coffee/internal/media"
+	"coffee/internal/scan"
+	"coffee/internal/table"
 	"coffee/internal/user"
 	"github.com/joho/godotenv"
 	"gorm.io/driver/postgres"
@@ -19,8 +25,19 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	err = db.AutoMigrate(&coffee.Coffee{}, &user.User{})
+	// Пользователи, зарегистрированные до подтверждения email, считаются
+	// подтвержденными, иначе они потеряют права при UNVERIFIED_LOGIN=limited.
+	backfillVerified := db.Migrator().HasTable(&user.User{}) &&
+		!db.Migrator().HasColumn(&user.User{}, "EmailVerifiedAt")
+	err = db.AutoMigrate(&coffee.Coffee{}, &coffee.CoffeeImage{}, &coffee.CoffeeImageAlt{}, &user.User{}, &auth.RefreshToken{}, &auth.Session{}, &auth.RevokedToken{}, &auth.ActionToken{}, &auth.LoginAttempt{}, &auth.RecoveryCode{}, &auth.Identity{}, &auth.OIDCState{}, &auth.ImpersonationToken{}, &apikey.APIKey{}, &audit.Event{}, &media.Blob{}, &media.Upload{}, &scan.Event{}, &table.Table{}, &table.Session{})
 	if err != nil {
 		return
 	}
+	if backfillVerified {
+		err = db.Model(&user.User{}).Where("email_verified_at IS NULL").
+			Update("email_verified_at", gorm.Expr("created_at")).Error
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -2,6 +2,8 @@ package db
 
 import (
 	"coffee/configs"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
@@ -18,3 +20,9 @@ func NewDb(conf *configs.Config) *Db {
 	}
 	return &Db{db}
 }
+
+// IsUniqueViolation сообщает, что запись нарушила уникальный индекс.
+func IsUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
@@ -1,125 +1,277 @@
 package jwt
 
 import (
+	"errors"
+	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"slices"
 	"time"
 )
 
 const (
 	AccessToken  string = "access"
 	RefreshToken string = "refresh"
+	// ChallengeToken — токен второго шага входа с двухфакторной
+	// аутентификацией, подписывается ключом access-токенов.
+	ChallengeToken string = "2fa"
 )
 
 type JWTData struct {
+	// ID — уникальный идентификатор токена (claim jti).
+	ID        string
 	Email     string
 	ExpiresAt time.Time
 	TokenType string
+	// Role и Permissions передаются только в access-токене.
+	Role        string
+	Permissions []string
+	// Actor — email администратора, если токен выпущен для входа под
+	// другим пользователем (claim act, RFC 8693).
+	Actor string
 }
 
+var (
+	// ErrInvalid — токен поврежден, подписан чужим ключом или алгоритмом,
+	// выпущен не нами или не для нас.
+	ErrInvalid     = errors.New("invalid token")
+	ErrExpired     = errors.New("token has expired")
+	ErrNotYetValid = errors.New("token is not valid yet")
+	// ErrWrongType — токен другого типа, например refresh вместо access.
+	ErrWrongType = errors.New("wrong token type")
+)
+
+// JWT выпускает и проверяет токены. Access и refresh подписываются
+// разными наборами ключей. Issuer и Audience записываются в iss и aud и
+// сверяются при проверке, если заданы. Без RequireIssuer токены без iss и
+// aud, выпущенные до их появления, принимаются; с чужими iss или aud
+// токены отклоняются всегда. ClockSkew — допустимое расхождение часов при
+// проверке exp, nbf и iat.
 type JWT struct {
-	AccessSecret  string
-	RefreshSecret string
+	Access        *KeySet
+	Refresh       *KeySet
+	Issuer        string
+	Audience      string
+	RequireIssuer bool
+	ClockSkew     time.Duration
 }
 
 type TokenPair struct {
 	AccessToken  string
 	RefreshToken string
+	// AccessTokenID и AccessExpiresAt нужны, чтобы отозвать access token
+	// при выходе.
+	AccessTokenID   string
+	AccessExpiresAt time.Time
 }
 
-func NewJWT(accessSecret, refreshSecret string) *JWT {
+func NewJWT(access, refresh *KeySet, issuer, audience string, requireIssuer bool, clockSkew time.Duration) *JWT {
 	return &JWT{
-		AccessSecret:  accessSecret,
-		RefreshSecret: refreshSecret,
+		Access:        access,
+		Refresh:       refresh,
+		Issuer:        issuer,
+		Audience:      audience,
+		RequireIssuer: requireIssuer,
+		ClockSkew:     clockSkew,
 	}
 }
 
-func (j *JWT) CreateTokenPair(email string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
+// CreateTokenPair выпускает пару токенов. Роль и права из data попадают
+// только в access token, refresh token несет лишь email.
+func (j *JWT) CreateTokenPair(data JWTData, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
 	// Создаем access token
-	accessToken, err := j.Create(JWTData{
-		Email:     email,
-		ExpiresAt: time.Now().Add(accessTTL),
-		TokenType: AccessToken,
-	}, j.AccessSecret)
+	access := JWTData{
+		ID:          uuid.NewString(),
+		Email:       data.Email,
+		ExpiresAt:   time.Now().Add(accessTTL),
+		TokenType:   AccessToken,
+		Role:        data.Role,
+		Permissions: data.Permissions,
+	}
+	accessToken, err := j.Create(access, j.Access)
 	if err != nil {
 		return nil, err
 	}
 
 	// Создаем refresh token
 	refreshToken, err := j.Create(JWTData{
-		Email:     email,
+		ID:        uuid.NewString(),
+		Email:     data.Email,
 		ExpiresAt: time.Now().Add(refreshTTL),
 		TokenType: RefreshToken,
-	}, j.RefreshSecret)
+	}, j.Refresh)
 	if err != nil {
 		return nil, err
 	}
 
 	return &TokenPair{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
+		AccessToken:     accessToken,
+		RefreshToken:    refreshToken,
+		AccessTokenID:   access.ID,
+		AccessExpiresAt: access.ExpiresAt,
 	}, nil
 }
-func (j *JWT) Create(data JWTData, secret string) (string, error) {
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+
+// Create подписывает токен текущим ключом набора keys и указывает его kid
+// в заголовке.
+func (j *JWT) Create(data JWTData, keys *KeySet) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
 		"email": data.Email,
 		"exp":   data.ExpiresAt.Unix(),
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
 		"type":  data.TokenType,
-	})
-	return t.SignedString([]byte(secret))
+	}
+	if j.Issuer != "" {
+		claims["iss"] = j.Issuer
+	}
+	if j.Audience != "" {
+		claims["aud"] = j.Audience
+	}
+	if data.ID != "" {
+		claims["jti"] = data.ID
+	}
+	if data.Role != "" {
+		claims["role"] = data.Role
+	}
+	if data.Permissions != nil {
+		claims["perms"] = data.Permissions
+	}
+	if data.Actor != "" {
+		claims["act"] = map[string]string{"sub": data.Actor}
+	}
+	key := keys.signingKey()
+	t := jwt.NewWithClaims(key.method(), claims)
+	if key.ID != "" {
+		t.Header["kid"] = key.ID
+	}
+	return t.SignedString(key.private)
 }
 
-func (j *JWT) parse(token string, secret string) (bool, *JWTData) {
+// parse проверяет подпись и claims токена и ожидает тип tokenType.
+func (j *JWT) parse(token string, keys *KeySet, tokenType string) (*JWTData, error) {
 	t, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-	if err != nil {
-		return false, nil
+		kid, _ := t.Header["kid"].(string)
+		set := jwt.VerificationKeySet{}
+		for _, key := range keys.verificationKeys(kid, time.Now()) {
+			if key.method().Alg() == t.Method.Alg() {
+				set.Keys = append(set.Keys, key.public)
+			}
+		}
+		if len(set.Keys) == 0 {
+			return nil, ErrUnknownKey
+		}
+		return set, nil
+	}, j.parserOptions(keys)...)
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, ErrExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return nil, ErrNotYetValid
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrInvalid, err)
 	}
 
 	claims, ok := t.Claims.(jwt.MapClaims)
 	if !ok {
-		return false, nil
+		return nil, ErrInvalid
+	}
+	if !j.RequireIssuer {
+		if err := j.checkLegacyClaims(claims); err != nil {
+			return nil, err
+		}
 	}
 
 	email, ok := claims["email"].(string)
-	if !ok {
-		return false, nil
+	if !ok || email == "" {
+		return nil, fmt.Errorf("%w: email claim is missing", ErrInvalid)
 	}
 
-	exp, ok := claims["exp"].(float64)
-	if !ok {
-		return false, nil
+	exp, err := claims.GetExpirationTime()
+	if err != nil || exp == nil {
+		return nil, fmt.Errorf("%w: exp claim is missing", ErrInvalid)
 	}
 
-	tokenType, ok := claims["type"].(string)
-	if !ok {
-		return false, nil
+	if claimType, _ := claims["type"].(string); claimType != tokenType {
+		return nil, ErrWrongType
 	}
 
-	return t.Valid, &JWTData{
-		Email:     email,
-		ExpiresAt: time.Unix(int64(exp), 0),
-		TokenType: tokenType,
+	id, _ := claims["jti"].(string)
+	role, _ := claims["role"].(string)
+	var permissions []string
+	if perms, ok := claims["perms"].([]interface{}); ok {
+		for _, perm := range perms {
+			if perm, ok := perm.(string); ok {
+				permissions = append(permissions, perm)
+			}
+		}
 	}
-}
 
-func (j *JWT) ParseAccessToken(token string) (bool, *JWTData) {
-	return j.parse(token, j.AccessSecret)
-}
+	var actor string
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		actor, _ = act["sub"].(string)
+	}
 
-func (j *JWT) ParseRefreshToken(token string) (bool, *JWTData) {
-	return j.parse(token, j.RefreshSecret)
+	return &JWTData{
+		ID:          id,
+		Email:       email,
+		ExpiresAt:   exp.Time,
+		TokenType:   tokenType,
+		Role:        role,
+		Permissions: permissions,
+		Actor:       actor,
+	}, nil
 }
 
-func (j *JWT) RefreshTokens(refreshToken string, accessTTL, refreshTTL time.Duration) (*TokenPair, error) {
-	valid, data := j.ParseRefreshToken(refreshToken)
-	if !valid || data == nil {
-		return nil, jwt.ErrSignatureInvalid
+// parserOptions — обязательные проверки: только алгоритмы ключей набора,
+// наличие exp и iat, iss и aud, если они заданы и RequireIssuer.
+func (j *JWT) parserOptions(keys *KeySet) []jwt.ParserOption {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(keys.algorithms()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(j.ClockSkew),
+	}
+	if !j.RequireIssuer {
+		return options
+	}
+	if j.Issuer != "" {
+		options = append(options, jwt.WithIssuer(j.Issuer))
 	}
+	if j.Audience != "" {
+		options = append(options, jwt.WithAudience(j.Audience))
+	}
+	return options
+}
 
-	if data.TokenType != RefreshToken {
-		return nil, jwt.ErrTokenInvalidClaims
+// checkLegacyClaims сверяет iss и aud, только если они есть в токене.
+func (j *JWT) checkLegacyClaims(claims jwt.MapClaims) error {
+	issuer, err := claims.GetIssuer()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if j.Issuer != "" && issuer != "" && issuer != j.Issuer {
+		return fmt.Errorf("%w: %w", ErrInvalid, jwt.ErrTokenInvalidIssuer)
 	}
+	audience, err := claims.GetAudience()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if j.Audience != "" && len(audience) > 0 && !slices.Contains(audience, j.Audience) {
+		return fmt.Errorf("%w: %w", ErrInvalid, jwt.ErrTokenInvalidAudience)
+	}
+	return nil
+}
+
+func (j *JWT) ParseAccessToken(token string) (*JWTData, error) {
+	return j.parse(token, j.Access, AccessToken)
+}
+
+func (j *JWT) ParseRefreshToken(token string) (*JWTData, error) {
+	return j.parse(token, j.Refresh, RefreshToken)
+}
 
-	return j.CreateTokenPair(data.Email, accessTTL, refreshTTL)
+func (j *JWT) ParseChallengeToken(token string) (*JWTData, error) {
+	return j.parse(token, j.Access, ChallengeToken)
 }
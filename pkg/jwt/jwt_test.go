@@ -0,0 +1,245 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func newTestJWT(t *testing.T, issuer, audience string, requireIssuer bool) *JWT {
+	t.Helper()
+	access, err := NewKeySet(NewHMACKey("a1", []byte("access")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := NewKeySet(NewHMACKey("r1", []byte("refresh")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewJWT(access, refresh, issuer, audience, requireIssuer, 0)
+}
+
+func TestParseIssuerAndAudience(t *testing.T) {
+	legacy := newTestJWT(t, "", "", false)
+	current := newTestJWT(t, "coffee", "coffee-api", false)
+	foreignIssuer := newTestJWT(t, "other", "coffee-api", false)
+	foreignAudience := newTestJWT(t, "coffee", "other-api", false)
+
+	tests := []struct {
+		name          string
+		issuer        *JWT
+		requireIssuer bool
+		wantErr       error
+	}{
+		{"current token", current, false, nil},
+		{"current token, required", current, true, nil},
+		{"legacy token", legacy, false, nil},
+		{"legacy token, required", legacy, true, ErrInvalid},
+		{"foreign issuer", foreignIssuer, false, ErrInvalid},
+		{"foreign issuer, required", foreignIssuer, true, ErrInvalid},
+		{"foreign audience", foreignAudience, false, ErrInvalid},
+		{"foreign audience, required", foreignAudience, true, ErrInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pair, err := test.issuer.CreateTokenPair(JWTData{Email: "user@example.com"}, time.Minute, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier := newTestJWT(t, "coffee", "coffee-api", test.requireIssuer)
+			_, accessErr := verifier.ParseAccessToken(pair.AccessToken)
+			_, refreshErr := verifier.ParseRefreshToken(pair.RefreshToken)
+			for _, err := range []error{accessErr, refreshErr} {
+				if test.wantErr == nil && err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+					t.Errorf("error = %v, want %v", err, test.wantErr)
+				}
+			}
+		})
+	}
+}
+
+// signClaims подписывает произвольные claims ключом key, минуя Create.
+func signClaims(t *testing.T, key *Key, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// accessClaims — claims access-токена, выпущенного в момент issued.
+func accessClaims(issued time.Time, ttl time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{
+		"email": "user@example.com",
+		"exp":   issued.Add(ttl).Unix(),
+		"iat":   issued.Unix(),
+		"nbf":   issued.Unix(),
+		"type":  AccessToken,
+	}
+}
+
+func TestParseWrongType(t *testing.T) {
+	j := newTestJWT(t, "", "", false)
+	pair, err := j.CreateTokenPair(JWTData{Email: "user@example.com"}, time.Minute, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	create := func(tokenType string, keys *KeySet) string {
+		token, err := j.Create(JWTData{Email: "user@example.com", ExpiresAt: time.Now().Add(time.Minute), TokenType: tokenType}, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		parse   func(string) (*JWTData, error)
+		token   string
+		wantErr error
+	}{
+		{"access as access", j.ParseAccessToken, pair.AccessToken, nil},
+		{"refresh as access", j.ParseAccessToken, pair.RefreshToken, ErrInvalid},
+		{"refresh type signed by access key", j.ParseAccessToken, create(RefreshToken, j.Access), ErrWrongType},
+		{"challenge as access", j.ParseAccessToken, create(ChallengeToken, j.Access), ErrWrongType},
+		{"access as challenge", j.ParseChallengeToken, pair.AccessToken, ErrWrongType},
+		{"access as refresh", j.ParseRefreshToken, pair.AccessToken, ErrInvalid},
+		{"access type signed by refresh key", j.ParseRefreshToken, create(AccessToken, j.Refresh), ErrWrongType},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := test.parse(test.token)
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseAlgorithmMismatch(t *testing.T) {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublic, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey := &Key{ID: "a1", Algorithm: RS256, private: rsaPrivate, public: &rsaPrivate.PublicKey}
+	edKey := &Key{ID: "a1", Algorithm: EdDSA, private: edPrivate, public: edPublic}
+	rsaPublicDER, err := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicDER, err := x509.MarshalPKIXPublicKey(edPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := accessClaims(now, time.Minute)
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		keys    *Key
+		token   string
+		wantErr error
+	}{
+		{"RS256 token, RS256 keys", rsaKey, signClaims(t, rsaKey, claims), nil},
+		{"EdDSA token, EdDSA keys", edKey, signClaims(t, edKey, claims), nil},
+		// Подмена алгоритма: открытый ключ используется как секрет HS256.
+		{"HS256 token, RS256 keys", rsaKey, signClaims(t, NewHMACKey("a1", rsaPublicDER), claims), ErrInvalid},
+		{"HS256 token, EdDSA keys", edKey, signClaims(t, NewHMACKey("a1", edPublicDER), claims), ErrInvalid},
+		{"EdDSA token, RS256 keys", rsaKey, signClaims(t, edKey, claims), ErrInvalid},
+		{"RS256 token, HS256 keys", NewHMACKey("a1", []byte("access")), signClaims(t, rsaKey, claims), ErrInvalid},
+		{"alg none, RS256 keys", rsaKey, unsigned, ErrInvalid},
+		{"alg none, HS256 keys", NewHMACKey("a1", []byte("access")), unsigned, ErrInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			access, err := NewKeySet(test.keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j := NewJWT(access, access, "", "", false, 0)
+			_, err = j.ParseAccessToken(test.token)
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("error = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTimeClaims(t *testing.T) {
+	const skew = time.Minute
+	key := NewHMACKey("a1", []byte("access"))
+	foreign := NewHMACKey("a1", []byte("foreign"))
+	now := time.Now()
+	with := func(claims jwt.MapClaims, name string, value time.Time) jwt.MapClaims {
+		claims[name] = value.Unix()
+		return claims
+	}
+	without := func(claims jwt.MapClaims, name string) jwt.MapClaims {
+		delete(claims, name)
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		key     *Key
+		claims  jwt.MapClaims
+		wantErr error
+	}{
+		{"valid", key, accessClaims(now, time.Minute), nil},
+		{"nbf ahead within skew", key, with(accessClaims(now, time.Minute), "nbf", now.Add(skew/2)), nil},
+		{"nbf ahead beyond skew", key, with(accessClaims(now, time.Minute), "nbf", now.Add(2*skew)), ErrNotYetValid},
+		{"iat ahead within skew", key, with(accessClaims(now, time.Minute), "iat", now.Add(skew/2)), nil},
+		{"iat ahead beyond skew", key, with(accessClaims(now, time.Minute), "iat", now.Add(2*skew)), ErrNotYetValid},
+		{"expired within skew", key, accessClaims(now.Add(-time.Minute-skew/2), time.Minute), nil},
+		{"expired beyond skew", key, accessClaims(now.Add(-time.Hour), time.Minute), ErrExpired},
+		// Подпись проверяется раньше срока: чужой просроченный токен —
+		// поддельный, а не просроченный.
+		{"expired, foreign key", foreign, accessClaims(now.Add(-time.Hour), time.Minute), ErrInvalid},
+		{"without exp", key, without(accessClaims(now, time.Minute), "exp"), ErrInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			access, err := NewKeySet(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			j := NewJWT(access, access, "", "", false, skew)
+			_, err = j.ParseAccessToken(signClaims(t, test.key, test.claims))
+			if test.wantErr == nil && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("error = %v, want %v", err, test.wantErr)
+			}
+			if test.wantErr != nil && test.wantErr != ErrInvalid && errors.Is(err, ErrInvalid) {
+				t.Errorf("error = %v, must not be %v", err, ErrInvalid)
+			}
+		})
+	}
+}
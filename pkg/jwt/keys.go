@@ -0,0 +1,293 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Поддерживаемые алгоритмы подписи.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown signing algorithm")
+	ErrNoSigningKey     = errors.New("no signing key")
+	ErrUnknownKey       = errors.New("unknown key id")
+)
+
+// Key — ключ подписи с идентификатором kid. Ключ, заданный только
+// открытой частью, годится лишь для проверки. Until — до какого момента
+// ключ принимается после ротации; нулевое значение — без ограничения.
+type Key struct {
+	ID        string
+	Algorithm string
+	Until     time.Time
+	private   crypto.PrivateKey
+	public    crypto.PublicKey
+}
+
+// NewHMACKey возвращает ключ HS256.
+func NewHMACKey(id string, secret []byte) *Key {
+	return &Key{
+		ID:        id,
+		Algorithm: HS256,
+		private:   secret,
+		public:    secret,
+	}
+}
+
+// ParsePEMKey читает ключ RS256 или EdDSA из PEM: закрытый ключ (PKCS#8 или
+// PKCS#1) или открытый (PKIX) для ключей, которыми только проверяют.
+func ParsePEMKey(id, algorithm string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("ключ %s: не найден PEM", id)
+	}
+	key := &Key{ID: id, Algorithm: algorithm}
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key.public, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ключ %s: %w", id, err)
+	}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.private, key.public = private, &private.PublicKey
+	case ed25519.PrivateKey:
+		key.private, key.public = private, private.Public()
+	}
+	switch key.public.(type) {
+	case *rsa.PublicKey:
+		if algorithm != RS256 {
+			return nil, fmt.Errorf("ключ %s: RSA-ключ для %s", id, algorithm)
+		}
+	case ed25519.PublicKey:
+		if algorithm != EdDSA {
+			return nil, fmt.Errorf("ключ %s: Ed25519-ключ для %s", id, algorithm)
+		}
+	default:
+		return nil, fmt.Errorf("ключ %s: неподдерживаемый тип ключа", id)
+	}
+	return key, nil
+}
+
+func (key *Key) method() jwt.SigningMethod {
+	switch key.Algorithm {
+	case RS256:
+		return jwt.SigningMethodRS256
+	case EdDSA:
+		return jwt.SigningMethodEdDSA
+	default:
+		return jwt.SigningMethodHS256
+	}
+}
+
+// active — принимается ли ключ в момент now.
+func (key *Key) active(now time.Time) bool {
+	return key.Until.IsZero() || now.Before(key.Until)
+}
+
+// KeySet — ключи одного вида токенов. Первым ключом подписываются новые
+// токены, остальные нужны для проверки токенов, выпущенных до ротации.
+type KeySet struct {
+	keys []*Key
+}
+
+func NewKeySet(keys ...*Key) (*KeySet, error) {
+	if len(keys) == 0 || keys[0].private == nil {
+		return nil, ErrNoSigningKey
+	}
+	return &KeySet{keys: keys}, nil
+}
+
+// ParseKeySet разбирает список ключей вида "kid=[alg:]значение[@срок]"
+// через запятую. alg (HS256, RS256 или EdDSA) задает алгоритм ключа, без
+// него используется algorithm — так при переходе на другой алгоритм можно
+// оставить старый ключ. Для HS256 значение — сам секрет, для RS256 и EdDSA —
+// путь к PEM-файлу. Срок (2006-01-02 или RFC 3339) ограничивает прием
+// старого ключа после ротации.
+func ParseKeySet(algorithm, spec string) (*KeySet, error) {
+	if !knownAlgorithm(algorithm) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+	}
+	var keys []*Key
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, value, ok := strings.Cut(item, "=")
+		if !ok || id == "" || value == "" {
+			return nil, fmt.Errorf("ключ %q: ожидается kid=значение", item)
+		}
+		keyAlgorithm := algorithm
+		if alg, rest, ok := strings.Cut(value, ":"); ok && knownAlgorithm(alg) {
+			keyAlgorithm, value = alg, rest
+		}
+		var until time.Time
+		if i := strings.LastIndexByte(value, '@'); i >= 0 {
+			if t, err := parseUntil(value[i+1:]); err == nil {
+				value, until = value[:i], t
+			}
+		}
+		if value == "" {
+			return nil, fmt.Errorf("ключ %s: пустое значение", id)
+		}
+		var key *Key
+		if keyAlgorithm == HS256 {
+			key = NewHMACKey(id, []byte(value))
+		} else {
+			data, err := os.ReadFile(value)
+			if err != nil {
+				return nil, fmt.Errorf("ключ %s: %w", id, err)
+			}
+			if key, err = ParsePEMKey(id, keyAlgorithm, data); err != nil {
+				return nil, err
+			}
+		}
+		key.Until = until
+		keys = append(keys, key)
+	}
+	return NewKeySet(keys...)
+}
+
+func knownAlgorithm(algorithm string) bool {
+	return algorithm == HS256 || algorithm == RS256 || algorithm == EdDSA
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// algorithms — алгоритмы ключей набора; токены с другим alg отклоняются.
+func (set *KeySet) algorithms() []string {
+	var algorithms []string
+	for _, key := range set.keys {
+		if alg := key.method().Alg(); !slices.Contains(algorithms, alg) {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	return algorithms
+}
+
+// Shares сообщает, есть ли у наборов общий ключ. Access- и
+// refresh-токены, подписанные одним ключом, отличались бы только claim
+// typ.
+func (set *KeySet) Shares(other *KeySet) bool {
+	for _, key := range set.keys {
+		for _, otherKey := range other.keys {
+			if key.Algorithm != otherKey.Algorithm {
+				continue
+			}
+			switch public := key.public.(type) {
+			case []byte:
+				if secret, ok := otherKey.public.([]byte); ok && bytes.Equal(public, secret) {
+					return true
+				}
+			case interface{ Equal(crypto.PublicKey) bool }:
+				if public.Equal(otherKey.public) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// HasSecret сообщает, есть ли в наборе ключ HS256 с секретом secret,
+// в том числе ключ, оставленный для проверки после ротации.
+func (set *KeySet) HasSecret(secret []byte) bool {
+	for _, key := range set.keys {
+		if public, ok := key.public.([]byte); ok && bytes.Equal(public, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+func (set *KeySet) signingKey() *Key {
+	return set.keys[0]
+}
+
+// verificationKeys — ключи, которыми можно проверить токен с заголовком
+// kid. Токены без kid, выпущенные до появления ротации, проверяются всеми
+// действующими ключами.
+func (set *KeySet) verificationKeys(kid string, now time.Time) []*Key {
+	var keys []*Key
+	for _, key := range set.keys {
+		if (kid == "" || key.ID == kid) && key.active(now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// JWK — открытый ключ в формате RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает открытые части действующих асимметричных ключей.
+// Секреты HS256 не публикуются.
+func (set *KeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range set.keys {
+		if !key.active(now) {
+			continue
+		}
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,291 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM сохраняет закрытый ключ в PKCS#8 во временный файл.
+func writePEM(t *testing.T, name string, private any) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newEd25519(t *testing.T) (ed25519.PublicKey, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return public, writePEM(t, "ed25519.pem", private)
+}
+
+func keyIDs(keys []*Key) []string {
+	ids := []string{}
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+func TestParseKeySetHS256(t *testing.T) {
+	set, err := ParseKeySet(HS256, " k2=new_secret , k1=old@secret@2026-11-01,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(set.keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(set.keys))
+	}
+	signing := set.signingKey()
+	if signing.ID != "k2" || string(signing.private.([]byte)) != "new_secret" || !signing.Until.IsZero() {
+		t.Errorf("signing key = %+v", signing)
+	}
+	old := set.keys[1]
+	if string(old.private.([]byte)) != "old@secret" {
+		t.Errorf("old secret = %q, want %q", old.private, "old@secret")
+	}
+	if want := time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC); !old.Until.Equal(want) {
+		t.Errorf("old.Until = %v, want %v", old.Until, want)
+	}
+}
+
+func TestParseKeySetAtWithoutDate(t *testing.T) {
+	set, err := ParseKeySet(HS256, "k1=secret@example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := set.keys[0]; string(key.private.([]byte)) != "secret@example" || !key.Until.IsZero() {
+		t.Errorf("key = %q until %v", key.private, key.Until)
+	}
+}
+
+func TestParseKeySetPerKeyAlgorithm(t *testing.T) {
+	_, path := newEd25519(t)
+	set, err := ParseKeySet(EdDSA, "a2="+path+",a1=HS256:legacy@2026-11-01T12:00:00Z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := set.signingKey().Algorithm; got != EdDSA {
+		t.Errorf("signing algorithm = %s, want %s", got, EdDSA)
+	}
+	legacy := set.keys[1]
+	if legacy.Algorithm != HS256 || string(legacy.private.([]byte)) != "legacy" {
+		t.Errorf("legacy key = %s %q", legacy.Algorithm, legacy.private)
+	}
+	if want := time.Date(2026, 11, 1, 12, 0, 0, 0, time.UTC); !legacy.Until.Equal(want) {
+		t.Errorf("legacy.Until = %v, want %v", legacy.Until, want)
+	}
+	if got := set.algorithms(); len(got) != 2 || got[0] != EdDSA || got[1] != HS256 {
+		t.Errorf("algorithms = %v", got)
+	}
+}
+
+func TestParseKeySetUnknownPrefixIsSecret(t *testing.T) {
+	set, err := ParseKeySet(HS256, "k1=ES256:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := set.keys[0]; key.Algorithm != HS256 || string(key.private.([]byte)) != "ES256:secret" {
+		t.Errorf("key = %s %q", key.Algorithm, key.private)
+	}
+}
+
+func TestParseKeySetErrors(t *testing.T) {
+	_, path := newEd25519(t)
+	tests := []struct {
+		name      string
+		algorithm string
+		spec      string
+	}{
+		{"unknown algorithm", "ES256", "k1=secret"},
+		{"empty spec", HS256, " , "},
+		{"missing value", HS256, "k1="},
+		{"missing kid", HS256, "=secret"},
+		{"no separator", HS256, "secret"},
+		{"empty after prefix", HS256, "k1=HS256:"},
+		{"missing file", EdDSA, "k1=" + filepath.Join(t.TempDir(), "missing.pem")},
+		{"algorithm mismatch", RS256, "k1=" + path},
+		{"verify-only signing key", HS256, "k1=EdDSA:" + publicPEM(t)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseKeySet(test.algorithm, test.spec); err == nil {
+				t.Errorf("ParseKeySet(%q, %q) succeeded", test.algorithm, test.spec)
+			}
+		})
+	}
+	if _, err := ParseKeySet("ES256", "k1=secret"); !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("unknown algorithm error = %v", err)
+	}
+	if _, err := ParseKeySet(HS256, ""); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("empty spec error = %v", err)
+	}
+}
+
+// publicPEM сохраняет открытый ключ Ed25519, годный только для проверки.
+func publicPEM(t *testing.T) string {
+	t.Helper()
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "public.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestVerificationKeys(t *testing.T) {
+	now := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	current := NewHMACKey("k3", []byte("three"))
+	rotated := NewHMACKey("k2", []byte("two"))
+	rotated.Until = now.Add(time.Hour)
+	expired := NewHMACKey("k1", []byte("one"))
+	expired.Until = now.Add(-time.Hour)
+	set, err := NewKeySet(current, rotated, expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		kid  string
+		want []string
+	}{
+		{"k3", []string{"k3"}},
+		{"k2", []string{"k2"}},
+		{"k1", []string{}},
+		{"unknown", []string{}},
+		{"", []string{"k3", "k2"}},
+	}
+	for _, test := range tests {
+		got := keyIDs(set.verificationKeys(test.kid, now))
+		if len(got) != len(test.want) {
+			t.Errorf("verificationKeys(%q) = %v, want %v", test.kid, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("verificationKeys(%q) = %v, want %v", test.kid, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	edPublic, edPath := newEd25519(t)
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPath := writePEM(t, "rsa.pem", rsaPrivate)
+	spec := "ed=" + edPath + ",rsa=RS256:" + rsaPath + ",hs=HS256:secret,old=RS256:" + rsaPath + "@2000-01-01"
+	set, err := ParseKeySet(EdDSA, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2 (no HS256, no expired): %+v", len(jwks.Keys), jwks.Keys)
+	}
+	ed, rsaKey := jwks.Keys[0], jwks.Keys[1]
+	if ed.KeyID != "ed" || ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.Algorithm != EdDSA || ed.Use != "sig" {
+		t.Errorf("Ed25519 JWK = %+v", ed)
+	}
+	if x, _ := base64.RawURLEncoding.DecodeString(ed.X); !edPublic.Equal(ed25519.PublicKey(x)) {
+		t.Errorf("Ed25519 x does not match the key")
+	}
+	if rsaKey.KeyID != "rsa" || rsaKey.KeyType != "RSA" || rsaKey.Algorithm != RS256 {
+		t.Errorf("RSA JWK = %+v", rsaKey)
+	}
+	if n, _ := base64.RawURLEncoding.DecodeString(rsaKey.N); string(n) != string(rsaPrivate.N.Bytes()) {
+		t.Errorf("RSA n does not match the key")
+	}
+	if rsaKey.E != "AQAB" {
+		t.Errorf("RSA e = %q, want AQAB", rsaKey.E)
+	}
+}
+
+func TestJWKSOnlyHMAC(t *testing.T) {
+	set, err := NewKeySet(NewHMACKey("", []byte("secret")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jwks := set.JWKS(); jwks.Keys == nil || len(jwks.Keys) != 0 {
+		t.Errorf("JWKS = %+v, want empty list", jwks)
+	}
+}
+
+func TestShares(t *testing.T) {
+	_, edPath := newEd25519(t)
+	access, err := ParseKeySet(HS256, "a2=access,a1=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refresh, err := ParseKeySet(HS256, "r1=refresh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.Shares(refresh) {
+		t.Error("distinct secrets reported as shared")
+	}
+	overlapping, err := ParseKeySet(HS256, "r2=refresh,r1=shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !access.Shares(overlapping) {
+		t.Error("shared HS256 secret not detected")
+	}
+	edAccess, err := ParseKeySet(EdDSA, "a1="+edPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edRefresh, err := ParseKeySet(EdDSA, "r1="+edPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !edAccess.Shares(edRefresh) {
+		t.Error("shared Ed25519 key not detected")
+	}
+	if edAccess.Shares(access) {
+		t.Error("keys of different algorithms reported as shared")
+	}
+}
+
+func TestHasSecret(t *testing.T) {
+	_, edPath := newEd25519(t)
+	set, err := ParseKeySet(EdDSA, "a2="+edPath+",a1=HS256:old@2026-11-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !set.HasSecret([]byte("old")) {
+		t.Error("secret of a rotated HS256 key not found")
+	}
+	if set.HasSecret([]byte("new")) {
+		t.Error("unknown secret reported as present")
+	}
+}
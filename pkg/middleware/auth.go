@@ -4,36 +4,191 @@ import (
 	"coffee/configs"
 	"coffee/pkg/jwt"
 	"context"
+	"errors"
+	"log"
 	"net/http"
+	"slices"
 	"strings"
 )
 
 type key string
 
 const (
-	ContextEmailKey key = "ContextEmailKey"
+	ContextEmailKey       key = "ContextEmailKey"
+	ContextRoleKey        key = "ContextRoleKey"
+	ContextPermissionsKey key = "ContextPermissionsKey"
+	// ContextClaimsKey — *jwt.JWTData access-токена запроса.
+	ContextClaimsKey key = "ContextClaimsKey"
+	// ContextAPIKeyIDKey — ID API-ключа, если запрос пришел с ним.
+	ContextAPIKeyIDKey key = "ContextAPIKeyIDKey"
 )
 
+// APIKeyPrefix — с него начинаются API-ключи. Ключ передается в заголовке
+// X-API-Key или вместо JWT в Authorization: Bearer.
+const APIKeyPrefix = "ck_"
+
+// APIKeyIdentity — владелец API-ключа и права, которые ключ дает.
+type APIKeyIdentity struct {
+	KeyID       uint
+	Email       string
+	Role        string
+	Permissions []string
+}
+
+// APIKeyVerifier проверяет API-ключ.
+type APIKeyVerifier interface {
+	VerifyAPIKey(key string) (*APIKeyIdentity, error)
+}
+
+// RevocationChecker сообщает, отозван ли токен с данным jti.
+type RevocationChecker interface {
+	IsRevoked(jti string) bool
+}
+
+// ImpersonationRecorder пишет в журнал запрос, сделанный с токеном входа
+// под пользователем.
+type ImpersonationRecorder interface {
+	RecordImpersonation(claims *jwt.JWTData, r *http.Request) error
+}
+
+// Auth — зависимости IsAuthed и RequirePermission, собирается в app.go и
+// передается обработчикам через Deps. APIKeys включает вход по API-ключам.
+// Без Revocations проверяются только подпись и срок действия токена.
+// Impersonation пишет в журнал запросы под пользователем: если записать
+// запрос не удалось, IsAuthed его не пропускает.
+type Auth struct {
+	Config        *configs.Config
+	APIKeys       APIKeyVerifier
+	Revocations   RevocationChecker
+	Impersonation ImpersonationRecorder
+}
+
 func writeUnauthed(w http.ResponseWriter) {
 	w.WriteHeader(http.StatusUnauthorized)
 	w.Write([]byte(http.StatusText(http.StatusUnauthorized)))
 }
 
-func IsAuthed(next http.Handler, config *configs.Config) http.Handler {
+// writeInvalidToken отвечает 401 с причиной в WWW-Authenticate (RFC 6750),
+// чтобы клиент отличал истекший токен, который нужно обновить, от
+// недействительного.
+func writeInvalidToken(w http.ResponseWriter, err error) {
+	description := "invalid token"
+	switch {
+	case errors.Is(err, jwt.ErrExpired):
+		description = "token expired"
+	case errors.Is(err, jwt.ErrNotYetValid):
+		description = "token not valid yet"
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token", error_description="`+description+`"`)
+	http.Error(w, description, http.StatusUnauthorized)
+}
+
+func IsAuthed(next http.Handler, auth *Auth) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := apiKey(r); key != "" && auth.APIKeys != nil {
+			identity, err := auth.APIKeys.VerifyAPIKey(key)
+			if err != nil {
+				writeUnauthed(w)
+				return
+			}
+			ctx := context.WithValue(r.Context(), ContextEmailKey, identity.Email)
+			ctx = context.WithValue(ctx, ContextRoleKey, identity.Role)
+			ctx = context.WithValue(ctx, ContextPermissionsKey, identity.Permissions)
+			ctx = context.WithValue(ctx, ContextAPIKeyIDKey, identity.KeyID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
 		authedHeader := r.Header.Get("Authorization")
 		if !strings.HasPrefix(authedHeader, "Bearer ") {
 			writeUnauthed(w)
 			return
 		}
 		token := strings.TrimPrefix(authedHeader, "Bearer ")
-		isValid, data := jwt.NewJWT(config.Auth.AccessSecret, config.Auth.RefreshSecret).ParseAccessToken(token)
-		if !isValid {
+		conf := auth.Config.Auth
+		data, err := jwt.NewJWT(conf.AccessKeys, conf.RefreshKeys, conf.Issuer, conf.Audience, conf.RequireIssuer, conf.ClockSkew).ParseAccessToken(token)
+		if err != nil {
+			writeInvalidToken(w, err)
+			return
+		}
+		if auth.Revocations != nil && data.ID != "" && auth.Revocations.IsRevoked(data.ID) {
 			writeUnauthed(w)
 			return
 		}
+		if data.Actor != "" {
+			log.Printf("impersonation: %s как %s: %s %s", data.Actor, data.Email, r.Method, r.URL.Path)
+			if auth.Impersonation != nil {
+				if err := auth.Impersonation.RecordImpersonation(data, r); err != nil {
+					log.Printf("impersonation: запрос не записан в журнал: %v", err)
+					writeUnauthed(w)
+					return
+				}
+			}
+		}
 		ctx := context.WithValue(r.Context(), ContextEmailKey, data.Email)
+		ctx = context.WithValue(ctx, ContextClaimsKey, data)
+		ctx = context.WithValue(ctx, ContextRoleKey, data.Role)
+		ctx = context.WithValue(ctx, ContextPermissionsKey, data.Permissions)
 		req := r.WithContext(ctx)
 		next.ServeHTTP(w, req)
 	})
 }
+
+// RequirePermission пропускает запрос, только если в access-токене есть
+// право permission. Без токена отвечает 401, без права — 403.
+func RequirePermission(permission string, next http.Handler, auth *Auth) http.Handler {
+	return IsAuthed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !HasPermission(r.Context(), permission) {
+			writeForbidden(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}), auth)
+}
+
+// IsAPIKey сообщает, что запрос аутентифицирован API-ключом, а не
+// токеном пользователя.
+func IsAPIKey(ctx context.Context) bool {
+	_, ok := ctx.Value(ContextAPIKeyIDKey).(uint)
+	return ok
+}
+
+// IsImpersonated сообщает, что запрос сделан администратором от имени
+// пользователя.
+func IsImpersonated(ctx context.Context) bool {
+	claims, _ := ctx.Value(ContextClaimsKey).(*jwt.JWTData)
+	return claims != nil && claims.Actor != ""
+}
+
+// OwnerOnly пропускает только запросы самого пользователя: не по API-ключу
+// и не от имени администратора. Нужен для управления входом и аккаунтом.
+func OwnerOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsAPIKey(r.Context()) || IsImpersonated(r.Context()) {
+			writeForbidden(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if strings.HasPrefix(token, APIKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// HasPermission проверяет право из контекста запроса, заполненного IsAuthed.
+func HasPermission(ctx context.Context, permission string) bool {
+	permissions, _ := ctx.Value(ContextPermissionsKey).([]string)
+	return slices.Contains(permissions, permission)
+}
+
+func writeForbidden(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusForbidden)
+	w.Write([]byte(http.StatusText(http.StatusForbidden)))
+}
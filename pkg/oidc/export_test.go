@@ -0,0 +1,11 @@
+package oidc
+
+import "time"
+
+// ExpireKeys позволяет тестам не ждать keysRefreshInterval перед повторной
+// загрузкой ключей.
+func (p *Provider) ExpireKeys() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keysFetchedAt = time.Now().Add(-keysRefreshInterval)
+}
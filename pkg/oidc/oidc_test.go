@@ -0,0 +1,211 @@
+package oidc_test
+
+import (
+	"coffee/pkg/oidc"
+	"coffee/pkg/oidc/oidctest"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const redirectURL = "https://coffee.example.com/auth/oidc/fake/callback"
+
+func newProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	fake, server, err := oidctest.NewServer("coffee", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	provider := oidc.NewProvider("fake", server.URL, "coffee", "secret", redirectURL, []string{"openid", "email"})
+	return fake, provider
+}
+
+// authorize проходит страницу входа провайдера и возвращает code и state
+// из перенаправления обратно.
+func authorize(t *testing.T, link string) (code, state string) {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	response, err := client.Get(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", response.StatusCode)
+	}
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != redirectURL {
+		t.Fatalf("redirected to %s, want %s", got, redirectURL)
+	}
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestAuthorizationCodeWithPKCE(t *testing.T) {
+	fake, provider := newProvider(t)
+	fake.Email = "alice@example.com"
+	ctx := context.Background()
+	state, nonce, verifier := oidc.RandomString(), oidc.RandomString(), oidc.RandomString()
+
+	link, err := provider.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := mustParse(t, link).Query()
+	if query.Get("code_challenge") != oidc.Challenge(verifier) || query.Get("code_challenge_method") != "S256" {
+		t.Errorf("PKCE parameters = %v", query)
+	}
+	code, returnedState := authorize(t, link)
+	if returnedState != state {
+		t.Errorf("state = %q, want %q", returnedState, state)
+	}
+
+	claims, err := provider.Exchange(ctx, code, verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := oidc.Claims{Subject: "fake|alice@example.com", Email: "alice@example.com", EmailVerified: true, Name: "Test User"}
+	if *claims != want {
+		t.Errorf("claims = %+v, want %+v", *claims, want)
+	}
+	if _, err := provider.Exchange(ctx, code, verifier, nonce); !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("reused code: error = %v, want %v", err, oidc.ErrExchange)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	_, provider := newProvider(t)
+	ctx := context.Background()
+	nonce, verifier := oidc.RandomString(), oidc.RandomString()
+	link, err := provider.AuthCodeURL(ctx, oidc.RandomString(), nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := authorize(t, link)
+	if _, err := provider.Exchange(ctx, code, oidc.RandomString(), nonce); !errors.Is(err, oidc.ErrExchange) {
+		t.Errorf("error = %v, want %v", err, oidc.ErrExchange)
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	_, provider := newProvider(t)
+	ctx := context.Background()
+	verifier := oidc.RandomString()
+	link, err := provider.AuthCodeURL(ctx, oidc.RandomString(), oidc.RandomString(), verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := authorize(t, link)
+	if _, err := provider.Exchange(ctx, code, verifier, oidc.RandomString()); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("error = %v, want %v", err, oidc.ErrInvalidToken)
+	}
+}
+
+func TestVerifyIDTokenClaims(t *testing.T) {
+	fake, provider := newProvider(t)
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		extra   jwt.MapClaims
+		wantErr bool
+	}{
+		{"valid", nil, false},
+		{"wrong audience", jwt.MapClaims{"aud": "other-client"}, true},
+		{"audience list with client", jwt.MapClaims{"aud": []string{"other-client", "coffee"}}, false},
+		{"wrong azp", jwt.MapClaims{"aud": []string{"other-client", "coffee"}, "azp": "other-client"}, true},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example.com"}, true},
+		{"expired", jwt.MapClaims{"exp": 1}, true},
+		{"no subject", jwt.MapClaims{"sub": ""}, true},
+		{"email_verified as string", jwt.MapClaims{"email_verified": "true"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := fake.IDToken("bob@example.com", "nonce", test.extra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := provider.VerifyIDToken(ctx, raw, "nonce")
+			if test.wantErr {
+				if !errors.Is(err, oidc.ErrInvalidToken) {
+					t.Errorf("error = %v, want %v", err, oidc.ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Email != "bob@example.com" || !claims.EmailVerified {
+				t.Errorf("claims = %+v", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyIDTokenRefetchesKeysForUnknownKid(t *testing.T) {
+	fake, provider := newProvider(t)
+	ctx := context.Background()
+	raw, err := fake.IDToken("carol@example.com", "nonce", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.VerifyIDToken(ctx, raw, "nonce"); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.JWKSRequests(); got != 1 {
+		t.Fatalf("JWKS requests = %d, want 1", got)
+	}
+
+	if err := fake.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := fake.IDToken("carol@example.com", "nonce", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Сразу после загрузки ключи не перезагружаются, чтобы токены с
+	// выдуманным kid не заставляли ходить к провайдеру на каждый запрос.
+	if _, err := provider.VerifyIDToken(ctx, rotated, "nonce"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("error = %v, want %v", err, oidc.ErrInvalidToken)
+	}
+	if got := fake.JWKSRequests(); got != 1 {
+		t.Errorf("JWKS requests = %d, want 1", got)
+	}
+
+	provider.ExpireKeys()
+	if _, err := provider.VerifyIDToken(ctx, rotated, "nonce"); err != nil {
+		t.Fatalf("after refetch: %v", err)
+	}
+	if got := fake.JWKSRequests(); got != 2 {
+		t.Errorf("JWKS requests = %d, want 2", got)
+	}
+	if _, err := provider.VerifyIDToken(ctx, raw, "nonce"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("token signed with the removed key: error = %v, want %v", err, oidc.ErrInvalidToken)
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	fake, provider := newProvider(t)
+	fake.Issuer = "https://other.example.com"
+	_, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if !errors.Is(err, oidc.ErrDiscovery) {
+		t.Errorf("error = %v, want %v", err, oidc.ErrDiscovery)
+	}
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
@@ -0,0 +1,258 @@
+// Package oidctest — поддельный провайдер OpenID Connect для тестов и
+// локальной разработки. Страница входа сразу «входит» пользователем Email
+// или из параметра login_hint и возвращает его по authorization code с
+// PKCE.
+package oidctest
+
+import (
+	"coffee/pkg/oidc"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// grant — выданный, но еще не обмененный code.
+type grant struct {
+	redirectURI string
+	nonce       string
+	challenge   string
+	email       string
+	expiresAt   time.Time
+}
+
+// Provider — провайдер с одним ключом RS256. Поля пользователя можно
+// менять между входами. Claims дописываются в каждый id_token поверх
+// обычных и нужны, чтобы выдать заведомо неверный токен.
+type Provider struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	Email         string
+	Name          string
+	EmailVerified bool
+	Claims        jwt.MapClaims
+
+	mu           sync.Mutex
+	key          *rsa.PrivateKey
+	keyID        string
+	keyVersion   int
+	codes        map[string]grant
+	jwksRequests int
+}
+
+// New создает провайдера с новым ключом. Пустой clientSecret — публичный
+// клиент.
+func New(issuer, clientID, clientSecret string) (*Provider, error) {
+	provider := &Provider{
+		Issuer:        issuer,
+		ClientID:      clientID,
+		ClientSecret:  clientSecret,
+		Email:         "user@example.com",
+		Name:          "Test User",
+		EmailVerified: true,
+		codes:         map[string]grant{},
+	}
+	if err := provider.RotateKey(); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+// NewServer запускает провайдера на httptest.Server; Issuer — адрес
+// сервера. Сервер нужно закрыть.
+func NewServer(clientID, clientSecret string) (*Provider, *httptest.Server, error) {
+	provider, err := New("", clientID, clientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	server := httptest.NewServer(provider.Handler())
+	provider.Issuer = server.URL
+	return provider, server, nil
+}
+
+// Handler — discovery, JWKS, страница входа и token endpoint.
+func (p *Provider) Handler() http.Handler {
+	router := http.NewServeMux()
+	router.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	router.HandleFunc("GET /jwks", p.jwks)
+	router.HandleFunc("GET /authorize", p.authorize)
+	router.HandleFunc("POST /token", p.token)
+	return router
+}
+
+// RotateKey заменяет ключ подписи ключом с новым kid. Старый ключ сразу
+// пропадает из JWKS.
+func (p *Provider) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keyVersion++
+	p.key = key
+	p.keyID = fmt.Sprintf("fake-%d", p.keyVersion)
+	return nil
+}
+
+// JWKSRequests — сколько раз загружались ключи.
+func (p *Provider) JWKSRequests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.jwksRequests
+}
+
+// IDToken подписывает id_token текущим ключом: стандартные claims для
+// email и nonce, затем Claims, затем extra.
+func (p *Provider) IDToken(email, nonce string, extra jwt.MapClaims) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            p.Issuer,
+		"sub":            "fake|" + email,
+		"aud":            p.ClientID,
+		"exp":            now.Add(time.Hour).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          email,
+		"email_verified": p.EmailVerified,
+		"name":           p.Name,
+	}
+	for name, value := range p.Claims {
+		claims[name] = value
+	}
+	for name, value := range extra {
+		claims[name] = value
+	}
+	p.mu.Lock()
+	key, keyID := p.key, p.keyID
+	p.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	return token.SignedString(key)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer,
+		"authorization_endpoint":                p.Issuer + "/authorize",
+		"token_endpoint":                        p.Issuer + "/token",
+		"jwks_uri":                              p.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	p.jwksRequests++
+	key, keyID := p.key, p.keyID
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	target, err := url.Parse(redirectURI)
+	if err != nil || redirectURI == "" {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if query.Get("client_id") != p.ClientID || query.Get("response_type") != "code" ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+	email := p.Email
+	if hint := query.Get("login_hint"); hint != "" {
+		email = hint
+	}
+	code := oidc.RandomString()
+	p.mu.Lock()
+	p.codes[code] = grant{
+		redirectURI: redirectURI,
+		nonce:       query.Get("nonce"),
+		challenge:   query.Get("code_challenge"),
+		email:       email,
+		expiresAt:   time.Now().Add(time.Minute),
+	}
+	p.mu.Unlock()
+	values := target.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	target.RawQuery = values.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request")
+		return
+	}
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		clientSecret = r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || subtle.ConstantTimeCompare([]byte(clientSecret), []byte(p.ClientSecret)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	p.mu.Lock()
+	granted, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if r.PostForm.Get("grant_type") != "authorization_code" || !found || time.Now().After(granted.expiresAt) ||
+		granted.redirectURI != r.PostForm.Get("redirect_uri") ||
+		oidc.Challenge(r.PostForm.Get("code_verifier")) != granted.challenge {
+		tokenError(w, "invalid_grant")
+		return
+	}
+	idToken, err := p.IDToken(granted.email, granted.nonce, nil)
+	if err != nil {
+		tokenError(w, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": oidc.RandomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
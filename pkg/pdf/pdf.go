@@ -0,0 +1,228 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Mm — миллиметр в пунктах PDF.
+const Mm = 72 / 25.4
+
+// Document — минимальный генератор PDF: страницы произвольного размера
+// с векторной графикой, растровыми изображениями и текстом. Координаты
+// задаются в пунктах от левого верхнего угла страницы.
+type Document struct {
+	pages  []*Page
+	images []image.Image
+	ids    map[image.Image]int
+}
+
+type Page struct {
+	Width   float64
+	Height  float64
+	doc     *Document
+	content bytes.Buffer
+	images  map[int]bool
+}
+
+func New() *Document {
+	return &Document{ids: map[image.Image]int{}}
+}
+
+// AddPage добавляет страницу размером width x height пунктов.
+func (doc *Document) AddPage(width, height float64) *Page {
+	page := &Page{Width: width, Height: height, doc: doc, images: map[int]bool{}}
+	doc.pages = append(doc.pages, page)
+	return page
+}
+
+// SetFill задает цвет заливки, компоненты от 0 до 255.
+func (page *Page) SetFill(r, g, b uint8) {
+	fmt.Fprintf(&page.content, "%s %s %s rg\n", color(r), color(g), color(b))
+}
+
+// Rect добавляет прямоугольник к текущему контуру. Контур заливается Fill.
+func (page *Page) Rect(x, y, width, height float64) {
+	fmt.Fprintf(&page.content, "%s %s %s %s re\n",
+		num(x), num(page.Height-y-height), num(width), num(height))
+}
+
+func (page *Page) MoveTo(x, y float64) {
+	fmt.Fprintf(&page.content, "%s %s m\n", num(x), num(page.Height-y))
+}
+
+func (page *Page) LineTo(x, y float64) {
+	fmt.Fprintf(&page.content, "%s %s l\n", num(x), num(page.Height-y))
+}
+
+// CurveTo добавляет кубическую кривую Безье с контрольными точками
+// (x1, y1) и (x2, y2).
+func (page *Page) CurveTo(x1, y1, x2, y2, x, y float64) {
+	fmt.Fprintf(&page.content, "%s %s %s %s %s %s c\n",
+		num(x1), num(page.Height-y1), num(x2), num(page.Height-y2), num(x), num(page.Height-y))
+}
+
+func (page *Page) ClosePath() {
+	page.content.WriteString("h\n")
+}
+
+// Fill заливает текущий контур по правилу ненулевого индекса.
+func (page *Page) Fill() {
+	page.content.WriteString("f\n")
+}
+
+// Rotate180 поворачивает все, что рисуется до Restore, на 180 градусов
+// вокруг точки (cx, cy). Нужен для верхней половины складных табличек.
+func (page *Page) Rotate180(cx, cy float64) {
+	fmt.Fprintf(&page.content, "q -1 0 0 -1 %s %s cm\n", num(2*cx), num(2*(page.Height-cy)))
+}
+
+// Restore отменяет преобразование Rotate180.
+func (page *Page) Restore() {
+	page.content.WriteString("Q\n")
+}
+
+// Image рисует изображение в прямоугольнике. Прозрачность накладывается
+// на белый фон. Одно и то же изображение хранится в документе один раз.
+func (page *Page) Image(img image.Image, x, y, width, height float64) {
+	id, ok := page.doc.ids[img]
+	if !ok {
+		id = len(page.doc.images)
+		page.doc.images = append(page.doc.images, img)
+		page.doc.ids[img] = id
+	}
+	page.images[id] = true
+	fmt.Fprintf(&page.content, "q %s 0 0 %s %s %s cm /Im%d Do Q\n",
+		num(width), num(height), num(x), num(page.Height-y-height), id)
+}
+
+// WriteTo записывает документ в формате PDF 1.4.
+func (doc *Document) WriteTo(w io.Writer) (int64, error) {
+	out := &writer{}
+	out.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	// 1 — каталог, 2 — дерево страниц, далее по два объекта на страницу,
+	// затем изображения.
+	pageID := func(i int) int { return 3 + i*2 }
+	imageID := func(i int) int { return 3 + len(doc.pages)*2 + i }
+
+	out.object(1, "<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(doc.pages))
+	for i := range doc.pages {
+		kids[i] = strconv.Itoa(pageID(i)) + " 0 R"
+	}
+	out.object(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+
+	for i, page := range doc.pages {
+		xobjects := ""
+		for id := range page.images {
+			xobjects += fmt.Sprintf("/Im%d %d 0 R ", id, imageID(id))
+		}
+		out.object(pageID(i), fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Contents %d 0 R /Resources << /XObject << %s>> >> >>",
+			num(page.Width), num(page.Height), pageID(i)+1, xobjects))
+		out.stream(pageID(i)+1, "", page.content.Bytes())
+	}
+
+	for i, img := range doc.images {
+		bounds := img.Bounds()
+		data, err := encodeRGB(img)
+		if err != nil {
+			return 0, err
+		}
+		out.stream(imageID(i), fmt.Sprintf(
+			"/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /FlateDecode ",
+			bounds.Dx(), bounds.Dy()), data)
+	}
+
+	out.finish(1)
+	n, err := w.Write(out.buf.Bytes())
+	return int64(n), err
+}
+
+// Bytes возвращает документ целиком.
+func (doc *Document) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeRGB сжимает пиксели изображения в RGB, накладывая альфа-канал на белый.
+func encodeRGB(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	bounds := img.Bounds()
+	row := make([]byte, 0, bounds.Dx()*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row = row[:0]
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			white := 0xffff - a
+			row = append(row, byte((r+white)>>8), byte((g+white)>>8), byte((b+white)>>8))
+		}
+		if _, err := zw.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type writer struct {
+	buf     bytes.Buffer
+	offsets map[int]int
+}
+
+func (w *writer) printf(format string, args ...any) {
+	fmt.Fprintf(&w.buf, format, args...)
+}
+
+func (w *writer) begin(id int) {
+	if w.offsets == nil {
+		w.offsets = map[int]int{}
+	}
+	w.offsets[id] = w.buf.Len()
+	w.printf("%d 0 obj\n", id)
+}
+
+func (w *writer) object(id int, body string) {
+	w.begin(id)
+	w.printf("%s\nendobj\n", body)
+}
+
+// stream записывает объект-поток. dict — дополнительные ключи словаря.
+func (w *writer) stream(id int, dict string, data []byte) {
+	w.begin(id)
+	w.printf("<< /Length %d %s>>\nstream\n", len(data), dict)
+	w.buf.Write(data)
+	w.printf("\nendstream\nendobj\n")
+}
+
+func (w *writer) finish(root int) {
+	size := len(w.offsets) + 1
+	xref := w.buf.Len()
+	w.printf("xref\n0 %d\n0000000000 65535 f \n", size)
+	for id := 1; id < size; id++ {
+		w.printf("%010d 00000 n \n", w.offsets[id])
+	}
+	w.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", size, root, xref)
+}
+
+func num(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 3, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+func color(c uint8) string {
+	return strconv.FormatFloat(float64(c)/255, 'f', 3, 64)
+}
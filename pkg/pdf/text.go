@@ -0,0 +1,123 @@
+package pdf
+
+import (
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"sync"
+)
+
+// Текст рисуется контурами глифов шрифта Go Regular, поэтому шрифт не
+// встраивается в документ, а кириллица выводится без кодировок PDF.
+
+// glyphUnits — размер em, в котором загружаются контуры глифов.
+const glyphUnits = 1000
+
+var (
+	fontOnce sync.Once
+	textFont *sfnt.Font
+	fontErr  error
+)
+
+func loadFont() (*sfnt.Font, error) {
+	fontOnce.Do(func() {
+		textFont, fontErr = sfnt.Parse(goregular.TTF)
+	})
+	return textFont, fontErr
+}
+
+// TextWidth возвращает ширину строки в пунктах при кегле size.
+func TextWidth(text string, size float64) float64 {
+	f, err := loadFont()
+	if err != nil {
+		return 0
+	}
+	var buf sfnt.Buffer
+	width := fixed.Int26_6(0)
+	prev := sfnt.GlyphIndex(0)
+	for i, r := range text {
+		index, err := f.GlyphIndex(&buf, r)
+		if err != nil {
+			continue
+		}
+		if i > 0 {
+			if kern, err := f.Kern(&buf, prev, index, fixed.I(glyphUnits), font.HintingNone); err == nil {
+				width += kern
+			}
+		}
+		advance, err := f.GlyphAdvance(&buf, index, fixed.I(glyphUnits), font.HintingNone)
+		if err == nil {
+			width += advance
+		}
+		prev = index
+	}
+	return float64(width) / 64 * size / glyphUnits
+}
+
+// Text рисует строку кеглем size текущим цветом заливки. (x, y) — начало
+// базовой линии.
+func (page *Page) Text(x, y, size float64, text string) {
+	f, err := loadFont()
+	if err != nil {
+		return
+	}
+	scale := size / glyphUnits / 64
+	var buf sfnt.Buffer
+	pen := fixed.Int26_6(0)
+	prev := sfnt.GlyphIndex(0)
+	for i, r := range text {
+		index, err := f.GlyphIndex(&buf, r)
+		if err != nil {
+			continue
+		}
+		if i > 0 {
+			if kern, err := f.Kern(&buf, prev, index, fixed.I(glyphUnits), font.HintingNone); err == nil {
+				pen += kern
+			}
+		}
+		segments, err := f.LoadGlyph(&buf, index, fixed.I(glyphUnits), nil)
+		if err == nil && len(segments) > 0 {
+			page.glyph(segments, x+float64(pen)*scale, y, scale)
+			page.Fill()
+		}
+		advance, err := f.GlyphAdvance(&buf, index, fixed.I(glyphUnits), font.HintingNone)
+		if err == nil {
+			pen += advance
+		}
+		prev = index
+	}
+}
+
+// glyph добавляет к контуру сегменты глифа. Квадратичные кривые
+// переводятся в кубические.
+func (page *Page) glyph(segments sfnt.Segments, x, y, scale float64) {
+	point := func(p fixed.Point26_6) (float64, float64) {
+		return x + float64(p.X)*scale, y + float64(p.Y)*scale
+	}
+	var cx, cy float64
+	for i, segment := range segments {
+		switch segment.Op {
+		case sfnt.SegmentOpMoveTo:
+			if i > 0 {
+				page.ClosePath()
+			}
+			cx, cy = point(segment.Args[0])
+			page.MoveTo(cx, cy)
+		case sfnt.SegmentOpLineTo:
+			cx, cy = point(segment.Args[0])
+			page.LineTo(cx, cy)
+		case sfnt.SegmentOpQuadTo:
+			qx, qy := point(segment.Args[0])
+			ex, ey := point(segment.Args[1])
+			page.CurveTo(cx+2.0/3*(qx-cx), cy+2.0/3*(qy-cy), ex+2.0/3*(qx-ex), ey+2.0/3*(qy-ey), ex, ey)
+			cx, cy = ex, ey
+		case sfnt.SegmentOpCubeTo:
+			x1, y1 := point(segment.Args[0])
+			x2, y2 := point(segment.Args[1])
+			cx, cy = point(segment.Args[2])
+			page.CurveTo(x1, y1, x2, y2, cx, cy)
+		}
+	}
+	page.ClosePath()
+}
@@ -0,0 +1,75 @@
+package qr
+
+import (
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"image"
+	"image/color"
+	"math"
+)
+
+func fill(img draw.Image, rect image.Rectangle, c color.RGBA) {
+	draw.Draw(img, rect, image.NewUniform(c), image.Point{}, draw.Src)
+}
+
+func scaleRect(rect image.Rectangle, scale float64) image.Rectangle {
+	return image.Rect(
+		int(math.Round(float64(rect.Min.X)*scale)),
+		int(math.Round(float64(rect.Min.Y)*scale)),
+		int(math.Round(float64(rect.Max.X)*scale)),
+		int(math.Round(float64(rect.Max.Y)*scale)),
+	)
+}
+
+// fitRect вписывает изображение с границами src в квадрат area (в модулях)
+// с сохранением пропорций и возвращает прямоугольник в пунктах.
+func fitRect(src image.Rectangle, area image.Rectangle, module float64) (x, y, w, h float64) {
+	side := float64(area.Dx()) * module
+	w, h = side, side
+	if src.Dx() > src.Dy() {
+		h = side * float64(src.Dy()) / float64(src.Dx())
+	} else {
+		w = side * float64(src.Dx()) / float64(src.Dy())
+	}
+	x = float64(area.Min.X)*module + (side-w)/2
+	y = float64(area.Min.Y)*module + (side-h)/2
+	return x, y, w, h
+}
+
+// drawLogo вписывает логотип в rect с сохранением пропорций.
+func drawLogo(dst draw.Image, rect image.Rectangle, logo image.Image) {
+	x, y, w, h := fitRect(logo.Bounds(), image.Rect(0, 0, 1, 1), float64(rect.Dx()))
+	target := image.Rect(
+		rect.Min.X+int(math.Round(x)), rect.Min.Y+int(math.Round(y)),
+		rect.Min.X+int(math.Round(x+w)), rect.Min.Y+int(math.Round(y+h)),
+	)
+	draw.CatmullRom.Scale(dst, target, logo, logo.Bounds(), draw.Over, nil)
+}
+
+// drawCaption печатает подпись по центру полосы rect.
+func drawCaption(dst draw.Image, rect image.Rectangle, caption string, c color.RGBA) error {
+	ttf, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return err
+	}
+	face, err := opentype.NewFace(ttf, &opentype.FaceOptions{
+		Size:    float64(rect.Dy()) * 0.6,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+	drawer := &font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+	width := drawer.MeasureString(caption)
+	drawer.Dot = fixed.Point26_6{
+		X: fixed.I(rect.Min.X+rect.Dx()/2) - width/2,
+		Y: fixed.I(rect.Min.Y + int(float64(rect.Dy())*0.7)),
+	}
+	drawer.DrawString(caption)
+	return nil
+}
@@ -0,0 +1,58 @@
+package qr
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// Ограничения параметров из запроса.
+const (
+	DefaultSize = 256
+	MinSize     = 64
+	MaxSize     = 2048
+	MaxMargin   = 16
+	MaxCaption  = 40
+)
+
+// ParseOptions разбирает параметры отрисовки и оформления из запроса:
+// format, ecc, size, margin, fg, bg, rounded и caption. Логотип не
+// разбирается — его источник знает вызывающий.
+func ParseOptions(query url.Values) (Options, error) {
+	opts := Options{Size: DefaultSize, Margin: 4}
+	var err error
+	if opts.Format, err = ParseFormat(query.Get("format")); err != nil {
+		return opts, err
+	}
+	if opts.Level, err = ParseLevel(query.Get("ecc")); err != nil {
+		return opts, err
+	}
+	if raw := query.Get("size"); raw != "" {
+		opts.Size, err = strconv.Atoi(raw)
+		if err != nil || opts.Size < MinSize || opts.Size > MaxSize {
+			return opts, fmt.Errorf("size должен быть от %d до %d", MinSize, MaxSize)
+		}
+	}
+	if raw := query.Get("margin"); raw != "" {
+		opts.Margin, err = strconv.Atoi(raw)
+		if err != nil || opts.Margin < 0 || opts.Margin > MaxMargin {
+			return opts, fmt.Errorf("margin должен быть от 0 до %d", MaxMargin)
+		}
+	}
+	if raw := query.Get("fg"); raw != "" {
+		if opts.Foreground, err = ParseColor(raw); err != nil {
+			return opts, err
+		}
+	}
+	if raw := query.Get("bg"); raw != "" {
+		if opts.Background, err = ParseColor(raw); err != nil {
+			return opts, err
+		}
+	}
+	opts.Rounded, _ = strconv.ParseBool(query.Get("rounded"))
+	opts.Caption = query.Get("caption")
+	if len([]rune(opts.Caption)) > MaxCaption {
+		return opts, fmt.Errorf("caption длиннее %d символов", MaxCaption)
+	}
+	return opts, nil
+}
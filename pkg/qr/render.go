@@ -0,0 +1,440 @@
+package qr
+
+import (
+	"bytes"
+	"coffee/pkg/pdf"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/skip2/go-qrcode"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strings"
+)
+
+type Format string
+
+const (
+	PNG Format = "png"
+	SVG Format = "svg"
+	PDF Format = "pdf"
+)
+
+func (f Format) ContentType() string {
+	switch f {
+	case SVG:
+		return "image/svg+xml"
+	case PDF:
+		return "application/pdf"
+	default:
+		return "image/png"
+	}
+}
+
+// Options — параметры отрисовки QR-кода. Size — сторона кода в пикселях
+// для PNG и в пунктах для SVG и PDF, Margin — ширина пустой рамки в модулях.
+type Options struct {
+	Format Format
+	Size   int
+	Level  qrcode.RecoveryLevel
+	Margin int
+
+	// Оформление. Нулевые цвета означают черный код на белом фоне.
+	Foreground color.RGBA
+	Background color.RGBA
+	// Rounded скругляет внешние углы модулей.
+	Rounded bool
+	// Caption печатается под кодом.
+	Caption string
+	// Logo рисуется в центре кода, уровень коррекции при этом поднимается до H.
+	Logo image.Image
+	// LogoKey идентифицирует Logo в Key.
+	LogoKey string
+}
+
+const (
+	// logoRatio — доля стороны кода без рамки, которую занимает логотип.
+	logoRatio = 0.22
+	// captionRatio — высота полосы подписи относительно стороны кода.
+	captionRatio = 0.14
+)
+
+// ParseFormat разбирает формат, пустая строка означает PNG.
+func ParseFormat(value string) (Format, error) {
+	switch Format(strings.ToLower(value)) {
+	case "", PNG:
+		return PNG, nil
+	case SVG:
+		return SVG, nil
+	case PDF:
+		return PDF, nil
+	}
+	return "", fmt.Errorf("unknown format %q", value)
+}
+
+// ParseLevel разбирает уровень коррекции ошибок L, M, Q или H. Пустая
+// строка означает M.
+func ParseLevel(value string) (qrcode.RecoveryLevel, error) {
+	switch strings.ToUpper(value) {
+	case "L":
+		return qrcode.Low, nil
+	case "", "M":
+		return qrcode.Medium, nil
+	case "Q":
+		return qrcode.High, nil
+	case "H":
+		return qrcode.Highest, nil
+	}
+	return 0, fmt.Errorf("unknown error correction level %q", value)
+}
+
+// ParseColor разбирает цвет вида #rrggbb или rrggbb.
+func ParseColor(value string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(value, "#")
+	var r, g, b uint8
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", value)
+	}
+	if _, err := fmt.Sscanf(hex, "%02x%02x%02x", &r, &g, &b); err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", value)
+	}
+	return color.RGBA{R: r, G: g, B: b, A: 0xff}, nil
+}
+
+// Key однозначно описывает QR-код с содержимым content и параметрами opts.
+func (opts Options) Key(content string) string {
+	return fmt.Sprintf("%s|%d|%d|%d|%s|%s|%t|%s|%s|%s",
+		opts.Format, opts.Size, opts.Level, opts.Margin,
+		hexColor(opts.Foreground), hexColor(opts.Background), opts.Rounded,
+		opts.LogoKey, opts.Caption, content)
+}
+
+// Render рисует QR-код с содержимым content в выбранном формате.
+func Render(content string, opts Options) ([]byte, error) {
+	opts = opts.withDefaults()
+	modules, err := bitmap(content, opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	l := newLayout(modules, opts)
+	switch opts.Format {
+	case SVG:
+		return renderSVG(l, opts)
+	case PDF:
+		return renderPDF(l, opts)
+	default:
+		return renderPNG(l, opts)
+	}
+}
+
+func (opts Options) withDefaults() Options {
+	if opts.Foreground == (color.RGBA{}) {
+		opts.Foreground = color.RGBA{A: 0xff}
+	}
+	if opts.Background == (color.RGBA{}) {
+		opts.Background = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	}
+	if opts.Logo != nil {
+		opts.Level = qrcode.Highest
+	}
+	return opts
+}
+
+// bitmap возвращает матрицу модулей без рамки.
+func bitmap(content string, level qrcode.RecoveryLevel) ([][]bool, error) {
+	code, err := qrcode.New(content, level)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate a QR code: %v", err)
+	}
+	code.DisableBorder = true
+	return code.Bitmap(), nil
+}
+
+// layout — геометрия кода в модулях: рамка, место под логотип и подпись.
+type layout struct {
+	modules [][]bool
+	margin  int
+	total   int
+	// logo — квадрат под логотип в модулях от левого верхнего угла кода,
+	// модули внутри не рисуются.
+	logo    image.Rectangle
+	hasLogo bool
+	// caption — высота полосы подписи в модулях.
+	caption float64
+}
+
+func newLayout(modules [][]bool, opts Options) *layout {
+	l := &layout{
+		modules: modules,
+		margin:  opts.Margin,
+		total:   len(modules) + 2*opts.Margin,
+	}
+	if opts.Logo != nil {
+		side := int(math.Round(float64(len(modules)) * logoRatio))
+		if side%2 != len(modules)%2 {
+			side++
+		}
+		offset := (len(modules)-side)/2 + opts.Margin
+		l.logo = image.Rect(offset, offset, offset+side, offset+side)
+		l.hasLogo = true
+	}
+	if opts.Caption != "" {
+		l.caption = float64(l.total) * captionRatio
+	}
+	return l
+}
+
+// dark сообщает, рисуется ли модуль (x, y) в координатах с учетом рамки.
+func (l *layout) dark(x, y int) bool {
+	if l.hasLogo && image.Pt(x, y).In(l.logo.Inset(-1)) {
+		return false
+	}
+	x -= l.margin
+	y -= l.margin
+	return y >= 0 && y < len(l.modules) && x >= 0 && x < len(l.modules[y]) && l.modules[y][x]
+}
+
+// corners возвращает скругляемые углы модуля: левый верхний, правый
+// верхний, правый нижний, левый нижний. Угол скругляется, если оба
+// соседних по нему модуля пустые.
+func (l *layout) corners(x, y int) [4]bool {
+	up, down := l.dark(x, y-1), l.dark(x, y+1)
+	left, right := l.dark(x-1, y), l.dark(x+1, y)
+	return [4]bool{!up && !left, !up && !right, !down && !right, !down && !left}
+}
+
+// covers сообщает, закрашена ли точка (u, v) внутри модуля (от 0 до 1)
+// с учетом скругления углов.
+func covers(corners [4]bool, u, v float64) bool {
+	var corner int
+	switch {
+	case u < 0.5 && v < 0.5:
+		corner = 0
+	case u >= 0.5 && v < 0.5:
+		corner = 1
+	case u >= 0.5:
+		corner = 2
+	default:
+		corner = 3
+	}
+	if !corners[corner] {
+		return true
+	}
+	du, dv := u-0.5, v-0.5
+	return du*du+dv*dv <= 0.25
+}
+
+func renderPNG(l *layout, opts Options) ([]byte, error) {
+	size := opts.Size
+	if size < l.total {
+		size = l.total
+	}
+	scale := float64(size) / float64(l.total)
+	captionHeight := int(math.Round(l.caption * scale))
+	img := image.NewRGBA(image.Rect(0, 0, size, size+captionHeight))
+	fill(img, img.Bounds(), opts.Background)
+
+	for py := 0; py < size; py++ {
+		fy := float64(py) / scale
+		my := int(fy)
+		for px := 0; px < size; px++ {
+			fx := float64(px) / scale
+			mx := int(fx)
+			if !l.dark(mx, my) {
+				continue
+			}
+			if opts.Rounded && !covers(l.corners(mx, my), fx-float64(mx), fy-float64(my)) {
+				continue
+			}
+			img.SetRGBA(px, py, opts.Foreground)
+		}
+	}
+	if l.hasLogo {
+		drawLogo(img, scaleRect(l.logo, scale), opts.Logo)
+	}
+	if opts.Caption != "" {
+		if err := drawCaption(img, image.Rect(0, size, size, size+captionHeight), opts.Caption, opts.Foreground); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("could not encode PNG: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func renderSVG(l *layout, opts Options) ([]byte, error) {
+	height := float64(l.total) + l.caption
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%s" viewBox="0 0 %d %s">`,
+		opts.Size, num(float64(opts.Size)*height/float64(l.total)), l.total, num(height))
+	fmt.Fprintf(&buf, `<rect width="%d" height="%s" fill="%s"/>`, l.total, num(height), hexColor(opts.Background))
+	fmt.Fprintf(&buf, `<path fill="%s" d="`, hexColor(opts.Foreground))
+	for y := 0; y < l.total; y++ {
+		for x := 0; x < l.total; x++ {
+			if !l.dark(x, y) {
+				continue
+			}
+			if !opts.Rounded {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x, y)
+				continue
+			}
+			svgModule(&buf, x, y, l.corners(x, y))
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	if l.hasLogo {
+		var logo bytes.Buffer
+		if err := png.Encode(&logo, opts.Logo); err != nil {
+			return nil, fmt.Errorf("could not encode logo: %v", err)
+		}
+		fmt.Fprintf(&buf, `<image x="%d" y="%d" width="%d" height="%d" preserveAspectRatio="xMidYMid meet" href="data:image/png;base64,%s"/>`,
+			l.logo.Min.X, l.logo.Min.Y, l.logo.Dx(), l.logo.Dy(), base64.StdEncoding.EncodeToString(logo.Bytes()))
+	}
+	if opts.Caption != "" {
+		buf.WriteString(fmt.Sprintf(`<text x="%s" y="%s" font-size="%s" font-family="Go, Arial, sans-serif" text-anchor="middle" fill="%s">`,
+			num(float64(l.total)/2), num(float64(l.total)+l.caption*0.7), num(l.caption*0.6), hexColor(opts.Foreground)))
+		if err := xml.EscapeText(&buf, []byte(opts.Caption)); err != nil {
+			return nil, err
+		}
+		buf.WriteString(`</text>`)
+	}
+	buf.WriteString(`</svg>`)
+	return buf.Bytes(), nil
+}
+
+// svgModule рисует модуль со скругленными углами радиусом в половину модуля.
+func svgModule(buf *bytes.Buffer, x, y int, corners [4]bool) {
+	r := func(rounded bool) float64 {
+		if rounded {
+			return 0.5
+		}
+		return 0
+	}
+	tl, tr, br, bl := r(corners[0]), r(corners[1]), r(corners[2]), r(corners[3])
+	fx, fy := float64(x), float64(y)
+	fmt.Fprintf(buf, "M%s %sH%s", num(fx+tl), num(fy), num(fx+1-tr))
+	if tr > 0 {
+		fmt.Fprintf(buf, "A.5 .5 0 0 1 %s %s", num(fx+1), num(fy+tr))
+	}
+	fmt.Fprintf(buf, "V%s", num(fy+1-br))
+	if br > 0 {
+		fmt.Fprintf(buf, "A.5 .5 0 0 1 %s %s", num(fx+1-br), num(fy+1))
+	}
+	fmt.Fprintf(buf, "H%s", num(fx+bl))
+	if bl > 0 {
+		fmt.Fprintf(buf, "A.5 .5 0 0 1 %s %s", num(fx), num(fy+1-bl))
+	}
+	fmt.Fprintf(buf, "V%s", num(fy+tl))
+	if tl > 0 {
+		fmt.Fprintf(buf, "A.5 .5 0 0 1 %s %s", num(fx+tl), num(fy))
+	}
+	buf.WriteString("z")
+}
+
+func renderPDF(l *layout, opts Options) ([]byte, error) {
+	module := float64(opts.Size) / float64(l.total)
+	doc := pdf.New()
+	page := doc.AddPage(float64(opts.Size), (float64(l.total)+l.caption)*module)
+	drawPDF(page, 0, 0, float64(opts.Size), l, opts)
+	return doc.Bytes()
+}
+
+// DrawPDF рисует QR-код на странице PDF в квадрате со стороной size
+// и левым верхним углом (x, y). Подпись, если задана, рисуется под
+// квадратом. opts.Format и opts.Size не используются.
+func DrawPDF(page *pdf.Page, x, y, size float64, content string, opts Options) error {
+	opts = opts.withDefaults()
+	modules, err := bitmap(content, opts.Level)
+	if err != nil {
+		return err
+	}
+	drawPDF(page, x, y, size, newLayout(modules, opts), opts)
+	return nil
+}
+
+func drawPDF(page *pdf.Page, left, top, size float64, l *layout, opts Options) {
+	module := size / float64(l.total)
+	setFill(page, opts.Background)
+	page.Rect(left, top, size, (float64(l.total)+l.caption)*module)
+	page.Fill()
+
+	setFill(page, opts.Foreground)
+	for y := 0; y < l.total; y++ {
+		for x := 0; x < l.total; x++ {
+			if !l.dark(x, y) {
+				continue
+			}
+			mx, my := left+float64(x)*module, top+float64(y)*module
+			if !opts.Rounded {
+				page.Rect(mx, my, module, module)
+				continue
+			}
+			pdfModule(page, mx, my, module, l.corners(x, y))
+		}
+	}
+	page.Fill()
+
+	if l.hasLogo {
+		logo := image.NewRGBA(opts.Logo.Bounds())
+		fill(logo, logo.Bounds(), opts.Background)
+		drawLogo(logo, logo.Bounds(), opts.Logo)
+		x, y, w, h := fitRect(opts.Logo.Bounds(), l.logo, module)
+		page.Image(logo, left+x, top+y, w, h)
+	}
+	if opts.Caption != "" {
+		textSize := l.caption * 0.6 * module
+		width := pdf.TextWidth(opts.Caption, textSize)
+		setFill(page, opts.Foreground)
+		page.Text(left+(size-width)/2, top+(float64(l.total)+l.caption*0.7)*module, textSize, opts.Caption)
+	}
+}
+
+// kappa — смещение контрольных точек кривой Безье, приближающей четверть
+// окружности единичного радиуса.
+const kappa = 0.5523
+
+func pdfModule(page *pdf.Page, x, y, size float64, corners [4]bool) {
+	r := func(rounded bool) float64 {
+		if rounded {
+			return size / 2
+		}
+		return 0
+	}
+	tl, tr, br, bl := r(corners[0]), r(corners[1]), r(corners[2]), r(corners[3])
+	page.MoveTo(x+tl, y)
+	page.LineTo(x+size-tr, y)
+	if tr > 0 {
+		page.CurveTo(x+size-tr+tr*kappa, y, x+size, y+tr-tr*kappa, x+size, y+tr)
+	}
+	page.LineTo(x+size, y+size-br)
+	if br > 0 {
+		page.CurveTo(x+size, y+size-br+br*kappa, x+size-br+br*kappa, y+size, x+size-br, y+size)
+	}
+	page.LineTo(x+bl, y+size)
+	if bl > 0 {
+		page.CurveTo(x+bl-bl*kappa, y+size, x, y+size-bl+bl*kappa, x, y+size-bl)
+	}
+	page.LineTo(x, y+tl)
+	if tl > 0 {
+		page.CurveTo(x, y+tl-tl*kappa, x+tl-tl*kappa, y, x+tl, y)
+	}
+	page.ClosePath()
+}
+
+func setFill(page *pdf.Page, c color.RGBA) {
+	page.SetFill(c.R, c.G, c.B)
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func num(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", v), "0"), ".")
+}
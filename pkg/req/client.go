@@ -0,0 +1,94 @@
+package req
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type contextKey string
+
+const clientIPKey contextKey = "clientIP"
+
+// TrustProxies возвращает middleware, которое определяет адрес клиента для
+// ClientIP. Только запросам от обратных прокси из prefixes оно верит в
+// X-Forwarded-For и X-Real-IP: остальные клиенты могут подставить в
+// заголовок любой адрес.
+func TrustProxies(prefixes []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIPKey, resolveClientIP(r, prefixes))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP возвращает адрес клиента, определенный TrustProxies. Без него —
+// адрес соединения.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// resolveClientIP берет адрес из X-Forwarded-For (первый справа, который не
+// является доверенным прокси) или X-Real-IP, если запрос пришел от
+// доверенного прокси, иначе — адрес соединения.
+func resolveClientIP(r *http.Request, prefixes []netip.Prefix) string {
+	host := remoteHost(r)
+	remote, err := netip.ParseAddr(host)
+	if err != nil || !trusted(prefixes, remote.Unmap()) {
+		return host
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		client := ""
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+			if err != nil {
+				break
+			}
+			client = ip.Unmap().String()
+			if !trusted(prefixes, ip.Unmap()) {
+				break
+			}
+		}
+		if client != "" {
+			return client
+		}
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
+
+func trusted(prefixes []netip.Prefix, ip netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Truncate обрезает значение из запроса (User-Agent, Referer и т.п.) до max
+// символов, чтобы оно поместилось в колонку.
+func Truncate(value string, max int) string {
+	runes := []rune(value)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+	return value
+}
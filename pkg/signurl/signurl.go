@@ -0,0 +1,87 @@
+package signurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissingSignature = errors.New("signature is missing")
+	ErrInvalidSignature = errors.New("signature is invalid")
+	ErrExpired          = errors.New("signed url has expired")
+)
+
+// Signer подписывает пути HMAC-SHA256. Подпись привязана к пути и сроку
+// действия, которые передаются в параметрах expires и signature.
+type Signer struct {
+	secret []byte
+}
+
+func NewSigner(secret string) *Signer {
+	return &Signer{
+		secret: []byte(secret),
+	}
+}
+
+// Sign возвращает path с параметрами expires и signature.
+func (s *Signer) Sign(path string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.signature(path, expires))
+	return path + "?" + query.Encode()
+}
+
+// Verify проверяет подпись path по параметрам запроса.
+func (s *Signer) Verify(path string, query url.Values) error {
+	expires := query.Get("expires")
+	signature := query.Get("signature")
+	if expires == "" || signature == "" {
+		return ErrMissingSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.signature(path, expires))) {
+		return ErrInvalidSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if time.Now().After(time.Unix(unix, 0)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Seal возвращает payload с подписью без срока действия. Подходит для
+// долгоживущих ссылок вроде напечатанных QR-кодов; отзывать их нужно
+// сменой payload.
+func (s *Signer) Seal(payload string) string {
+	return payload + "." + s.signature(payload, "")
+}
+
+// Open проверяет подпись токена из Seal и возвращает payload.
+func (s *Signer) Open(token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", ErrMissingSignature
+	}
+	payload, signature := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.signature(payload, ""))) {
+		return "", ErrInvalidSignature
+	}
+	return payload, nil
+}
+
+func (s *Signer) signature(path, expires string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
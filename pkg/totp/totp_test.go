@@ -0,0 +1,100 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret — ключ SHA1 из приложения B RFC 6238 ("12345678901234567890").
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+// Векторы из RFC 6238 для SHA1; коды в RFC из 8 цифр, здесь — последние 6.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCodeRFC6238(t *testing.T) {
+	for _, vector := range rfcVectors {
+		code, err := Code(rfcSecret, Step(time.Unix(vector.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", vector.unix, err)
+		}
+		if code != vector.code {
+			t.Errorf("Code(%d) = %s, want %s", vector.unix, code, vector.code)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, err := Code(rfcSecret, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := Code(" "+strings.ToLower(rfcSecret)+" ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower {
+		t.Errorf("lowercase secret: got %s, want %s", lower, upper)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("expected error for invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	previous, _ := Code(rfcSecret, step-1)
+	tooOld, _ := Code(rfcSecret, step-2)
+
+	tests := []struct {
+		name     string
+		code     string
+		after    int64
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current", "050471", 0, step, true},
+		{"previous within skew", previous, 0, step - 1, true},
+		{"outside skew", tooOld, 0, 0, false},
+		{"replayed step", "050471", step, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"wrong length", "05047", 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gotStep, ok := Validate(rfcSecret, test.code, now, 1, test.after)
+			if ok != test.wantOK || gotStep != test.wantStep {
+				t.Errorf("Validate = (%d, %v), want (%d, %v)", gotStep, ok, test.wantStep, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret is not base32: %v", err)
+	}
+	if len(raw) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(raw))
+	}
+}